@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const minPasswordLength = 8
+
+// currentUser loads the authenticated user for the /api/me endpoints
+func currentUser(c *gin.Context, db *gorm.DB) (models.User, bool) {
+	var user models.User
+	if err := db.First(&user, c.MustGet("user_id").(uint)).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return user, false
+	}
+	return user, true
+}
+
+// GetMe returns the current user's profile
+func GetMe(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := currentUser(c, db)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+// UpdateMe lets users edit their own contact details. With apply_to_domains
+// the new details also replace the registrant contact of all their personal
+// (not organization-owned) domains.
+func UpdateMe(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireSession(c) {
+			return
+		}
+		user, ok := currentUser(c, db)
+		if !ok {
+			return
+		}
+
+		var input struct {
+			ContactName    string `json:"contact_name"`
+			ContactOrg     string `json:"contact_org"`
+			ContactEmail   string `json:"contact_email"`
+			ContactPhone   string `json:"contact_phone"`
+			ContactAddress string `json:"contact_address"`
+			ContactCity    string `json:"contact_city"`
+			ContactState   string `json:"contact_state"`
+			ContactZip     string `json:"contact_zip"`
+			ContactCountry string `json:"contact_country"`
+			ApplyToDomains bool   `json:"apply_to_domains"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		before := user
+		user.ContactName = input.ContactName
+		user.ContactOrg = input.ContactOrg
+		user.ContactEmail = input.ContactEmail
+		user.ContactPhone = input.ContactPhone
+		user.ContactAddress = input.ContactAddress
+		user.ContactCity = input.ContactCity
+		user.ContactState = input.ContactState
+		user.ContactZip = input.ContactZip
+		user.ContactCountry = input.ContactCountry
+		emailChanged := user.ContactEmail != before.ContactEmail
+		if emailChanged {
+			user.EmailVerified = false
+		}
+
+		var domainsUpdated int64
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Save(&user).Error; err != nil {
+				return err
+			}
+			if !input.ApplyToDomains {
+				return nil
+			}
+			result := tx.Model(&models.Domain{}).Where("user_id = ? AND organization_id IS NULL", user.ID).Updates(map[string]interface{}{
+				"registrant_name":    user.ContactName,
+				"registrant_org":     user.ContactOrg,
+				"registrant_email":   user.ContactEmail,
+				"registrant_phone":   user.ContactPhone,
+				"registrant_address": user.ContactAddress,
+				"registrant_city":    user.ContactCity,
+				"registrant_state":   user.ContactState,
+				"registrant_zip":     user.ContactZip,
+				"registrant_country": user.ContactCountry,
+			})
+			domainsUpdated = result.RowsAffected
+			return result.Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save profile: " + err.Error()})
+			return
+		}
+
+		if emailChanged && user.ContactEmail != "" {
+			if err := sendVerificationEmail(db, user); err != nil {
+				log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+			}
+		}
+
+		audit(c, "user.profile_update", "user", user.ID, before, user)
+		c.JSON(http.StatusOK, gin.H{"user": user, "domains_updated": domainsUpdated})
+	}
+}
+
+// ChangePassword changes the current user's password after checking the
+// current one, and signs out every other session
+func ChangePassword(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireSession(c) {
+			return
+		}
+		var input struct {
+			CurrentPassword string `json:"current_password" binding:"required"`
+			NewPassword     string `json:"new_password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		user, ok := currentUser(c, db)
+		if !ok {
+			return
+		}
+		if user.AuthSource != "" && user.AuthSource != "local" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Password is managed by your identity provider"})
+			return
+		}
+		if len(input.NewPassword) < minPasswordLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "New password must be at least 8 characters"})
+			return
+		}
+
+		// Guessing the current password with a stolen session counts as a failed login
+		if throttled(c, map[*throttle]string{userThrottle: userThrottleKey(user.Username)}) {
+			return
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(input.CurrentPassword)); err != nil {
+			recordLoginFailure(db, c, user.Username)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+			return
+		}
+		userThrottle.reset(userThrottleKey(user.Username))
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), 14)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+		if err := db.Model(&models.User{}).Where("id = ?", user.ID).Update("password_hash", string(hash)).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password: " + err.Error()})
+			return
+		}
+
+		revoked, _ := revokeSessions(db, user.ID, c.MustGet("session_id").(uint))
+		audit(c, "user.password_change", "user", user.ID, nil, gin.H{"sessions_revoked": revoked})
+		c.JSON(http.StatusOK, gin.H{"message": "Password changed", "sessions_revoked": revoked})
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUpdateMeAppliesContactToPersonalDomains(t *testing.T) {
+	db := newTestDB(t)
+	out := useOutbox(t)
+	user := models.User{Username: "alice", Role: "user", ContactEmail: "alice@corp.lan", EmailVerified: true}
+	db.Create(&user)
+	org := models.Organization{Name: "Ops"}
+	db.Create(&org)
+	personal := models.Domain{Name: "alice.lan", UserID: user.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0)}
+	shared := models.Domain{Name: "ops.lan", UserID: user.ID, OrganizationID: &org.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0),
+		RegistrantEmail: "ops@corp.lan"}
+	db.Create(&personal)
+	db.Create(&shared)
+
+	r := signedIn(user)
+	r.PUT("/api/me", UpdateMe(db))
+	w := serve(r, jsonRequest(http.MethodPut, "/api/me", `{"contact_name":"Alice","contact_email":"alice@home.lan","apply_to_domains":true}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("update profile: %d %s", w.Code, w.Body)
+	}
+
+	var stored models.User
+	db.First(&stored, user.ID)
+	if stored.ContactEmail != "alice@home.lan" || stored.EmailVerified {
+		t.Errorf("expected the new address unverified, got %q verified=%v", stored.ContactEmail, stored.EmailVerified)
+	}
+	if sent := out.sent(); len(sent) != 1 || sent[0].To != "alice@home.lan" {
+		t.Errorf("expected a verification mail to the new address, got %+v", sent)
+	}
+	db.First(&personal, personal.ID)
+	db.First(&shared, shared.ID)
+	if personal.RegistrantName != "Alice" || personal.RegistrantEmail != "alice@home.lan" {
+		t.Errorf("personal domain registrant not updated: %q %q", personal.RegistrantName, personal.RegistrantEmail)
+	}
+	if shared.RegistrantEmail != "ops@corp.lan" {
+		t.Errorf("organization domain registrant changed to %q", shared.RegistrantEmail)
+	}
+
+	keyed := withAPIKey(user, "domains:write")
+	keyed.PUT("/api/me", UpdateMe(db))
+	if w := serve(keyed, jsonRequest(http.MethodPut, "/api/me", `{"contact_name":"Mallory"}`)); w.Code != http.StatusForbidden {
+		t.Errorf("API key profile update: expected 403, got %d", w.Code)
+	}
+}
+
+func TestChangePasswordChecksTheCurrentOneAndSignsOutElsewhere(t *testing.T) {
+	db := newTestDB(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("old-password"), bcrypt.MinCost)
+	user := models.User{Username: "alice", Role: "user", AuthSource: "local", PasswordHash: string(hash)}
+	sso := models.User{Username: "bob", Role: "user", AuthSource: "oidc"}
+	db.Create(&user)
+	db.Create(&sso)
+	t.Cleanup(func() { userThrottle.reset(userThrottleKey(user.Username)) })
+
+	r := gin.New()
+	api := r.Group("/api", AuthMiddleware(db))
+	api.POST("/me/password", ChangePassword(db))
+	api.GET("/domains", ListDomains(db))
+	current, _ := signIn(t, db, user)
+	other, _ := signIn(t, db, user)
+	ssoAccess, _ := signIn(t, db, sso)
+
+	change := func(token, currentPassword, newPassword string) int {
+		req := jsonRequest(http.MethodPost, "/api/me/password",
+			fmt.Sprintf(`{"current_password":%q,"new_password":%q}`, currentPassword, newPassword))
+		req.Header.Set("Authorization", "Bearer "+token)
+		return serve(r, req).Code
+	}
+	if code := change(current, "wrong-password", "new-password"); code != http.StatusUnauthorized {
+		t.Errorf("wrong current password: expected 401, got %d", code)
+	}
+	if code := change(current, "old-password", "short"); code != http.StatusBadRequest {
+		t.Errorf("short new password: expected 400, got %d", code)
+	}
+	if code := change(ssoAccess, "anything", "new-password"); code != http.StatusBadRequest {
+		t.Errorf("single sign-on account: expected 400, got %d", code)
+	}
+	if code := change(current, "old-password", "new-password"); code != http.StatusOK {
+		t.Fatalf("change password: %d", code)
+	}
+
+	var stored models.User
+	db.First(&stored, user.ID)
+	if bcrypt.CompareHashAndPassword([]byte(stored.PasswordHash), []byte("new-password")) != nil {
+		t.Error("new password not stored")
+	}
+	if code := authorized(r, http.MethodGet, "/api/domains", current); code != http.StatusOK {
+		t.Errorf("the session that changed the password was signed out: %d", code)
+	}
+	if code := authorized(r, http.MethodGet, "/api/domains", other); code != http.StatusUnauthorized {
+		t.Errorf("other session still signed in: %d", code)
+	}
+}
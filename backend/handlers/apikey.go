@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+const apiKeyPrefix = "ldns_"
+
+// apiKeyScopes lists the scopes an API key can hold. Scopes marked true may be
+// narrowed to a single domain by appending ":<domain>" (e.g. records:write:corp.lan).
+var apiKeyScopes = map[string]bool{
+	"domains:read":        true,
+	"domains:write":       true,
+	"records:read":        true,
+	"records:write":       true,
+	"templates:read":      false,
+	"templates:write":     false,
+	"organizations:read":  false,
+	"organizations:write": false,
+	"users:admin":         false,
+	"whois:admin":         false,
+	"audit:read":          false,
+}
+
+// adminScopes can only be granted to users whose role holds the mapped
+// permission
+var adminScopes = map[string]string{
+	"templates:write": "template.write",
+	"users:admin":     "user.manage",
+	"whois:admin":     "config.write",
+	"audit:read":      "audit.read",
+}
+
+// validateScope checks a requested scope against the catalogue
+func validateScope(scope string, held []string) error {
+	base, domain := scope, ""
+	if parts := strings.SplitN(scope, ":", 3); len(parts) == 3 {
+		base, domain = parts[0]+":"+parts[1], parts[2]
+	}
+	perDomain, known := apiKeyScopes[base]
+	if !known {
+		return fmt.Errorf("unknown scope %q", scope)
+	}
+	if domain != "" && !perDomain {
+		return fmt.Errorf("scope %q cannot be restricted to a domain", base)
+	}
+	if perm, admin := adminScopes[base]; admin && !permits(held, perm) {
+		return fmt.Errorf("scope %q requires the %s permission", base, perm)
+	}
+	return nil
+}
+
+// scopeGranted reports whether the held scopes cover scope on domain.
+// Write scopes imply the matching read scope.
+func scopeGranted(held []string, scope, domain string) bool {
+	candidates := []string{scope}
+	if strings.HasSuffix(scope, ":read") {
+		candidates = append(candidates, strings.TrimSuffix(scope, ":read")+":write")
+	}
+	for _, s := range held {
+		for _, want := range candidates {
+			if s == want || (domain != "" && s == want+":"+strings.ToLower(domain)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requireScope enforces API key scopes. Requests authenticated with a login
+// session are not scope-restricted. Pass domain "" for scopes that are not
+// tied to a domain. Writes a 403 response and returns false when denied.
+func requireScope(c *gin.Context, scope, domain string) bool {
+	held, ok := c.Get("scopes")
+	if !ok {
+		return true
+	}
+	if scopeGranted(held.([]string), scope, domain) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks required scope: " + scope})
+	return false
+}
+
+// authenticateAPIKey validates an API key and loads its user into the context
+func authenticateAPIKey(db *gorm.DB, c *gin.Context, key string) bool {
+	var apiKey models.APIKey
+	if err := db.Where("key_hash = ?", hashToken(key)).First(&apiKey).Error; err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		return false
+	}
+	if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API key expired"})
+		return false
+	}
+	if len(apiKey.AllowedCIDRs) > 0 {
+		ip := net.ParseIP(c.ClientIP())
+		allowed := false
+		for _, cidr := range apiKey.AllowedCIDRs {
+			if _, network, err := net.ParseCIDR(cidr); err == nil && ip != nil && network.Contains(ip) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key not allowed from this address"})
+			return false
+		}
+	}
+
+	var user models.User
+	if err := db.First(&user, apiKey.UserID).Error; err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User no longer exists"})
+		return false
+	}
+	if message := accountStatusError(user); message != "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": message})
+		return false
+	}
+
+	now := time.Now()
+	db.Model(&models.APIKey{}).Where("id = ?", apiKey.ID).Update("last_used_at", &now)
+
+	c.Set("user_id", user.ID)
+	c.Set("role", user.Role)
+	c.Set("api_key_id", apiKey.ID)
+	c.Set("scopes", []string(apiKey.Scopes))
+	return true
+}
+
+// requireSession rejects requests made with an API key or an impersonation session
+func requireSession(c *gin.Context) bool {
+	if _, ok := c.Get("api_key_id"); ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint requires an interactive login"})
+		return false
+	}
+	if _, ok := c.Get("impersonator_id"); ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint is not available while impersonating"})
+		return false
+	}
+	return true
+}
+
+// ListAPIKeys returns the caller's API keys
+func ListAPIKeys(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireSession(c) {
+			return
+		}
+		userID := c.MustGet("user_id").(uint)
+
+		var keys []models.APIKey
+		db.Where("user_id = ?", userID).Order("created_at DESC").Find(&keys)
+		c.JSON(http.StatusOK, keys)
+	}
+}
+
+// CreateAPIKey issues a new API key. The plain key is only returned here.
+func CreateAPIKey(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireSession(c) {
+			return
+		}
+		userID := c.MustGet("user_id").(uint)
+		held := rolePermissions(c, db)
+
+		var input struct {
+			Name         string     `json:"name" binding:"required"`
+			Scopes       []string   `json:"scopes" binding:"required"`
+			AllowedCIDRs []string   `json:"allowed_cidrs"`
+			ExpiresAt    *time.Time `json:"expires_at"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(input.Scopes) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required"})
+			return
+		}
+		scopes := make(models.StringList, 0, len(input.Scopes))
+		for _, scope := range input.Scopes {
+			scope = strings.ToLower(strings.TrimSpace(scope))
+			if err := validateScope(scope, held); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			scopes = append(scopes, scope)
+		}
+		cidrs := make(models.StringList, 0, len(input.AllowedCIDRs))
+		for _, cidr := range input.AllowedCIDRs {
+			if _, _, err := net.ParseCIDR(strings.TrimSpace(cidr)); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid CIDR %q", cidr)})
+				return
+			}
+			cidrs = append(cidrs, strings.TrimSpace(cidr))
+		}
+		if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+			return
+		}
+
+		secret, err := generateToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate key"})
+			return
+		}
+		key := apiKeyPrefix + secret
+
+		apiKey := models.APIKey{
+			UserID:       userID,
+			Name:         input.Name,
+			Prefix:       key[:len(apiKeyPrefix)+6],
+			KeyHash:      hashToken(key),
+			Scopes:       scopes,
+			AllowedCIDRs: cidrs,
+			ExpiresAt:    input.ExpiresAt,
+		}
+		if err := db.Create(&apiKey).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key: " + err.Error()})
+			return
+		}
+		audit(c, "api_key.create", "api_key", apiKey.ID, nil, apiKey)
+
+		c.JSON(http.StatusCreated, gin.H{"key": key, "api_key": apiKey})
+	}
+}
+
+// DeleteAPIKey revokes one of the caller's API keys (admins may revoke any key)
+func DeleteAPIKey(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireSession(c) {
+			return
+		}
+		userID := c.MustGet("user_id").(uint)
+
+		var apiKey models.APIKey
+		if result := db.First(&apiKey, c.Param("keyId")); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		if apiKey.UserID != userID && !can(c, db, "user.manage") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+
+		db.Delete(&apiKey)
+		audit(c, "api_key.delete", "api_key", apiKey.ID, apiKey, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+// issueKey stores an API key for user and returns the plain key
+func issueKey(t *testing.T, db *gorm.DB, user models.User, scopes []string, cidrs ...string) string {
+	t.Helper()
+	key := fmt.Sprintf("%s%s-%d", apiKeyPrefix, t.Name(), time.Now().UnixNano())
+	if err := db.Create(&models.APIKey{UserID: user.ID, Name: "test", Prefix: key[:8], KeyHash: hashToken(key),
+		Scopes: scopes, AllowedCIDRs: cidrs}).Error; err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	return key
+}
+
+// apiRouter serves a few domain, record and admin routes behind AuthMiddleware
+func apiRouter(db *gorm.DB) *gin.Engine {
+	r := gin.New()
+	api := r.Group("/api", AuthMiddleware(db))
+	api.GET("/domains/:id", GetDomain(db))
+	api.GET("/domains/:id/records", ListRecords(db))
+	api.POST("/domains/:id/records", AddRecord(db))
+	api.GET("/users", ListUsers(db))
+	api.GET("/audit", ListAuditLogs(db))
+	return r
+}
+
+func TestAPIKeyScopesLimitRoutes(t *testing.T) {
+	db := newTestDB(t)
+	admin := models.User{Username: "admin", Role: "admin"}
+	db.Create(&admin)
+	domain := models.Domain{Name: "corp.lan", UserID: admin.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0)}
+	other := models.Domain{Name: "other.lan", UserID: admin.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0)}
+	db.Create(&domain)
+	db.Create(&other)
+	r := apiRouter(db)
+
+	record := `{"name":"www","type":"A","content":"192.0.2.1"}`
+	tests := []struct {
+		scopes []string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{[]string{"records:read"}, http.MethodGet, fmt.Sprintf("/api/domains/%d", domain.ID), "", http.StatusForbidden},
+		{[]string{"domains:read"}, http.MethodGet, fmt.Sprintf("/api/domains/%d", domain.ID), "", http.StatusOK},
+		{[]string{"domains:read"}, http.MethodGet, fmt.Sprintf("/api/domains/%d/records", domain.ID), "", http.StatusForbidden},
+		{[]string{"records:read"}, http.MethodPost, fmt.Sprintf("/api/domains/%d/records", domain.ID), record, http.StatusForbidden},
+		{[]string{"records:write:corp.lan"}, http.MethodGet, fmt.Sprintf("/api/domains/%d/records", domain.ID), "", http.StatusOK},
+		{[]string{"records:write:corp.lan"}, http.MethodGet, fmt.Sprintf("/api/domains/%d/records", other.ID), "", http.StatusForbidden},
+		{[]string{"records:write:corp.lan"}, http.MethodPost, fmt.Sprintf("/api/domains/%d/records", domain.ID), record, http.StatusCreated},
+		{[]string{"domains:write", "records:write"}, http.MethodGet, "/api/users", "", http.StatusForbidden},
+		{[]string{"users:admin"}, http.MethodGet, "/api/users", "", http.StatusOK},
+		{[]string{"users:admin"}, http.MethodGet, "/api/audit", "", http.StatusForbidden},
+		{[]string{"audit:read"}, http.MethodGet, "/api/audit", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := jsonRequest(tt.method, tt.path, tt.body)
+		req.Header.Set("Authorization", "Bearer "+issueKey(t, db, admin, tt.scopes))
+		if w := serve(r, req); w.Code != tt.want {
+			t.Errorf("%s %s with %v: expected %d, got %d %s", tt.method, tt.path, tt.scopes, tt.want, w.Code, w.Body)
+		}
+	}
+}
+
+func TestAPIKeyAdminScopesFollowPermissions(t *testing.T) {
+	db := newTestDB(t)
+	admin := models.User{Username: "admin", Role: "admin"}
+	user := models.User{Username: "alice", Role: "user"}
+	db.Create(&admin)
+	db.Create(&user)
+
+	tests := []struct {
+		user  models.User
+		scope string
+		want  int
+	}{
+		{user, "records:write:corp.lan", http.StatusCreated},
+		{user, "users:admin", http.StatusBadRequest},
+		{user, "audit:read", http.StatusBadRequest},
+		{user, "users:admin:corp.lan", http.StatusBadRequest},
+		{admin, "users:admin", http.StatusCreated},
+		{admin, "nonsense:read", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := signedIn(tt.user)
+		r.POST("/api/keys", CreateAPIKey(db))
+		w := serve(r, jsonRequest(http.MethodPost, "/api/keys", `{"name":"ci","scopes":["`+tt.scope+`"]}`))
+		if w.Code != tt.want {
+			t.Errorf("%s asking for %s: expected %d, got %d %s", tt.user.Username, tt.scope, tt.want, w.Code, w.Body)
+		}
+	}
+}
+
+func TestAPIKeyAllowedCIDRs(t *testing.T) {
+	db := newTestDB(t)
+	user := models.User{Username: "alice", Role: "user"}
+	db.Create(&user)
+	domain := models.Domain{Name: "corp.lan", UserID: user.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0)}
+	db.Create(&domain)
+	r := apiRouter(db)
+
+	tests := []struct {
+		cidrs  []string
+		remote string
+		want   int
+	}{
+		{nil, "203.0.113.7:4000", http.StatusOK},
+		{[]string{"192.0.2.0/24"}, "192.0.2.10:4000", http.StatusOK},
+		{[]string{"192.0.2.0/24"}, "203.0.113.7:4000", http.StatusForbidden},
+		{[]string{"192.0.2.0/24", "2001:db8::/32"}, "[2001:db8::1]:4000", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := jsonRequest(http.MethodGet, fmt.Sprintf("/api/domains/%d", domain.ID), "")
+		req.RemoteAddr = tt.remote
+		req.Header.Set("Authorization", "Bearer "+issueKey(t, db, user, []string{"domains:read"}, tt.cidrs...))
+		if w := serve(r, req); w.Code != tt.want {
+			t.Errorf("key for %v from %s: expected %d, got %d %s", tt.cidrs, tt.remote, tt.want, w.Code, w.Body)
+		}
+	}
+}
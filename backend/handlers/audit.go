@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+const auditEventsKey = "audit_events"
+
+// audit queues an audit log entry for the current request. Entries are
+// persisted by AuditMiddleware once the handler has completed successfully.
+// before and after may be nil; the stored diff only contains changed fields.
+func audit(c *gin.Context, action, targetType string, targetID interface{}, before, after interface{}) {
+	entry := models.AuditLog{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   fmt.Sprint(targetID),
+		Diff:       jsonDiff(before, after),
+	}
+	events, _ := c.Get(auditEventsKey)
+	queued, _ := events.([]models.AuditLog)
+	c.Set(auditEventsKey, append(queued, entry))
+}
+
+// writeAudit persists an audit entry immediately, for events that must be
+// recorded even when the request itself fails (e.g. failed logins).
+func writeAudit(db *gorm.DB, entry models.AuditLog) {
+	if err := db.Create(&entry).Error; err != nil {
+		log.Printf("Failed to write audit log (%s): %v", entry.Action, err)
+	}
+}
+
+// AuditMiddleware writes the audit entries queued by handlers, stamped with
+// the acting user, impersonating admin and client IP. Nothing is written for
+// failed requests, except the record of each impersonated change.
+func AuditMiddleware(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		var actor, impersonator uint
+		if id, ok := c.Get("user_id"); ok {
+			actor = id.(uint)
+		}
+		if id, ok := c.Get("impersonator_id"); ok {
+			impersonator = id.(uint)
+		}
+
+		// Every change made while impersonating is recorded, even when it fails
+		if impersonator != 0 && c.Request.Method != http.MethodGet {
+			writeAudit(db, models.AuditLog{
+				ActorID:        actor,
+				ImpersonatorID: impersonator,
+				Action:         "impersonation.request",
+				TargetType:     "user",
+				TargetID:       fmt.Sprint(actor),
+				IP:             c.ClientIP(),
+				Diff:           jsonDiff(nil, gin.H{"method": c.Request.Method, "path": c.Request.URL.Path, "status": c.Writer.Status()}),
+			})
+		}
+
+		events, ok := c.Get(auditEventsKey)
+		if !ok || c.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+		for _, entry := range events.([]models.AuditLog) {
+			entry.ActorID = actor
+			entry.ImpersonatorID = impersonator
+			entry.IP = c.ClientIP()
+			writeAudit(db, entry)
+		}
+	}
+}
+
+// toFieldMap converts a value into its JSON object representation
+func toFieldMap(v interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return fields
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// jsonDiff returns a JSON object of the fields that differ between before and after
+func jsonDiff(before, after interface{}) models.JSONText {
+	old, cur := toFieldMap(before), toFieldMap(after)
+	diff := map[string]interface{}{}
+	for key, value := range old {
+		if !reflect.DeepEqual(value, cur[key]) {
+			diff[key] = gin.H{"old": value, "new": cur[key]}
+		}
+	}
+	for key, value := range cur {
+		if _, seen := old[key]; !seen {
+			diff[key] = gin.H{"old": nil, "new": value}
+		}
+	}
+	if len(diff) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(diff)
+	if err != nil {
+		return ""
+	}
+	return models.JSONText(data)
+}
+
+// ListAuditLogs queries the audit log (admin only).
+// Filters: actor_id, impersonator_id, action, target_type, target_id, ip, since, until.
+// ?format=csv exports the result as a CSV attachment.
+func ListAuditLogs(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "audit.read", "audit:read") {
+			return
+		}
+
+		query := db.Model(&models.AuditLog{})
+		for _, field := range []string{"actor_id", "impersonator_id", "action", "target_type", "target_id", "ip"} {
+			if value := c.Query(field); value != "" {
+				query = query.Where(field+" = ?", value)
+			}
+		}
+		if since := c.Query("since"); since != "" {
+			t, err := parseTimestamp(since)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'since' timestamp"})
+				return
+			}
+			query = query.Where("created_at >= ?", t)
+		}
+		if until := c.Query("until"); until != "" {
+			t, err := parseTimestamp(until)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'until' timestamp"})
+				return
+			}
+			query = query.Where("created_at <= ?", t)
+		}
+
+		limit := 500
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 10000 {
+			limit = l
+		}
+		offset, _ := strconv.Atoi(c.Query("offset"))
+
+		var entries []models.AuditLog
+		if result := query.Order("id DESC").Limit(limit).Offset(offset).Find(&entries); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+			return
+		}
+
+		switch c.DefaultQuery("format", "json") {
+		case "csv":
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			c.Header("Content-Disposition", "attachment; filename=audit-log.csv")
+			w := csv.NewWriter(c.Writer)
+			w.Write([]string{"id", "created_at", "actor_id", "impersonator_id", "action", "target_type", "target_id", "ip", "diff"})
+			for _, e := range entries {
+				w.Write([]string{
+					strconv.FormatUint(uint64(e.ID), 10),
+					e.CreatedAt.Format(time.RFC3339),
+					strconv.FormatUint(uint64(e.ActorID), 10),
+					strconv.FormatUint(uint64(e.ImpersonatorID), 10),
+					e.Action,
+					e.TargetType,
+					e.TargetID,
+					e.IP,
+					string(e.Diff),
+				})
+			}
+			w.Flush()
+		case "json":
+			if c.Query("download") != "" {
+				c.Header("Content-Disposition", "attachment; filename=audit-log.json")
+			}
+			c.JSON(http.StatusOK, entries)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		}
+	}
+}
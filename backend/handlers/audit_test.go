@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+)
+
+func TestAuditLogIsAppendOnly(t *testing.T) {
+	db := newTestDB(t)
+	entry := models.AuditLog{Action: "user.create", TargetType: "user", TargetID: "1"}
+	if err := db.Create(&entry).Error; err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	if err := db.Model(&entry).Update("action", "user.delete").Error; !errors.Is(err, models.ErrAuditLogImmutable) {
+		t.Errorf("update: expected ErrAuditLogImmutable, got %v", err)
+	}
+	if err := db.Model(&models.AuditLog{}).Where("id = ?", entry.ID).Update("ip", "192.0.2.1").Error; !errors.Is(err, models.ErrAuditLogImmutable) {
+		t.Errorf("bulk update: expected ErrAuditLogImmutable, got %v", err)
+	}
+	if err := db.Delete(&entry).Error; !errors.Is(err, models.ErrAuditLogImmutable) {
+		t.Errorf("delete: expected ErrAuditLogImmutable, got %v", err)
+	}
+	var stored models.AuditLog
+	db.First(&stored, entry.ID)
+	if stored.Action != "user.create" || stored.IP != "" {
+		t.Errorf("entry changed: %+v", stored)
+	}
+}
+
+func TestAuditMiddlewareOnlyRecordsSuccessfulRequests(t *testing.T) {
+	db := newTestDB(t)
+	admin := models.User{Username: "admin", Role: "admin"}
+	db.Create(&admin)
+
+	r := signedIn(admin)
+	r.Use(AuditMiddleware(db))
+	r.POST("/ok", func(c *gin.Context) {
+		audit(c, "config.update", "config", 1, gin.H{"grace": 30, "mode": "open"}, gin.H{"grace": 45, "mode": "open"})
+		c.Status(http.StatusOK)
+	})
+	r.POST("/fail", func(c *gin.Context) {
+		audit(c, "config.update", "config", 1, nil, gin.H{"grace": 10})
+		c.Status(http.StatusBadRequest)
+	})
+	serve(r, jsonRequest(http.MethodPost, "/ok", ""))
+	serve(r, jsonRequest(http.MethodPost, "/fail", ""))
+
+	var entries []models.AuditLog
+	db.Find(&entries)
+	if len(entries) != 1 {
+		t.Fatalf("expected one entry, got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry.ActorID != admin.ID || entry.IP != "192.0.2.1" || entry.TargetID != "1" {
+		t.Errorf("entry not stamped with the request: %+v", entry)
+	}
+	var diff map[string]interface{}
+	json.Unmarshal([]byte(entry.Diff), &diff)
+	if _, ok := diff["mode"]; ok || diff["grace"] == nil {
+		t.Errorf("diff should hold only the changed field, got %s", entry.Diff)
+	}
+}
+
+func TestListAuditLogsFiltersAndExports(t *testing.T) {
+	db := newTestDB(t)
+	admin := models.User{Username: "admin", Role: "admin"}
+	db.Create(&admin)
+	old := time.Now().Add(-48 * time.Hour)
+	for _, entry := range []models.AuditLog{
+		{ActorID: admin.ID, Action: "user.create", TargetType: "user", TargetID: "2", CreatedAt: old},
+		{ActorID: admin.ID, Action: "user.delete", TargetType: "user", TargetID: "2"},
+		{ActorID: 7, Action: "domain.delete", TargetType: "domain", TargetID: "3", IP: "192.0.2.7"},
+	} {
+		db.Create(&entry)
+	}
+
+	r := signedIn(admin)
+	r.GET("/api/audit", ListAuditLogs(db))
+	since := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"domain.delete", "user.delete", "user.create"}},
+		{"?target_type=user", []string{"user.delete", "user.create"}},
+		{"?actor_id=7", []string{"domain.delete"}},
+		{"?ip=192.0.2.7", []string{"domain.delete"}},
+		{"?since=" + since, []string{"domain.delete", "user.delete"}},
+		{"?until=" + since, []string{"user.create"}},
+		{"?limit=1&offset=1", []string{"user.delete"}},
+	}
+	for _, tt := range tests {
+		w := serve(r, jsonRequest(http.MethodGet, "/api/audit"+tt.query, ""))
+		var entries []models.AuditLog
+		json.Unmarshal(w.Body.Bytes(), &entries)
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Action)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.want, got)
+		}
+	}
+
+	w := serve(r, jsonRequest(http.MethodGet, "/api/audit?format=csv&target_type=domain", ""))
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Errorf("CSV export served as %q", w.Header().Get("Content-Type"))
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("read CSV: %v", err)
+	}
+	if len(rows) != 2 || rows[0][4] != "action" || rows[1][4] != "domain.delete" || rows[1][7] != "192.0.2.7" {
+		t.Errorf("unexpected CSV: %v", rows)
+	}
+
+	for _, query := range []string{"?format=xml", "?since=yesterday"} {
+		if w := serve(r, jsonRequest(http.MethodGet, "/api/audit"+query, "")); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
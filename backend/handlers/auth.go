@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/localdns/backend/models"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
@@ -29,25 +32,113 @@ func Register(db *gorm.DB) gin.HandlerFunc {
 		var input struct {
 			Username string `json:"username" binding:"required"`
 			Password string `json:"password" binding:"required"`
+			Email    string `json:"email" binding:"required,email"`
+
+			// Required when registration is invite-only
+			InviteToken string `json:"invite_token"`
 		}
 		if err := c.ShouldBindJSON(&input); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
+		// Registration is public and bcrypt is expensive; every attempt counts
+		if throttled(c, map[*throttle]string{registerThrottle: c.ClientIP()}) {
+			return
+		}
+
+		var config models.RegistrarConfig
+		db.First(&config)
+		mode := registrationMode(config)
+
+		var invitation *models.Invitation
+		if input.InviteToken != "" {
+			found, err := findInvitation(db, input.InviteToken)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invitation is invalid or has expired"})
+				return
+			}
+			if !strings.EqualFold(found.Email, input.Email) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Register with the email address the invitation was sent to"})
+				return
+			}
+			invitation = &found
+		}
+		if mode == "closed" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Registration is disabled"})
+			return
+		}
+		if mode == "invite" && invitation == nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Registration requires an invitation"})
+			return
+		}
+
 		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(input.Password), 14)
 
 		user := models.User{
 			Username:     input.Username,
 			PasswordHash: string(hashedPassword),
+			Status:       "active",
+			ContactEmail: input.Email,
+		}
+		if invitation != nil {
+			// Invited users were vouched for by an admin, and the link reached this address
+			user.Role = invitation.Role
+			user.DomainQuota = invitation.DomainQuota
+			user.EmailVerified = true
+		} else if config.RequireApproval {
+			user.Status = "pending"
 		}
 
-		if result := db.Create(&user); result.Error != nil {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			if invitation == nil {
+				return nil
+			}
+			result := tx.Model(&models.Invitation{}).Where("id = ? AND accepted_at IS NULL", invitation.ID).
+				Updates(map[string]interface{}{"accepted_at": time.Now(), "accepted_by": user.ID})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errInvalidInvitation
+			}
+			return nil
+		})
+		if errors.Is(err, errInvalidInvitation) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invitation is invalid or has expired"})
+			return
+		}
+		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Username probably exists"})
 			return
 		}
 
-		c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully"})
+		if invitation != nil {
+			writeAudit(db, models.AuditLog{
+				ActorID:    user.ID,
+				Action:     "invitation.accept",
+				TargetType: "invitation",
+				TargetID:   fmt.Sprint(invitation.ID),
+				IP:         c.ClientIP(),
+				Diff:       jsonDiff(nil, gin.H{"user_id": user.ID, "username": user.Username, "role": user.Role}),
+			})
+			c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully.", "status": user.Status})
+			return
+		}
+
+		// The contact email ends up in WHOIS and is used for password resets, so confirm it
+		if err := sendVerificationEmail(db, user); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+		}
+
+		if user.Status == "pending" {
+			c.JSON(http.StatusCreated, gin.H{"message": "Registration received. Check your email to verify your address; you can log in once an admin has approved your account.", "status": user.Status})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully. Check your email to verify your address.", "status": user.Status})
 	}
 }
 
@@ -62,34 +153,52 @@ func Login(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		var user models.User
-		if result := db.Where("username = ?", input.Username).First(&user); result.Error != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		// Throttling and lockout are checked before any password hashing
+		if throttled(c, map[*throttle]string{ipThrottle: c.ClientIP(), userThrottle: userThrottleKey(input.Username)}) {
+			return
+		}
+		if until, locked := accountLocked(db, input.Username); locked {
+			c.JSON(http.StatusLocked, gin.H{"error": "Account is temporarily locked", "locked_until": until})
 			return
 		}
 
-		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(input.Password)); err != nil {
+		// Local accounts first, then any configured directory
+		user, err := authenticate(c, db, input.Username, input.Password)
+		if errors.Is(err, errSingleSignOn) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "This account signs in through single sign-on"})
+			return
+		}
+		if err != nil {
+			recordLoginFailure(db, c, input.Username)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 			return
 		}
+		if message := accountStatusError(user); message != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": message, "status": user.Status})
+			return
+		}
 
-		// Generate JWT
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"iss":  "localdns",
-			"sub":  user.ID,
-			"role": user.Role,
-			"exp":  time.Now().Add(time.Hour * 24).Unix(),
-		})
+		// Accounts with two-factor authentication get a limited token for /api/login/mfa instead
+		challenge, err := mfaChallenge(db, user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+			return
+		}
+		if challenge != nil {
+			// The password was right; the second factor is throttled on its own
+			ipThrottle.forgive(c.ClientIP())
+			c.JSON(http.StatusOK, challenge)
+			return
+		}
 
-		tokenString, err := token.SignedString(SecretKey)
+		// Start a server-side session with a short-lived access token and a refresh token
+		response, err := issueSession(db, c, user)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
 			return
 		}
+		recordLoginSuccess(db, c, user)
 
-		c.JSON(http.StatusOK, gin.H{
-			"token": tokenString,
-			"user":  gin.H{"id": user.ID, "username": user.Username, "role": user.Role},
-		})
+		c.JSON(http.StatusOK, response)
 	}
 }
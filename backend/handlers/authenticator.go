@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	// errUnknownUser means the authenticator does not handle this username;
+	// the chain moves on to the next authenticator
+	errUnknownUser        = errors.New("unknown user")
+	errInvalidCredentials = errors.New("invalid credentials")
+	errSingleSignOn       = errors.New("this account signs in through single sign-on")
+)
+
+// Authenticator verifies a username and password against one user store
+type Authenticator interface {
+	Name() string
+	Authenticate(c *gin.Context, db *gorm.DB, username, password string) (models.User, error)
+}
+
+// localAuthenticator checks bcrypt password hashes stored in the users table
+type localAuthenticator struct{}
+
+func (localAuthenticator) Name() string { return "local" }
+
+func (localAuthenticator) Authenticate(c *gin.Context, db *gorm.DB, username, password string) (models.User, error) {
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return user, errUnknownUser
+	}
+	switch user.AuthSource {
+	case "", "local":
+	case "oidc":
+		return user, errSingleSignOn
+	default:
+		// Directory accounts are verified by their own authenticator
+		return user, errUnknownUser
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return user, errInvalidCredentials
+	}
+	return user, nil
+}
+
+// loadAuthenticators builds the login chain from AUTH_BACKENDS (comma
+// separated, tried in order). Without it, local accounts are tried first,
+// followed by LDAP when LDAP_URL is set.
+func loadAuthenticators() []Authenticator {
+	names := splitList(getEnv("AUTH_BACKENDS", "local"))
+	if getEnv("AUTH_BACKENDS", "") == "" && ldapConfig.URL != "" {
+		names = append(names, "ldap")
+	}
+
+	var chain []Authenticator
+	for _, name := range names {
+		switch strings.ToLower(name) {
+		case "local":
+			chain = append(chain, localAuthenticator{})
+		case "ldap":
+			chain = append(chain, ldapAuthenticator{settings: ldapConfig})
+		default:
+			log.Printf("Ignoring unknown authentication backend %q", name)
+		}
+	}
+	return chain
+}
+
+var authenticators = loadAuthenticators()
+
+// authenticate runs the authenticator chain. The first authenticator that
+// accepts the credentials wins; otherwise the most specific failure is returned.
+func authenticate(c *gin.Context, db *gorm.DB, username, password string) (models.User, error) {
+	failure := errInvalidCredentials
+	for _, authenticator := range authenticators {
+		user, err := authenticator.Authenticate(c, db, username, password)
+		if err == nil {
+			return user, nil
+		}
+		if errors.Is(err, errSingleSignOn) {
+			failure = err
+		} else if !errors.Is(err, errUnknownUser) && !errors.Is(err, errInvalidCredentials) {
+			log.Printf("%s authentication failed for %q: %v", authenticator.Name(), username, err)
+		}
+	}
+	return models.User{}, failure
+}
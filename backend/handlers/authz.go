@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+// permissions is the catalogue of named permissions roles and grants are
+// built from. Permissions held through a role apply to every domain.
+var permissions = map[string]string{
+	"domain.create":       "Register domains for yourself or an organization you edit",
+	"domain.create_any":   "Register domains for any user or organization, beyond quotas",
+	"domain.read":         "View domains and their registrant data",
+	"domain.write":        "Change registrant data",
+	"domain.delete":       "Delete domains",
+	"domain.transfer":     "Move domains between users and organizations",
+	"domain.delegate":     "Delegate subdomains to other users and organizations",
+	"domain.lock":         "Set and lift server status codes such as serverHold",
+	"record.read":         "View records, history and scheduled changes",
+	"record.write":        "Create, change, schedule and roll back records",
+	"whois.view_private":  "See contact details that WHOIS redacts",
+	"template.write":      "Manage record templates",
+	"user.manage":         "Manage users, invitations, sessions and approvals",
+	"user.impersonate":    "Act as another user for support, with every action audited",
+	"role.manage":         "Manage roles and grants",
+	"config.write":        "Change the registrar configuration and email templates",
+	"audit.read":          "Read the audit log",
+	"organization.manage": "View and manage every organization and its members",
+}
+
+// domainPermissions can be given on a single domain through a grant;
+// subtreePermissions also on the records below a subdomain
+var (
+	domainPermissions  = []string{"domain.read", "domain.write", "domain.delete", "domain.transfer", "domain.delegate", "record.read", "record.write", "whois.view_private"}
+	subtreePermissions = []string{"record.read", "record.write"}
+)
+
+// impliedBy lists the permission that implies another one
+var impliedBy = map[string]string{
+	"domain.read": "domain.write",
+	"record.read": "record.write",
+}
+
+// builtInRoles are seeded at startup. The admin role always holds every
+// permission; the user role's permissions can be changed.
+var builtInRoles = []models.Role{
+	{Name: "admin", Description: "Full access to every domain and setting"},
+	{Name: "user", Description: "Registers domains and manages their own", Permissions: models.StringList{"domain.create"}},
+}
+
+// ownerPermissions is what a user may do with their personal domains
+var ownerPermissions = []string{"domain.read", "domain.write", "domain.delete", "domain.transfer", "domain.delegate", "record.read", "record.write", "whois.view_private"}
+
+// orgRolePermissions maps organization roles to what they allow on the
+// organization's domains
+var orgRolePermissions = map[string][]string{
+	"viewer": {"domain.read", "record.read", "whois.view_private"},
+	"editor": {"domain.create", "domain.read", "domain.write", "record.read", "record.write", "whois.view_private"},
+	"owner":  ownerPermissions,
+}
+
+// orgRoleRank orders organization roles; each includes the ones below it
+var orgRoleRank = map[string]int{
+	"viewer": 1,
+	"editor": 2,
+	"owner":  3,
+}
+
+// SeedRoles creates the built-in roles and keeps the admin role holding
+// every permission in the catalogue
+func SeedRoles(db *gorm.DB) error {
+	for _, builtIn := range builtInRoles {
+		role := builtIn
+		if role.Name == "admin" {
+			role.Permissions = models.StringList{}
+			for name := range permissions {
+				role.Permissions = append(role.Permissions, name)
+			}
+			sort.Strings(role.Permissions)
+		}
+		var existing models.Role
+		if err := db.Where("name = ?", role.Name).First(&existing).Error; err == nil {
+			if role.Name == "admin" {
+				if err := db.Model(&existing).Updates(map[string]interface{}{"permissions": role.Permissions, "built_in": true}).Error; err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		role.BuiltIn = true
+		if err := db.Create(&role).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// permits reports whether a permission list holds perm, directly or through
+// a permission that implies it
+func permits(held []string, perm string) bool {
+	for _, p := range held {
+		if p == perm || p == impliedBy[perm] {
+			return true
+		}
+	}
+	return false
+}
+
+// roleHasPermission reports whether the named role holds perm
+func roleHasPermission(db *gorm.DB, roleName, perm string) bool {
+	var role models.Role
+	if err := db.Where("name = ?", roleName).First(&role).Error; err != nil {
+		return false
+	}
+	return permits(role.Permissions, perm)
+}
+
+// rolePermissions returns the permissions of the caller's role, loaded once
+// per request
+func rolePermissions(c *gin.Context, db *gorm.DB) []string {
+	if held, ok := c.Get("permissions"); ok {
+		return held.([]string)
+	}
+	var role models.Role
+	db.Where("name = ?", c.MustGet("role").(string)).First(&role)
+	held := []string(role.Permissions)
+	c.Set("permissions", held)
+	return held
+}
+
+// actingAs returns a context carrying a user's identity, for permission
+// checks made outside a request such as when the scheduler applies a change
+// on the user's behalf
+func actingAs(user models.User) *gin.Context {
+	c := &gin.Context{}
+	c.Set("user_id", user.ID)
+	c.Set("role", user.Role)
+	return c
+}
+
+// can reports whether the caller's role holds perm
+func can(c *gin.Context, db *gorm.DB, perm string) bool {
+	return permits(rolePermissions(c, db), perm)
+}
+
+// requirePermission checks that the caller's role holds perm. Writes a 403
+// response and returns false when denied.
+func requirePermission(c *gin.Context, db *gorm.DB, perm string) bool {
+	if can(c, db, perm) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Missing permission: " + perm})
+	return false
+}
+
+// requireAdmin checks that the caller's role holds perm and, for API keys,
+// that the key holds the admin scope covering it. Writes a 403 response and
+// returns false when denied.
+func requireAdmin(c *gin.Context, db *gorm.DB, perm, scope string) bool {
+	return requirePermission(c, db, perm) && requireScope(c, scope, "")
+}
+
+// orgRole returns the user's role in an organization, or "" for non-members
+func orgRole(db *gorm.DB, orgID, userID uint) string {
+	var member models.OrganizationMember
+	if err := db.Where("organization_id = ? AND user_id = ?", orgID, userID).First(&member).Error; err != nil {
+		return ""
+	}
+	return member.Role
+}
+
+// canInOrganization reports whether the caller holds perm in an
+// organization, through their role in it or domain.create_any
+func canInOrganization(c *gin.Context, db *gorm.DB, orgID uint, perm string) bool {
+	if can(c, db, "domain.create_any") {
+		return true
+	}
+	return permits(orgRolePermissions[orgRole(db, orgID, c.MustGet("user_id").(uint))], perm)
+}
+
+// ownershipPermissions works out what owning a domain gives the caller:
+// everything on personal domains, the organization role's permissions on
+// organization domains
+func ownershipPermissions(c *gin.Context, db *gorm.DB, domain models.Domain) []string {
+	userID := c.MustGet("user_id").(uint)
+	if domain.OrganizationID == nil {
+		if domain.UserID == userID {
+			return ownerPermissions
+		}
+		return nil
+	}
+	return orgRolePermissions[orgRole(db, *domain.OrganizationID, userID)]
+}
+
+// userGrants returns the caller's grants on a domain
+func userGrants(c *gin.Context, db *gorm.DB, domainID uint) []models.Grant {
+	var grants []models.Grant
+	db.Where("user_id = ? AND domain_id = ?", c.MustGet("user_id").(uint), domainID).Find(&grants)
+	return grants
+}
+
+// withinName reports whether name equals parent or lies below it
+func withinName(name, parent string) bool {
+	name, parent = strings.ToLower(name), strings.ToLower(parent)
+	return name == parent || strings.HasSuffix(name, "."+parent)
+}
+
+// recordFQDN expands a record name ("@", relative or fully qualified) to its
+// fully qualified form within a domain
+func recordFQDN(name, domain string) string {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if name == "" || name == "@" {
+		return domain
+	}
+	if withinName(name, domain) {
+		return name
+	}
+	return name + "." + domain
+}
+
+// delegatedNames returns the subdomains of a domain delegated to the caller
+// that give perm. Delegations to a user give record.read and record.write;
+// delegations to an organization give its members the record permissions of
+// their organization role.
+func delegatedNames(c *gin.Context, db *gorm.DB, domainID uint, perm string) []string {
+	if !models.StringList(subtreePermissions).Contains(perm) {
+		return nil
+	}
+	userID := c.MustGet("user_id").(uint)
+	var memberships []models.OrganizationMember
+	db.Where("user_id = ?", userID).Find(&memberships)
+	roles := map[uint]string{}
+	orgIDs := []uint{}
+	for _, m := range memberships {
+		roles[m.OrganizationID] = m.Role
+		orgIDs = append(orgIDs, m.OrganizationID)
+	}
+
+	var delegations []models.Delegation
+	db.Where("domain_id = ? AND (user_id = ? OR organization_id IN ?)", domainID, userID, orgIDs).Find(&delegations)
+	var names []string
+	for _, delegation := range delegations {
+		if delegation.UserID != nil && *delegation.UserID == userID ||
+			delegation.OrganizationID != nil && permits(orgRolePermissions[roles[*delegation.OrganizationID]], perm) {
+			names = append(names, delegation.Name)
+		}
+	}
+	return names
+}
+
+// subtreeNames returns the names in a domain under which the caller holds
+// perm through grants and delegations
+func subtreeNames(c *gin.Context, db *gorm.DB, domainID uint, perm string) []string {
+	var names []string
+	for _, grant := range userGrants(c, db, domainID) {
+		if permits(grant.Permissions, perm) {
+			names = append(names, grant.Name)
+		}
+	}
+	return append(names, delegatedNames(c, db, domainID, perm)...)
+}
+
+// permitted reports whether the caller holds perm on a name inside a
+// domain: through their role, ownership of the domain, or a grant or
+// delegation covering the name. Pass the domain name for actions on the
+// whole domain.
+func permitted(c *gin.Context, db *gorm.DB, domain models.Domain, name, perm string) bool {
+	if can(c, db, perm) || permits(ownershipPermissions(c, db, domain), perm) {
+		return true
+	}
+	for _, subtree := range subtreeNames(c, db, domain.ID, perm) {
+		if withinName(name, subtree) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordAccess returns a check for whether the caller holds perm on a record
+// name in a domain. Domain-wide access is resolved once, so the check is
+// cheap to run over many records.
+func recordAccess(c *gin.Context, db *gorm.DB, domain models.Domain, perm string) func(name string) bool {
+	if permitted(c, db, domain, domain.Name, perm) {
+		return func(string) bool { return true }
+	}
+	subtrees := subtreeNames(c, db, domain.ID, perm)
+	return func(name string) bool {
+		fqdn := recordFQDN(name, domain.Name)
+		for _, subtree := range subtrees {
+			if withinName(fqdn, subtree) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// authorizeDomain checks that the caller holds perm on the whole domain and
+// that an API key holds scope for it. Writes a 403 response and returns
+// false when denied.
+func authorizeDomain(c *gin.Context, db *gorm.DB, domain models.Domain, perm, scope string) bool {
+	if !permitted(c, db, domain, domain.Name, perm) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return false
+	}
+	return requireScope(c, scope, domain.Name)
+}
+
+// loadDomain loads a domain by ID and authorizes it like authorizeDomain.
+// Writes a 404 response when the domain does not exist.
+func loadDomain(c *gin.Context, db *gorm.DB, id interface{}, perm, scope string) (models.Domain, bool) {
+	var domain models.Domain
+	if result := db.First(&domain, id); result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		return domain, false
+	}
+	return domain, authorizeDomain(c, db, domain, perm, scope)
+}
+
+// loadZone loads a domain for record operations. Unlike loadDomain it also
+// admits callers whose grants or delegations only cover a subdomain; check
+// each record with recordAccess.
+func loadZone(c *gin.Context, db *gorm.DB, id interface{}, perm, scope string) (models.Domain, bool) {
+	var domain models.Domain
+	if result := db.First(&domain, id); result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		return domain, false
+	}
+	if !permitted(c, db, domain, domain.Name, perm) && len(subtreeNames(c, db, domain.ID, perm)) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return domain, false
+	}
+	return domain, requireScope(c, scope, domain.Name)
+}
+
+// authorizeRecord checks that the caller may act on a record name. Writes a
+// 403 response and returns false when denied.
+func authorizeRecord(c *gin.Context, access func(string) bool, name string) bool {
+	if access(name) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Access denied for record name " + name})
+	return false
+}
+
+// visibleDomains scopes a domain query to what the caller may see: every
+// domain with domain.read through their role, otherwise their personal
+// domains, those of their organizations and those they hold grants or
+// delegations in
+func visibleDomains(c *gin.Context) func(*gorm.DB) *gorm.DB {
+	userID := c.MustGet("user_id").(uint)
+	return func(db *gorm.DB) *gorm.DB {
+		fresh := db.Session(&gorm.Session{NewDB: true})
+		if can(c, fresh, "domain.read") {
+			return db
+		}
+		memberOf := fresh.Model(&models.OrganizationMember{}).Select("organization_id").Where("user_id = ?", userID)
+		granted := fresh.Model(&models.Grant{}).Select("domain_id").Where("user_id = ?", userID)
+		delegated := fresh.Model(&models.Delegation{}).Select("domain_id").Where("user_id = ? OR organization_id IN (?)", userID, memberOf)
+		return db.Where("((domains.organization_id IS NULL AND domains.user_id = ?) OR domains.organization_id IN (?) OR domains.id IN (?) OR domains.id IN (?))", userID, memberOf, granted, delegated)
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+// ListDelegations returns the subdomain delegations of a domain
+func ListDelegations(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		domain, ok := loadDomain(c, db, c.Param("id"), "domain.read", "domains:read")
+		if !ok {
+			return
+		}
+
+		delegations := []models.Delegation{}
+		db.Where("domain_id = ?", domain.ID).Order("name ASC").Find(&delegations)
+		c.JSON(http.StatusOK, delegations)
+	}
+}
+
+// CreateDelegation hands a subdomain to a user (username) or an
+// organization's members (organization_id). They may then change records at
+// and below it, but nowhere else in the zone. With nameservers the
+// subdomain is also delegated in DNS through NS records in the parent zone.
+func CreateDelegation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		domain, ok := loadDomain(c, db, c.Param("id"), "domain.delegate", "domains:write")
+		if !ok {
+			return
+		}
+		if !requireUnlocked(c, domain, "update") {
+			return
+		}
+
+		var input struct {
+			Name           string   `json:"name" binding:"required"` // relative ("ci") or fully qualified ("ci.corp.lan")
+			Username       string   `json:"username"`
+			OrganizationID *uint    `json:"organization_id"`
+			Nameservers    []string `json:"nameservers"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if (input.Username == "") == (input.OrganizationID == nil) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Give either username or organization_id"})
+			return
+		}
+		relative, err := normalizeRecordName(input.Name)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		name := recordFQDN(relative, domain.Name)
+		if name == domain.Name {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only subdomains can be delegated"})
+			return
+		}
+
+		delegation := models.Delegation{
+			DomainID:    domain.ID,
+			Name:        name,
+			Nameservers: models.StringList{},
+			CreatedBy:   actorID(c),
+		}
+		if input.Username != "" {
+			var user models.User
+			if err := db.Where("username = ?", input.Username).First(&user).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+			delegation.UserID = &user.ID
+		} else {
+			if err := db.First(&models.Organization{}, *input.OrganizationID).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+				return
+			}
+			delegation.OrganizationID = input.OrganizationID
+		}
+		for _, ns := range input.Nameservers {
+			if ns = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(ns)), "."); ns != "" {
+				if ns, err = normalizeRecordName(ns); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				delegation.Nameservers = append(delegation.Nameservers, ns)
+			}
+		}
+
+		comment := "delegation of " + name
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&delegation).Error; err != nil {
+				return err
+			}
+			for _, ns := range delegation.Nameservers {
+				record := models.Record{DomainID: domain.ID, Name: name, Type: "NS", Content: ns, TTL: 3600}
+				if err := tx.Create(&record).Error; err != nil {
+					return err
+				}
+				if err := recordHistory(tx, domain.ID, "create", actorID(c), comment, nil, &record); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			if strings.Contains(err.Error(), "duplicate key value") || strings.Contains(err.Error(), "UNIQUE constraint") {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is already delegated", name)})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create delegation: " + err.Error()})
+			}
+			return
+		}
+		audit(c, "delegation.create", "domain", domain.ID, nil, delegation)
+		c.JSON(http.StatusCreated, delegation)
+	}
+}
+
+// removeDelegation deletes a delegation together with the NS records it added
+// to the parent zone
+func removeDelegation(tx *gorm.DB, delegation models.Delegation, actor uint, comment string) error {
+	if len(delegation.Nameservers) > 0 {
+		var records []models.Record
+		tx.Where("domain_id = ? AND name = ? AND type = ? AND content IN ?", delegation.DomainID, delegation.Name, "NS", []string(delegation.Nameservers)).Find(&records)
+		for i := range records {
+			if err := tx.Delete(&records[i]).Error; err != nil {
+				return err
+			}
+			if err := recordHistory(tx, delegation.DomainID, "delete", actor, comment, &records[i], nil); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Delete(&delegation).Error
+}
+
+// DeleteDelegation revokes a delegation and removes the NS records it added
+func DeleteDelegation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var delegation models.Delegation
+		if err := db.First(&delegation, c.Param("delegationId")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Delegation not found"})
+			return
+		}
+		domain, ok := loadDomain(c, db, delegation.DomainID, "domain.delegate", "domains:write")
+		if !ok {
+			return
+		}
+		if !requireUnlocked(c, domain, "update") {
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return removeDelegation(tx, delegation, actorID(c), "delegation of "+delegation.Name+" revoked")
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete delegation: " + err.Error()})
+			return
+		}
+		audit(c, "delegation.delete", "domain", domain.ID, delegation, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Delegation revoked"})
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/localdns/backend/models"
+)
+
+func TestRecordAccessStopsAtTheDelegatedSubtree(t *testing.T) {
+	db := newTestDB(t)
+	alice := models.User{Username: "alice", Role: "user"}
+	carol := models.User{Username: "carol", Role: "user"}
+	db.Create(&alice)
+	db.Create(&carol)
+	domain := models.Domain{Name: "corp.lan", UserID: alice.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0)}
+	db.Create(&domain)
+	db.Create(&models.Delegation{DomainID: domain.ID, Name: "dev.corp.lan", UserID: &carol.ID, Nameservers: models.StringList{}})
+
+	access := recordAccess(actingAs(carol), db, domain, "record.write")
+	for name, want := range map[string]bool{
+		"dev":                true,
+		"dev.corp.lan":       true,
+		"dev.corp.lan.":      true,
+		"DEV":                true,
+		"*.dev":              true,
+		"*.dev.corp.lan":     true,
+		"ci.build.dev":       true,
+		"@":                  false,
+		"":                   false,
+		"corp.lan":           false,
+		"www":                false,
+		"evildev":            false,
+		"evildev.corp.lan":   false,
+		"dev.evil":           false,
+		"dev.corp.lan.other": false,
+	} {
+		if got := access(name); got != want {
+			t.Errorf("%q: expected %v, got %v", name, want, got)
+		}
+	}
+	if recordAccess(actingAs(carol), db, domain, "domain.write")("dev") {
+		t.Error("a delegation must not give domain permissions")
+	}
+}
+
+func TestOrganizationDelegationsFollowTheMemberRole(t *testing.T) {
+	db := newTestDB(t)
+	alice := models.User{Username: "alice", Role: "user"}
+	editor := models.User{Username: "erin", Role: "user"}
+	viewer := models.User{Username: "victor", Role: "user"}
+	for _, u := range []*models.User{&alice, &editor, &viewer} {
+		db.Create(u)
+	}
+	org := models.Organization{Name: "Dev"}
+	db.Create(&org)
+	db.Create(&models.OrganizationMember{OrganizationID: org.ID, UserID: editor.ID, Role: "editor"})
+	db.Create(&models.OrganizationMember{OrganizationID: org.ID, UserID: viewer.ID, Role: "viewer"})
+	domain := models.Domain{Name: "corp.lan", UserID: alice.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0)}
+	db.Create(&domain)
+	db.Create(&models.Delegation{DomainID: domain.ID, Name: "dev.corp.lan", OrganizationID: &org.ID, Nameservers: models.StringList{}})
+
+	tests := []struct {
+		user models.User
+		perm string
+		want bool
+	}{
+		{editor, "record.write", true},
+		{editor, "record.read", true},
+		{viewer, "record.read", true},
+		{viewer, "record.write", false},
+	}
+	for _, tt := range tests {
+		if got := recordAccess(actingAs(tt.user), db, domain, tt.perm)("www.dev"); got != tt.want {
+			t.Errorf("%s %s: expected %v, got %v", tt.user.Username, tt.perm, tt.want, got)
+		}
+	}
+}
+
+func TestDelegationNameserversComeAndGoWithIt(t *testing.T) {
+	db := newTestDB(t)
+	alice := models.User{Username: "alice", Role: "user"}
+	carol := models.User{Username: "carol", Role: "user"}
+	db.Create(&alice)
+	db.Create(&carol)
+	domain := models.Domain{Name: "corp.lan", UserID: alice.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0)}
+	db.Create(&domain)
+
+	r := signedIn(alice)
+	r.POST("/api/domains/:id/delegations", CreateDelegation(db))
+	r.DELETE("/api/delegations/:delegationId", DeleteDelegation(db))
+	path := fmt.Sprintf("/api/domains/%d/delegations", domain.ID)
+
+	if w := serve(r, jsonRequest(http.MethodPost, path, `{"name":"@","username":"carol"}`)); w.Code != http.StatusBadRequest {
+		t.Errorf("delegating the apex: expected 400, got %d", w.Code)
+	}
+	w := serve(r, jsonRequest(http.MethodPost, path, `{"name":"dev","username":"carol","nameservers":["ns1.dev.corp.lan.","NS2.dev.corp.lan"]}`))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create delegation: %d %s", w.Code, w.Body)
+	}
+	var delegation models.Delegation
+	db.Where("domain_id = ?", domain.ID).First(&delegation)
+	if delegation.Name != "dev.corp.lan" {
+		t.Errorf("expected the delegation stored as dev.corp.lan, got %q", delegation.Name)
+	}
+	var ns int64
+	db.Model(&models.Record{}).Where("domain_id = ? AND name = ? AND type = ?", domain.ID, "dev.corp.lan", "NS").Count(&ns)
+	if ns != 2 {
+		t.Fatalf("expected 2 NS records, got %d", ns)
+	}
+
+	if w := serve(r, jsonRequest(http.MethodDelete, fmt.Sprintf("/api/delegations/%d", delegation.ID), "")); w.Code != http.StatusOK {
+		t.Fatalf("delete delegation: %d %s", w.Code, w.Body)
+	}
+	db.Model(&models.Record{}).Where("domain_id = ? AND type = ?", domain.ID, "NS").Count(&ns)
+	if ns != 0 {
+		t.Errorf("%d NS records left after the delegation was removed", ns)
+	}
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -14,19 +16,39 @@ import (
 func CreateDomain(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID := c.MustGet("user_id").(uint)
-        role := c.MustGet("role").(string)
 
 		var input struct {
 			Name   string `json:"name" binding:"required"`
-            UserID uint   `json:"user_id"` // Admin can specify user_id
+            UserID uint   `json:"user_id"` // Needs domain.create_any
+
+			// Register the domain for an organization instead of a single user
+			OrganizationID *uint `json:"organization_id"`
+
+			// Optional record template applied to the new domain
+			TemplateID        uint              `json:"template_id"`
+			TemplateVariables map[string]string `json:"template_variables"`
 		}
 		if err := c.ShouldBindJSON(&input); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		name, err := normalizeDomainName(input.Name)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid domain name: " + err.Error()})
+			return
+		}
+		if !requireScope(c, "domains:write", name) {
+			return
+		}
 
+		// Registering for someone else, or beyond a quota, needs domain.create_any
+		createAny := can(c, db, "domain.create_any")
         targetUserID := userID
-        if role == "admin" && input.UserID != 0 {
+        if input.UserID != 0 && input.UserID != userID {
+            if !createAny {
+                c.JSON(http.StatusForbidden, gin.H{"error": "Missing permission: domain.create_any"})
+                return
+            }
             targetUserID = input.UserID
         }
 
@@ -37,17 +59,35 @@ func CreateDomain(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Get registrar config for default expiry
-		var config models.RegistrarConfig
-		defaultExpiryDays := 365 // Default fallback
-		if err := db.First(&config).Error; err == nil && config.DefaultExpiry > 0 {
-			defaultExpiryDays = config.DefaultExpiry
+		if input.OrganizationID != nil {
+			var org models.Organization
+			if err := db.First(&org, *input.OrganizationID).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+				return
+			}
+			if !canInOrganization(c, db, org.ID, "domain.create") {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Only owners and editors can register domains for an organization"})
+				return
+			}
+		} else if !requirePermission(c, db, "domain.create") {
+			return
 		}
 
+		// Only names one label below an enabled TLD, and not reserved, can be registered
+		if _, err := checkRegistrable(db, name, createAny); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Expiry defaults to the TLD's term, then the registrar config
+		defaultExpiryDays := termDays(db, name)
+
 		// Create domain with contact info from owner
 		domain := models.Domain{
-			Name:   input.Name,
+			Name:   name,
+			UnicodeName: toUnicodeName(name),
 			UserID: targetUserID,
+			OrganizationID: input.OrganizationID,
 			Status: "active",
 			// Copy contact info from owner to registrant fields
 			RegistrantName:    owner.ContactName,
@@ -63,12 +103,46 @@ func CreateDomain(db *gorm.DB) gin.HandlerFunc {
 			ExpiresAt: time.Now().AddDate(0, 0, defaultExpiryDays),
 		}
 
-		if result := db.Create(&domain); result.Error != nil {
+		// Render the template up front so missing variables are rejected before anything is created
+		var templateRecords []models.Record
+		if input.TemplateID != 0 {
+			template, err := loadTemplate(db, input.TemplateID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Template not found"})
+				return
+			}
+			if templateRecords, err = renderTemplate(template, domain.Name, input.TemplateVariables); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if !createAny {
+				if err := checkDomainQuota(tx, owner.ID); err != nil {
+					return err
+				}
+			}
+			if err := tx.Create(&domain).Error; err != nil {
+				return err
+			}
+			if input.TemplateID == 0 {
+				return nil
+			}
+			comment := fmt.Sprintf("template #%d", input.TemplateID)
+			return applyTemplatePlan(tx, domain, actorID(c), comment, planTemplate(tx, domain.ID, templateRecords))
+		})
+		var quota quotaError
+		if errors.As(err, &quota) {
+			c.JSON(http.StatusForbidden, gin.H{"error": quota.Error()})
+			return
+		}
+		if err != nil {
             // Check for unique constraint violation explicitly
-            if strings.Contains(result.Error.Error(), "duplicate key value") || strings.Contains(result.Error.Error(), "UNIQUE constraint") {
+            if strings.Contains(err.Error(), "duplicate key value") || strings.Contains(err.Error(), "UNIQUE constraint") {
 			    c.JSON(http.StatusBadRequest, gin.H{"error": "Domain already exists"})
             } else {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create domain: " + result.Error.Error()})
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create domain: " + err.Error()})
             }
 			return
 		}
@@ -76,40 +150,39 @@ func CreateDomain(db *gorm.DB) gin.HandlerFunc {
 		// Reload domain with user association for response
 		db.Preload("User").First(&domain, domain.ID)
 
+		if targetUserID != userID {
+			audit(c, "domain.create_on_behalf", "domain", domain.ID, nil, gin.H{"name": domain.Name, "user_id": targetUserID})
+		}
+
 		c.JSON(http.StatusCreated, domain)
 	}
 }
 
 func ListDomains(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-        userID := c.MustGet("user_id").(uint)
-        role := c.MustGet("role").(string)
+		if !requireScope(c, "domains:read", "") {
+			return
+		}
 
 		var domains []models.Domain
-        
-        if role == "admin" {
-            // Admin sees all domains with owner info
-            if result := db.Preload("User").Find(&domains); result.Error != nil {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
-                return
-            }
-        } else {
-            // User sees only own domains, but still load user info for display
-		    if result := db.Preload("User").Where("user_id = ?", userID).Find(&domains); result.Error != nil {
-			    c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
-			    return
-		    }
-        }
-        
+
+		// Admin sees all domains, users only their own; owner info is loaded for display
+		if result := db.Preload("User").Preload("Organization").Scopes(visibleDomains(c)).Find(&domains); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+			return
+		}
+		for i := range domains {
+			if !permitted(c, db, domains[i], domains[i].Name, "whois.view_private") {
+				redactContacts(&domains[i])
+			}
+		}
+
 		c.JSON(http.StatusOK, domains)
 	}
 }
 
 func AddRecord(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-        userID := c.MustGet("user_id").(uint)
-        role := c.MustGet("role").(string)
-
 		domainID := c.Param("id")
 		
 		var input models.Record
@@ -118,29 +191,61 @@ func AddRecord(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 		
-		// Ensure domain exists and owned by user (or is admin)
-		var domain models.Domain
-		if result := db.First(&domain, domainID); result.Error != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		// Internationalised names are stored as A-labels, which is what DNS serves
+		var err error
+		if input.Name, err = normalizeRecordName(input.Name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if input.Content, err = normalizeRecordContent(input.Type, input.Content); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-        if role != "admin" && domain.UserID != userID {
-            c.JSON(http.StatusForbidden, gin.H{"error": "You do not own this domain"})
-            return
-        }
+		// Ensure domain exists and the caller may edit records under this name
+		domain, ok := loadZone(c, db, domainID, "record.write", "records:write")
+		if !ok {
+			return
+		}
+		if !authorizeRecord(c, recordAccess(c, db, domain, "record.write"), input.Name) {
+			return
+		}
+		if !requireUnlocked(c, domain, "update") {
+			return
+		}
 
 		input.DomainID = domain.ID
 		// Force default if 0
 		if input.TTL == 0 {
 			input.TTL = 360
 		}
+		if err := applyRecordWindow(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// Records of a domain past grace or on hold are kept out of DNS until
+		// it is restored or the hold is lifted
+		input.Held = false
+		holdForDomain(&input, domain)
+		tags, err := normalizeTags(input.Tags)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		input.Tags = tags
 		
-		if result := db.Create(&input); result.Error != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&input).Error; err != nil {
+				return err
+			}
+			return recordHistory(tx, domain.ID, "create", actorID(c), "", nil, &input)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
+		input.UnicodeName = toUnicodeName(input.Name)
 		c.JSON(http.StatusCreated, input)
 	}
 }
@@ -148,49 +253,60 @@ func AddRecord(db *gorm.DB) gin.HandlerFunc {
 // ListRecords returns all records for a domain
 func ListRecords(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.MustGet("user_id").(uint)
-		role := c.MustGet("role").(string)
-		domainID := c.Param("id")
-
-		var domain models.Domain
-		if result := db.First(&domain, domainID); result.Error != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
-			return
-		}
-
-		if role != "admin" && domain.UserID != userID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		domain, ok := loadZone(c, db, c.Param("id"), "record.read", "records:read")
+		if !ok {
 			return
 		}
 
 		var records []models.Record
-		db.Where("domain_id = ?", domain.ID).Find(&records)
-		c.JSON(http.StatusOK, records)
+		db.Preload("Tags").Where("domain_id = ?", domain.ID).Find(&records)
+
+		// Callers with a subdomain grant only see the records below it
+		access := recordAccess(c, db, domain, "record.read")
+		visible := make([]models.Record, 0, len(records))
+		for _, record := range records {
+			if access(record.Name) {
+				visible = append(visible, record)
+			}
+		}
+		withUnicodeNames(visible)
+		c.JSON(http.StatusOK, visible)
 	}
 }
 
 // DeleteRecord removes a DNS record
 func DeleteRecord(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.MustGet("user_id").(uint)
-		role := c.MustGet("role").(string)
 		recordID := c.Param("recordId")
 
 		var record models.Record
-		if result := db.First(&record, recordID); result.Error != nil {
+		if result := db.Preload("Tags").First(&record, recordID); result.Error != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
 			return
 		}
 
-		// Check ownership via domain
-		var domain models.Domain
-		db.First(&domain, record.DomainID)
-		if role != "admin" && domain.UserID != userID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		// Check access via domain
+		domain, ok := loadZone(c, db, record.DomainID, "record.write", "records:write")
+		if !ok {
+			return
+		}
+		if !authorizeRecord(c, recordAccess(c, db, domain, "record.write"), record.Name) {
+			return
+		}
+		if !requireUnlocked(c, domain, "update") {
 			return
 		}
 
-		db.Delete(&record)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(&record).Error; err != nil {
+				return err
+			}
+			return recordHistory(tx, domain.ID, "delete", actorID(c), "", &record, nil)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete record: " + err.Error()})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{"message": "Record deleted"})
 	}
 }
@@ -198,24 +314,19 @@ func DeleteRecord(db *gorm.DB) gin.HandlerFunc {
 // DeleteDomain removes a domain and all its records
 func DeleteDomain(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.MustGet("user_id").(uint)
-		role := c.MustGet("role").(string)
-		domainID := c.Param("id")
-
-		var domain models.Domain
-		if result := db.First(&domain, domainID); result.Error != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		domain, ok := loadDomain(c, db, c.Param("id"), "domain.delete", "domains:write")
+		if !ok {
 			return
 		}
-
-		if role != "admin" && domain.UserID != userID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		if !requireUnlocked(c, domain, "delete") {
 			return
 		}
 
-		// Delete all records first
-		db.Where("domain_id = ?", domain.ID).Delete(&models.Record{})
-		db.Delete(&domain)
+		// Records, grants and delegations go with the domain
+		if err := db.Transaction(func(tx *gorm.DB) error { return purgeDomain(tx, domain, actorID(c), "domain deleted") }); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete domain: " + err.Error()})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{"message": "Domain deleted"})
 	}
 }
@@ -223,14 +334,17 @@ func DeleteDomain(db *gorm.DB) gin.HandlerFunc {
 // ListUsers returns all users (admin only)
 func ListUsers(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role := c.MustGet("role").(string)
-		if role != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		if !requireAdmin(c, db, "user.manage", "users:admin") {
 			return
 		}
 
+		// ?status=pending lists registrations waiting for approval
+		query := db
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
 		var users []models.User
-		db.Find(&users)
+		query.Find(&users)
 		c.JSON(http.StatusOK, users)
 	}
 }
@@ -238,9 +352,7 @@ func ListUsers(db *gorm.DB) gin.HandlerFunc {
 // DeleteUser removes a user (admin only)
 func DeleteUser(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role := c.MustGet("role").(string)
-		if role != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		if !requireAdmin(c, db, "user.manage", "users:admin") {
 			return
 		}
 
@@ -261,7 +373,60 @@ func DeleteUser(db *gorm.DB) gin.HandlerFunc {
 			}
 		}
 
-		db.Delete(&user)
+		// Organizations must keep an owner, and personal domains an owner
+		var memberships []models.OrganizationMember
+		db.Where("user_id = ? AND role = ?", user.ID, "owner").Find(&memberships)
+		for _, m := range memberships {
+			if lastOwner(db, m.OrganizationID, user.ID) {
+				var org models.Organization
+				db.First(&org, m.OrganizationID)
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delete the last owner of organization " + org.Name})
+				return
+			}
+		}
+		var personal int64
+		db.Model(&models.Domain{}).Where("user_id = ? AND organization_id IS NULL", user.ID).Count(&personal)
+		if personal > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User still owns %d domains; transfer or delete them first", personal)})
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// Organization domains recorded under the user move to another owner
+			var orgDomains []models.Domain
+			tx.Where("user_id = ? AND organization_id IS NOT NULL", user.ID).Find(&orgDomains)
+			for _, domain := range orgDomains {
+				var owner uint
+				for _, id := range organizationOwners(tx, *domain.OrganizationID) {
+					if id != user.ID {
+						owner = id
+						break
+					}
+				}
+				if owner == 0 {
+					return fmt.Errorf("organization of %s has no other owner", domain.Name)
+				}
+				if err := tx.Model(&models.Domain{}).Where("id = ?", domain.ID).Update("user_id", owner).Error; err != nil {
+					return err
+				}
+			}
+
+			if _, err := revokeSessions(tx, user.ID, 0); err != nil {
+				return err
+			}
+			for _, model := range []interface{}{&models.APIKey{}, &models.RecoveryCode{}, &models.UserToken{},
+				&models.OrganizationMember{}, &models.Grant{}, &models.Delegation{}} {
+				if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Delete(&user).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user: " + err.Error()})
+			return
+		}
+		audit(c, "user.delete", "user", user.ID, user, nil)
 		c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
 	}
 }
@@ -269,21 +434,24 @@ func DeleteUser(db *gorm.DB) gin.HandlerFunc {
 // UpdateRecord modifies an existing DNS record
 func UpdateRecord(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.MustGet("user_id").(uint)
-		role := c.MustGet("role").(string)
 		recordID := c.Param("recordId")
 
 		var record models.Record
-		if result := db.First(&record, recordID); result.Error != nil {
+		if result := db.Preload("Tags").First(&record, recordID); result.Error != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
 			return
 		}
 
-		// Check ownership via domain
-		var domain models.Domain
-		db.First(&domain, record.DomainID)
-		if role != "admin" && domain.UserID != userID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		// Check access via domain
+		domain, ok := loadZone(c, db, record.DomainID, "record.write", "records:write")
+		if !ok {
+			return
+		}
+		access := recordAccess(c, db, domain, "record.write")
+		if !authorizeRecord(c, access, record.Name) {
+			return
+		}
+		if !requireUnlocked(c, domain, "update") {
 			return
 		}
 
@@ -293,28 +461,85 @@ func UpdateRecord(db *gorm.DB) gin.HandlerFunc {
 			Content string `json:"content"`
 			TTL     int    `json:"ttl"`
 			Prio    int    `json:"prio"`
+
+			ActivateAt *time.Time `json:"activate_at"`
+			ExpireAt   *time.Time `json:"expire_at"`
+
+			Comment *string             `json:"comment"`
+			Tags    *[]models.RecordTag `json:"tags"` // Replaces all tags when present
 		}
 		if err := c.ShouldBindJSON(&input); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
+		before := record
+
 		// Update fields
+		var err error
+		if input.Name, err = normalizeRecordName(input.Name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		if input.Name != "" {
+			// A record cannot be moved out of the caller's subtree
+			if !authorizeRecord(c, access, input.Name) {
+				return
+			}
 			record.Name = input.Name
 		}
 		if input.Type != "" {
 			record.Type = input.Type
 		}
 		if input.Content != "" {
-			record.Content = input.Content
+			if record.Content, err = normalizeRecordContent(record.Type, input.Content); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 		}
 		if input.TTL > 0 {
 			record.TTL = input.TTL
 		}
 		record.Prio = input.Prio
+		if input.ActivateAt != nil {
+			record.ActivateAt = input.ActivateAt
+		}
+		if input.ExpireAt != nil {
+			record.ExpireAt = input.ExpireAt
+		}
+		if err := applyRecordWindow(&record); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if input.Comment != nil {
+			record.Comment = *input.Comment
+		}
+		replaceTags := input.Tags != nil
+		if replaceTags {
+			tags, err := normalizeTags(*input.Tags)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			record.Tags = tags
+		}
 
-		db.Save(&record)
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if replaceTags {
+				if err := tx.Where("record_id = ?", record.ID).Delete(&models.RecordTag{}).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Save(&record).Error; err != nil {
+				return err
+			}
+			return recordHistory(tx, domain.ID, "update", actorID(c), "", &before, &record)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update record: " + err.Error()})
+			return
+		}
+		record.UnicodeName = toUnicodeName(record.Name)
 		c.JSON(http.StatusOK, record)
 	}
 }
@@ -322,9 +547,7 @@ func UpdateRecord(db *gorm.DB) gin.HandlerFunc {
 // UpdateUser modifies an existing user (admin only)
 func UpdateUser(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		adminRole := c.MustGet("role").(string)
-		if adminRole != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		if !requireAdmin(c, db, "user.manage", "users:admin") {
 			return
 		}
 
@@ -347,14 +570,33 @@ func UpdateUser(db *gorm.DB) gin.HandlerFunc {
 			ContactState   string `json:"contact_state"`
 			ContactZip     string `json:"contact_zip"`
 			ContactCountry string `json:"contact_country"`
+			DomainQuota    *int   `json:"domain_quota"`
 		}
 		if err := c.ShouldBindJSON(&input); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if input.DomainQuota != nil && *input.DomainQuota < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "domain_quota must not be negative"})
+			return
+		}
+		if input.Role != "" && !roleExists(db, input.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role: " + input.Role})
+			return
+		}
+		if input.Role != "" && input.Role != user.Role {
+			if user.ID == c.MustGet("user_id").(uint) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "You cannot change your own role"})
+				return
+			}
+			// Both the old and the new role must be within the caller's reach
+			if !requireAssignableRole(c, db, user.Role) || !requireAssignableRole(c, db, input.Role) {
+				return
+			}
+		}
 
 		// Prevent demoting the last admin
-		if user.Role == "admin" && input.Role == "user" {
+		if user.Role == "admin" && input.Role != "" && input.Role != "admin" {
 			var adminCount int64
 			db.Model(&models.User{}).Where("role = ?", "admin").Count(&adminCount)
 			if adminCount <= 1 {
@@ -363,6 +605,8 @@ func UpdateUser(db *gorm.DB) gin.HandlerFunc {
 			}
 		}
 
+		before := user
+
 		if input.Username != "" {
 			user.Username = input.Username
 		}
@@ -379,11 +623,22 @@ func UpdateUser(db *gorm.DB) gin.HandlerFunc {
 		user.ContactState = input.ContactState
 		user.ContactZip = input.ContactZip
 		user.ContactCountry = input.ContactCountry
+		if user.ContactEmail != before.ContactEmail {
+			user.EmailVerified = false
+		}
+		if input.DomainQuota != nil {
+			user.DomainQuota = *input.DomainQuota
+		}
 
 		if err := db.Save(&user).Error; err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save user: " + err.Error()})
             return
         }
+		action := "user.update"
+		if before.Role != user.Role {
+			action = "user.role_change"
+		}
+		audit(c, action, "user", user.ID, before, user)
 		c.JSON(http.StatusOK, user)
 	}
 }
@@ -391,9 +646,7 @@ func UpdateUser(db *gorm.DB) gin.HandlerFunc {
 // CreateUser creates a new user (admin only)
 func CreateUser(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		roleCtx := c.MustGet("role").(string)
-		if roleCtx != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		if !requireAdmin(c, db, "user.manage", "users:admin") {
 			return
 		}
 
@@ -410,12 +663,27 @@ func CreateUser(db *gorm.DB) gin.HandlerFunc {
 			ContactState   string `json:"contact_state"`
 			ContactZip     string `json:"contact_zip"`
 			ContactCountry string `json:"contact_country"`
+			DomainQuota    int    `json:"domain_quota"`
 		}
 
 		if err := c.ShouldBindJSON(&input); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if input.DomainQuota < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "domain_quota must not be negative"})
+			return
+		}
+		if input.Role == "" {
+			input.Role = "user"
+		}
+		if !roleExists(db, input.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role: " + input.Role})
+			return
+		}
+		if !requireAssignableRole(c, db, input.Role) {
+			return
+		}
 
         // Check if user exists
         var count int64
@@ -444,15 +712,14 @@ func CreateUser(db *gorm.DB) gin.HandlerFunc {
             ContactState:   input.ContactState,
             ContactZip:     input.ContactZip,
             ContactCountry: input.ContactCountry,
-        }
-        if user.Role == "" {
-             user.Role = "user"
+            DomainQuota:    input.DomainQuota,
         }
 
 		if err := db.Create(&user).Error; err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user: " + err.Error()})
             return
         }
+		audit(c, "user.create", "user", user.ID, nil, user)
 		c.JSON(http.StatusCreated, user)
 	}
 }
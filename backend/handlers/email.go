@@ -0,0 +1,477 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/mailer"
+	"github.com/localdns/backend/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Mail delivers transactional email; configured from the SMTP_* environment
+var Mail mailer.Mailer = mailer.FromEnv()
+
+const (
+	verifyEmailTTL   = 48 * time.Hour
+	passwordResetTTL = time.Hour
+)
+
+// defaultEmailTemplates are used unless an admin has stored an override.
+// Available fields: RegistrarName, RegistrarURL, Username, Email, Link, ExpiresIn,
+// and for expiry_reminder also Domain, ExpiresAt and DaysLeft.
+var defaultEmailTemplates = map[string]models.EmailTemplate{
+	"verify_email": {
+		Name:    "verify_email",
+		Subject: "Verify your email address for {{.RegistrarName}}",
+		Body: `Hello {{.Username}},
+
+Please confirm that {{.Email}} is your contact address at {{.RegistrarName}} by opening this link:
+
+{{.Link}}
+
+The link expires in {{.ExpiresIn}}. If you did not request this, you can ignore this message.
+
+{{.RegistrarName}}
+{{.RegistrarURL}}
+`,
+	},
+	"password_reset": {
+		Name:    "password_reset",
+		Subject: "Reset your {{.RegistrarName}} password",
+		Body: `Hello {{.Username}},
+
+Someone asked to reset the password of your {{.RegistrarName}} account. To choose a new password, open this link:
+
+{{.Link}}
+
+The link expires in {{.ExpiresIn}} and can only be used once. If you did not request a reset, you can ignore this message; your password has not been changed.
+
+{{.RegistrarName}}
+{{.RegistrarURL}}
+`,
+	},
+	"invitation": {
+		Name:    "invitation",
+		Subject: "You are invited to {{.RegistrarName}}",
+		Body: `Hello,
+
+You have been invited to create an account at {{.RegistrarName}}. To sign up with {{.Email}}, open this link:
+
+{{.Link}}
+
+The invitation expires in {{.ExpiresIn}} and can only be used once.
+
+{{.RegistrarName}}
+{{.RegistrarURL}}
+`,
+	},
+	"account_approved": {
+		Name:    "account_approved",
+		Subject: "Your {{.RegistrarName}} account has been approved",
+		Body: `Hello {{.Username}},
+
+An administrator has approved your account. You can now log in at:
+
+{{.Link}}
+
+{{.RegistrarName}}
+{{.RegistrarURL}}
+`,
+	},
+	"expiry_reminder": {
+		Name:    "expiry_reminder",
+		Subject: "{{.Domain}} expires in {{.DaysLeft}} days",
+		Body: `Hello {{.Username}},
+
+The registration of {{.Domain}} at {{.RegistrarName}} expires on {{.ExpiresAt}}, in {{.DaysLeft}} days. Renew it before then to keep it; after expiry it is only served during the grace period.
+
+{{.Link}}
+
+{{.RegistrarName}}
+{{.RegistrarURL}}
+`,
+	},
+}
+
+type emailData struct {
+	RegistrarName string
+	RegistrarURL  string
+	Username      string
+	Email         string
+	Link          string
+	ExpiresIn     string
+	Domain        string
+	ExpiresAt     string
+	DaysLeft      int
+}
+
+// loadEmailTemplate returns the stored override or the built-in template
+func loadEmailTemplate(db *gorm.DB, name string) (models.EmailTemplate, bool) {
+	var tmpl models.EmailTemplate
+	if db.Where("name = ?", name).First(&tmpl).Error == nil {
+		return tmpl, true
+	}
+	tmpl, ok := defaultEmailTemplates[name]
+	return tmpl, ok
+}
+
+func executeTemplate(text string, data emailData) (string, error) {
+	t, err := template.New("email").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// sendEmail renders a template with the registrar's details and sends it
+func sendEmail(db *gorm.DB, name, to string, data emailData) error {
+	var config models.RegistrarConfig
+	db.First(&config)
+	data.RegistrarName = config.RegistrarName
+	data.RegistrarURL = config.RegistrarURL
+	if data.RegistrarName == "" {
+		data.RegistrarName = "LocalDNS"
+	}
+
+	tmpl, ok := loadEmailTemplate(db, name)
+	if !ok {
+		return fmt.Errorf("unknown email template %q", name)
+	}
+	subject, err := executeTemplate(tmpl.Subject, data)
+	if err != nil {
+		return err
+	}
+	body, err := executeTemplate(tmpl.Body, data)
+	if err != nil {
+		return err
+	}
+	return Mail.Send(mailer.Message{To: to, Subject: subject, Body: body})
+}
+
+// frontendLink builds a link into the web UI, which lives at the registrar URL
+func frontendLink(db *gorm.DB, path, token string) string {
+	var config models.RegistrarConfig
+	db.First(&config)
+	base := strings.TrimSuffix(config.RegistrarURL, "/")
+	if base == "" {
+		base = oidcConfig.FrontendURL
+	}
+	if token == "" {
+		return base + path
+	}
+	return base + path + "?" + url.Values{"token": {token}}.Encode()
+}
+
+func humanDuration(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+	if d%time.Hour == 0 {
+		if d == time.Hour {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d minutes", int(d.Minutes()))
+}
+
+// issueUserToken creates a single-use token, replacing any unused token of
+// the same purpose for the user
+func issueUserToken(db *gorm.DB, userID uint, purpose, email string, ttl time.Duration) (string, error) {
+	token, err := generateToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).Delete(&models.UserToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.UserToken{
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: hashToken(token),
+			Email:     email,
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	return token, err
+}
+
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+// consumeUserToken marks a valid token as used and returns it
+func consumeUserToken(db *gorm.DB, token, purpose string) (models.UserToken, error) {
+	var userToken models.UserToken
+	if err := db.Where("token_hash = ? AND purpose = ?", hashToken(token), purpose).First(&userToken).Error; err != nil {
+		return userToken, errInvalidUserToken
+	}
+	if userToken.UsedAt != nil || time.Now().After(userToken.ExpiresAt) {
+		return userToken, errInvalidUserToken
+	}
+	result := db.Model(&models.UserToken{}).Where("id = ? AND used_at IS NULL", userToken.ID).Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		return userToken, errInvalidUserToken
+	}
+	return userToken, nil
+}
+
+// sendVerificationEmail mails a verification link for the user's contact email
+func sendVerificationEmail(db *gorm.DB, user models.User) error {
+	if user.ContactEmail == "" {
+		return fmt.Errorf("no contact email set")
+	}
+	token, err := issueUserToken(db, user.ID, "verify_email", user.ContactEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	return sendEmail(db, "verify_email", user.ContactEmail, emailData{
+		Username:  user.Username,
+		Email:     user.ContactEmail,
+		Link:      frontendLink(db, "/verify-email", token),
+		ExpiresIn: humanDuration(verifyEmailTTL),
+	})
+}
+
+// VerifyEmail confirms a contact email with the token from the verification mail
+func VerifyEmail(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Token string `json:"token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userToken, err := consumeUserToken(db, input.Token, "verify_email")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+			return
+		}
+		// The address may have changed since the mail was sent
+		result := db.Model(&models.User{}).Where("id = ? AND contact_email = ?", userToken.UserID, userToken.Email).
+			Update("email_verified", true)
+		if result.Error != nil || result.RowsAffected == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This link is for an email address that is no longer on the account"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Email address verified"})
+	}
+}
+
+// ResendVerificationEmail sends a new verification link to the current user
+func ResendVerificationEmail(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireSession(c) {
+			return
+		}
+		user, ok := currentUser(c, db)
+		if !ok {
+			return
+		}
+		if user.EmailVerified {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Email address is already verified"})
+			return
+		}
+		if throttled(c, map[*throttle]string{mailThrottle: "user:" + fmt.Sprint(user.ID)}) {
+			return
+		}
+
+		if err := sendVerificationEmail(db, user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Could not send verification email: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+	}
+}
+
+// RequestPasswordReset mails a reset link to the verified contact email of
+// the account. The response is the same whether or not the account exists.
+func RequestPasswordReset(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Login string `json:"login" binding:"required"` // username or email address
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if throttled(c, map[*throttle]string{mailThrottle: c.ClientIP()}) {
+			return
+		}
+
+		response := gin.H{"message": "If the account exists and has a verified email address, a reset link has been sent"}
+
+		login := strings.TrimSpace(input.Login)
+		var user models.User
+		if err := db.Where("username = ? OR (LOWER(contact_email) = LOWER(?) AND email_verified = ?)", login, login, true).
+			First(&user).Error; err != nil {
+			c.JSON(http.StatusOK, response)
+			return
+		}
+		if !user.EmailVerified || user.ContactEmail == "" || (user.AuthSource != "" && user.AuthSource != "local") {
+			c.JSON(http.StatusOK, response)
+			return
+		}
+		// Do not let one client flood a mailbox
+		key := "user:" + fmt.Sprint(user.ID)
+		if mailThrottle.wait(key) > 0 {
+			c.JSON(http.StatusOK, response)
+			return
+		}
+		mailThrottle.fail(key)
+
+		token, err := issueUserToken(db, user.ID, "password_reset", user.ContactEmail, passwordResetTTL)
+		if err == nil {
+			err = sendEmail(db, "password_reset", user.ContactEmail, emailData{
+				Username:  user.Username,
+				Email:     user.ContactEmail,
+				Link:      frontendLink(db, "/reset-password", token),
+				ExpiresIn: humanDuration(passwordResetTTL),
+			})
+		}
+		if err != nil {
+			log.Printf("Failed to send password reset email to user %d: %v", user.ID, err)
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// ResetPassword sets a new password with a reset token, signs the user out
+// everywhere and lifts any lockout
+func ResetPassword(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Token       string `json:"token" binding:"required"`
+			NewPassword string `json:"new_password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(input.NewPassword) < minPasswordLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "New password must be at least 8 characters"})
+			return
+		}
+
+		userToken, err := consumeUserToken(db, input.Token, "password_reset")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+			return
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), 14)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+		if err := db.Model(&models.User{}).Where("id = ?", userToken.UserID).Updates(map[string]interface{}{
+			"password_hash": string(hash),
+			"failed_logins": 0,
+			"locked_until":  nil,
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password: " + err.Error()})
+			return
+		}
+		revoked, _ := revokeSessions(db, userToken.UserID, 0)
+
+		writeAudit(db, models.AuditLog{
+			ActorID:    userToken.UserID,
+			Action:     "user.password_reset",
+			TargetType: "user",
+			TargetID:   fmt.Sprint(userToken.UserID),
+			IP:         c.ClientIP(),
+			Diff:       jsonDiff(nil, gin.H{"sessions_revoked": revoked}),
+		})
+		c.JSON(http.StatusOK, gin.H{"message": "Password has been reset, please log in"})
+	}
+}
+
+// ListEmailTemplates returns every email template, with built-in defaults
+// for those that have not been customised (admin only)
+func ListEmailTemplates(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "config.write", "whois:admin") {
+			return
+		}
+
+		templates := []gin.H{}
+		for _, name := range []string{"verify_email", "password_reset", "invitation", "account_approved", "expiry_reminder"} {
+			tmpl, custom := loadEmailTemplate(db, name)
+			templates = append(templates, gin.H{"name": name, "subject": tmpl.Subject, "body": tmpl.Body, "customized": custom})
+		}
+		c.JSON(http.StatusOK, templates)
+	}
+}
+
+// UpdateEmailTemplate stores a customised email template (admin only)
+func UpdateEmailTemplate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "config.write", "whois:admin") {
+			return
+		}
+
+		name := c.Param("name")
+		if _, ok := defaultEmailTemplates[name]; !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown email template"})
+			return
+		}
+		var input struct {
+			Subject string `json:"subject" binding:"required"`
+			Body    string `json:"body" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// Render against sample data so broken templates are rejected up front
+		sample := emailData{RegistrarName: "LocalDNS", Username: "user", Email: "user@example.lan", Link: "http://localhost", ExpiresIn: "1 hour",
+			Domain: "example.lan", ExpiresAt: "2006-01-02", DaysLeft: 7}
+		for _, text := range []string{input.Subject, input.Body} {
+			if _, err := executeTemplate(text, sample); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template: " + err.Error()})
+				return
+			}
+		}
+
+		before, _ := loadEmailTemplate(db, name)
+		var tmpl models.EmailTemplate
+		db.Where("name = ?", name).First(&tmpl)
+		tmpl.Name = name
+		tmpl.Subject = input.Subject
+		tmpl.Body = input.Body
+		if err := db.Save(&tmpl).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save template: " + err.Error()})
+			return
+		}
+		audit(c, "email_template.update", "email_template", name, before, tmpl)
+		c.JSON(http.StatusOK, tmpl)
+	}
+}
+
+// ResetEmailTemplate removes a customisation so the built-in template is used again (admin only)
+func ResetEmailTemplate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "config.write", "whois:admin") {
+			return
+		}
+
+		db.Where("name = ?", c.Param("name")).Delete(&models.EmailTemplate{})
+		audit(c, "email_template.reset", "email_template", c.Param("name"), nil, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Template reset to default"})
+	}
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/mailer"
+	"github.com/localdns/backend/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// outbox collects mail instead of sending it
+type outbox struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (o *outbox) Send(msg mailer.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+func (o *outbox) sent() []mailer.Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]mailer.Message(nil), o.messages...)
+}
+
+// useOutbox routes mail into an outbox for the duration of the test
+func useOutbox(t *testing.T) *outbox {
+	t.Helper()
+	o := &outbox{}
+	previous := Mail
+	Mail = o
+	t.Cleanup(func() { Mail = previous })
+	mailThrottle.reset("192.0.2.1")
+	return o
+}
+
+var linkToken = regexp.MustCompile(`\?token=([^\s]+)`)
+
+// tokenFromMail returns the token in the link of a message
+func tokenFromMail(t *testing.T, msg mailer.Message) string {
+	t.Helper()
+	m := linkToken.FindStringSubmatch(msg.Body)
+	if m == nil {
+		t.Fatalf("no link in mail:\n%s", msg.Body)
+	}
+	token, err := url.QueryUnescape(m[1])
+	if err != nil {
+		t.Fatalf("bad token in link: %v", err)
+	}
+	return token
+}
+
+func emailRouter(db *gorm.DB) *gin.Engine {
+	r := gin.New()
+	r.POST("/api/verify-email", VerifyEmail(db))
+	r.POST("/api/password-reset", RequestPasswordReset(db))
+	r.POST("/api/password-reset/confirm", ResetPassword(db))
+	return r
+}
+
+func TestVerifyEmailFlow(t *testing.T) {
+	db := newTestDB(t)
+	out := useOutbox(t)
+	r := emailRouter(db)
+	db.Create(&models.RegistrarConfig{RegistrarName: "Test Registrar", RegistrarURL: "https://registrar.test/"})
+	user := models.User{Username: "alice", Role: "user", ContactEmail: "alice@localdns.lan"}
+	db.Create(&user)
+
+	if err := sendVerificationEmail(db, user); err != nil {
+		t.Fatalf("send verification: %v", err)
+	}
+	sent := out.sent()
+	if len(sent) != 1 || sent[0].To != "alice@localdns.lan" || !strings.Contains(sent[0].Subject, "Test Registrar") ||
+		!strings.Contains(sent[0].Body, "https://registrar.test/verify-email?token=") {
+		t.Fatalf("unexpected verification mail: %+v", sent)
+	}
+	token := tokenFromMail(t, sent[0])
+
+	if w := serve(r, jsonRequest(http.MethodPost, "/api/verify-email", `{"token":"`+token+`"}`)); w.Code != http.StatusOK {
+		t.Fatalf("verify: %d %s", w.Code, w.Body)
+	}
+	db.First(&user, user.ID)
+	if !user.EmailVerified {
+		t.Error("email was not marked verified")
+	}
+
+	// Tokens are single use and bound to their purpose
+	if w := serve(r, jsonRequest(http.MethodPost, "/api/verify-email", `{"token":"`+token+`"}`)); w.Code != http.StatusBadRequest {
+		t.Errorf("reused token: expected 400, got %d", w.Code)
+	}
+	if w := serve(r, jsonRequest(http.MethodPost, "/api/password-reset/confirm", `{"token":"`+token+`","new_password":"new-password"}`)); w.Code != http.StatusBadRequest {
+		t.Errorf("verify token as reset token: expected 400, got %d", w.Code)
+	}
+}
+
+func TestVerifyEmailRejectsStaleAddress(t *testing.T) {
+	db := newTestDB(t)
+	out := useOutbox(t)
+	r := emailRouter(db)
+	user := models.User{Username: "alice", Role: "user", ContactEmail: "alice@localdns.lan"}
+	db.Create(&user)
+
+	if err := sendVerificationEmail(db, user); err != nil {
+		t.Fatalf("send verification: %v", err)
+	}
+	token := tokenFromMail(t, out.sent()[0])
+	db.Model(&user).Update("contact_email", "mallory@evil.test")
+
+	if w := serve(r, jsonRequest(http.MethodPost, "/api/verify-email", `{"token":"`+token+`"}`)); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a changed address, got %d", w.Code)
+	}
+	db.First(&user, user.ID)
+	if user.EmailVerified {
+		t.Error("the new address was marked verified")
+	}
+
+	// A newer link replaces the older one
+	if err := sendVerificationEmail(db, user); err != nil {
+		t.Fatalf("send verification: %v", err)
+	}
+	first := tokenFromMail(t, out.sent()[1])
+	if err := sendVerificationEmail(db, user); err != nil {
+		t.Fatalf("send verification: %v", err)
+	}
+	if w := serve(r, jsonRequest(http.MethodPost, "/api/verify-email", `{"token":"`+first+`"}`)); w.Code != http.StatusBadRequest {
+		t.Errorf("replaced token: expected 400, got %d", w.Code)
+	}
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	db := newTestDB(t)
+	out := useOutbox(t)
+	r := emailRouter(db)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("old-password"), bcrypt.MinCost)
+	locked := time.Now().Add(time.Hour)
+	user := models.User{Username: "alice", Role: "user", PasswordHash: string(hash), ContactEmail: "alice@localdns.lan",
+		EmailVerified: true, FailedLogins: 3, LockedUntil: &locked}
+	db.Create(&user)
+	db.Create(&models.Session{UserID: user.ID, RefreshHash: "refresh", ExpiresAt: time.Now().Add(time.Hour)})
+
+	w := serve(r, jsonRequest(http.MethodPost, "/api/password-reset", `{"login":"ALICE@localdns.lan"}`))
+	if w.Code != http.StatusOK || len(out.sent()) != 1 {
+		t.Fatalf("request reset: %d, %d mails", w.Code, len(out.sent()))
+	}
+	token := tokenFromMail(t, out.sent()[0])
+
+	if w := serve(r, jsonRequest(http.MethodPost, "/api/password-reset/confirm", `{"token":"`+token+`","new_password":"short"}`)); w.Code != http.StatusBadRequest {
+		t.Errorf("short password: expected 400, got %d", w.Code)
+	}
+	if w := serve(r, jsonRequest(http.MethodPost, "/api/password-reset/confirm", `{"token":"`+token+`","new_password":"new-password"}`)); w.Code != http.StatusOK {
+		t.Fatalf("reset: %d %s", w.Code, w.Body)
+	}
+	user = models.User{}
+	db.Where("username = ?", "alice").First(&user)
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte("new-password")) != nil {
+		t.Error("password was not changed")
+	}
+	if user.FailedLogins != 0 || user.LockedUntil != nil {
+		t.Errorf("lockout was not lifted: failed_logins=%d locked_until=%v", user.FailedLogins, user.LockedUntil)
+	}
+	var active int64
+	db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Count(&active)
+	if active != 0 {
+		t.Errorf("expected every session to be revoked, %d remain", active)
+	}
+
+	if w := serve(r, jsonRequest(http.MethodPost, "/api/password-reset/confirm", `{"token":"`+token+`","new_password":"other-password"}`)); w.Code != http.StatusBadRequest {
+		t.Errorf("reused token: expected 400, got %d", w.Code)
+	}
+}
+
+func TestPasswordResetExpiredToken(t *testing.T) {
+	db := newTestDB(t)
+	useOutbox(t)
+	r := emailRouter(db)
+	user := models.User{Username: "alice", Role: "user", ContactEmail: "alice@localdns.lan", EmailVerified: true}
+	db.Create(&user)
+
+	token, err := issueUserToken(db, user.ID, "password_reset", user.ContactEmail, passwordResetTTL)
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	db.Model(&models.UserToken{}).Where("user_id = ?", user.ID).Update("expires_at", time.Now().Add(-time.Minute))
+
+	if w := serve(r, jsonRequest(http.MethodPost, "/api/password-reset/confirm", `{"token":"`+token+`","new_password":"new-password"}`)); w.Code != http.StatusBadRequest {
+		t.Errorf("expired token: expected 400, got %d", w.Code)
+	}
+}
+
+func TestPasswordResetOnlyMailsVerifiedLocalAccounts(t *testing.T) {
+	db := newTestDB(t)
+	out := useOutbox(t)
+	r := emailRouter(db)
+	db.Create(&models.User{Username: "unverified", Role: "user", ContactEmail: "unverified@localdns.lan"})
+	db.Create(&models.User{Username: "external", Role: "user", ContactEmail: "external@localdns.lan", EmailVerified: true, AuthSource: "oidc"})
+
+	var bodies []string
+	for _, login := range []string{"unverified", "unverified@localdns.lan", "external", "nobody"} {
+		w := serve(r, jsonRequest(http.MethodPost, "/api/password-reset", `{"login":"`+login+`"}`))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", login, w.Code)
+		}
+		bodies = append(bodies, w.Body.String())
+	}
+	if n := len(out.sent()); n != 0 {
+		t.Errorf("expected no mail, %d sent", n)
+	}
+	for _, body := range bodies[1:] {
+		if body != bodies[0] {
+			t.Errorf("responses differ, revealing which accounts exist: %s vs %s", bodies[0], body)
+		}
+	}
+}
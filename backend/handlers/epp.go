@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+// eppStatuses are the EPP status codes (RFC 5731) that can be set on a
+// domain. client codes can be set and lifted by anyone who may change the
+// domain; server codes need domain.lock.
+var eppStatuses = []string{
+	"clientDeleteProhibited", "clientHold", "clientRenewProhibited", "clientTransferProhibited", "clientUpdateProhibited",
+	"serverDeleteProhibited", "serverHold", "serverRenewProhibited", "serverTransferProhibited", "serverUpdateProhibited",
+}
+
+// holdStatuses keep a domain's records out of DNS
+var holdStatuses = []string{"clientHold", "serverHold"}
+
+// onHold reports whether a hold status is set on a domain
+func onHold(domain models.Domain) bool {
+	for _, status := range holdStatuses {
+		if domain.EPPStatuses.Contains(status) {
+			return true
+		}
+	}
+	return false
+}
+
+// prohibitedBy returns the status code on a domain that prohibits action
+// ("delete", "renew", "transfer" or "update"), or "" when it is allowed
+func prohibitedBy(domain models.Domain, action string) string {
+	suffix := strings.ToUpper(action[:1]) + action[1:] + "Prohibited"
+	for _, status := range []string{"server" + suffix, "client" + suffix} {
+		if domain.EPPStatuses.Contains(status) {
+			return status
+		}
+	}
+	return ""
+}
+
+// requireUnlocked checks that no status code on the domain prohibits action.
+// Writes a 403 response and returns false when it does.
+func requireUnlocked(c *gin.Context, domain models.Domain, action string) bool {
+	if status := prohibitedBy(domain, action); status != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Domain status %s prohibits this %s", status, action)})
+		return false
+	}
+	return true
+}
+
+// eppStatusCodes returns the EPP status codes of a domain as WHOIS shows
+// them: its lifecycle stage, the codes set on it and pendingTransfer, or
+// "ok" when there are none
+func eppStatusCodes(domain models.Domain, pendingTransfer bool) []string {
+	var codes []string
+	switch domain.Status {
+	case "grace":
+		codes = append(codes, "autoRenewPeriod")
+	case "redemption":
+		codes = append(codes, "redemptionPeriod", "pendingDelete")
+	case "pending_delete":
+		codes = append(codes, "pendingDelete")
+	case "suspended":
+		codes = append(codes, "serverHold")
+	}
+	for _, status := range domain.EPPStatuses {
+		if !models.StringList(codes).Contains(status) {
+			codes = append(codes, status)
+		}
+	}
+	if pendingTransfer {
+		codes = append(codes, "pendingTransfer")
+	}
+	if len(codes) == 0 {
+		codes = append(codes, "ok")
+	}
+	return codes
+}
+
+// SetEPPStatuses replaces the status codes set on a domain. Changing server
+// codes needs domain.lock; setting or lifting a hold takes the domain's
+// records out of DNS or puts them back.
+func SetEPPStatuses(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		domain, ok := loadDomain(c, db, c.Param("id"), "domain.write", "domains:write")
+		if !ok {
+			return
+		}
+
+		var input struct {
+			Statuses []string `json:"epp_statuses"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		statuses := models.StringList{}
+		for _, status := range input.Statuses {
+			if !models.StringList(eppStatuses).Contains(status) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown status code: " + status})
+				return
+			}
+			if !statuses.Contains(status) {
+				statuses = append(statuses, status)
+			}
+		}
+		sort.Strings(statuses)
+
+		// Only domain.lock may add or lift server codes
+		for _, status := range eppStatuses {
+			if strings.HasPrefix(status, "server") && statuses.Contains(status) != domain.EPPStatuses.Contains(status) &&
+				!requirePermission(c, db, "domain.lock") {
+				return
+			}
+		}
+
+		before := gin.H{"epp_statuses": domain.EPPStatuses}
+		wasHeld := onHold(domain)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&domain).Update("epp_statuses", statuses).Error; err != nil {
+				return err
+			}
+			domain.EPPStatuses = statuses
+			if onHold(domain) != wasHeld {
+				if err := holdRecords(tx, domain); err != nil {
+					return err
+				}
+			}
+			comment := "status codes: " + strings.Join(eppStatusCodes(domain, false), ", ")
+			return recordHistory(tx, domain.ID, "update", actorID(c), comment, nil, nil)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update status codes: " + err.Error()})
+			return
+		}
+
+		audit(c, "domain.status", "domain", domain.ID, before, gin.H{"epp_statuses": domain.EPPStatuses})
+		c.JSON(http.StatusOK, domain)
+	}
+}
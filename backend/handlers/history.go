@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// actorID returns the user responsible for the current request
+func actorID(c *gin.Context) uint {
+	return c.MustGet("user_id").(uint)
+}
+
+// snapshotRecord serialises a record for storage in the history table
+func snapshotRecord(record *models.Record) models.JSONText {
+	if record == nil {
+		return ""
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return ""
+	}
+	return models.JSONText(data)
+}
+
+// recordHistory appends a versioned history entry for a domain. before and
+// after are the record state on either side of the change (nil if absent).
+// The domain row is locked first so concurrent writers take versions in turn.
+func recordHistory(tx *gorm.DB, domainID uint, action string, actor uint, comment string, before, after *models.Record) error {
+	var locked []uint
+	if err := tx.Model(&models.Domain{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", domainID).Pluck("id", &locked).Error; err != nil {
+		return err
+	}
+
+	var version uint
+	if err := tx.Model(&models.DomainHistory{}).
+		Where("domain_id = ?", domainID).
+		Select("COALESCE(MAX(version), 0)").
+		Scan(&version).Error; err != nil {
+		return err
+	}
+
+	entry := models.DomainHistory{
+		DomainID: domainID,
+		Version:  version + 1,
+		Action:   action,
+		ActorID:  actor,
+		Comment:  comment,
+		Before:   snapshotRecord(before),
+		After:    snapshotRecord(after),
+	}
+	if before != nil {
+		entry.RecordID = before.ID
+	} else if after != nil {
+		entry.RecordID = after.ID
+	}
+	return tx.Create(&entry).Error
+}
+
+// ListDomainHistory returns the change history of a domain, newest first
+func ListDomainHistory(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		domainID := c.Param("id")
+
+		domain, ok := loadDomain(c, db, domainID, "record.read", "records:read")
+		if !ok {
+			return
+		}
+
+		query := db.Where("domain_id = ?", domain.ID)
+		if recordID := c.Query("record_id"); recordID != "" {
+			query = query.Where("record_id = ?", recordID)
+		}
+		limit := 100
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+
+		var history []models.DomainHistory
+		if result := query.Order("version DESC").Limit(limit).Find(&history); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, history)
+	}
+}
+
+// parseTimestamp accepts RFC 3339 or Unix seconds
+func parseTimestamp(value string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// RollbackDomain restores all records of a domain to their state at ?to=<timestamp>.
+// For every record changed after that point, the Before snapshot of its
+// earliest later change is the state it had at the target time.
+func RollbackDomain(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		domainID := c.Param("id")
+
+		domain, ok := loadDomain(c, db, domainID, "record.write", "records:write")
+		if !ok {
+			return
+		}
+		if !requireUnlocked(c, domain, "update") {
+			return
+		}
+
+		target, err := parseTimestamp(c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter 'to' must be an RFC 3339 timestamp or Unix seconds"})
+			return
+		}
+		if target.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Rollback target must be in the past"})
+			return
+		}
+
+		comment := "rollback to " + target.UTC().Format(time.RFC3339)
+		restored, updated, deleted := 0, 0, 0
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var later []models.DomainHistory
+			if err := tx.Where("domain_id = ? AND record_id <> 0 AND created_at > ?", domain.ID, target).
+				Order("version ASC").Find(&later).Error; err != nil {
+				return err
+			}
+
+			seen := map[uint]bool{}
+			for _, entry := range later {
+				if seen[entry.RecordID] {
+					continue
+				}
+				seen[entry.RecordID] = true
+
+				var current *models.Record
+				var existing models.Record
+				if err := tx.Preload("Tags").Where("id = ? AND domain_id = ?", entry.RecordID, domain.ID).First(&existing).Error; err == nil {
+					current = &existing
+				}
+
+				// Record did not exist at the target time
+				if entry.Before == "" {
+					if current == nil {
+						continue
+					}
+					if err := tx.Delete(current).Error; err != nil {
+						return err
+					}
+					if err := recordHistory(tx, domain.ID, "delete", actorID(c), comment, current, nil); err != nil {
+						return err
+					}
+					deleted++
+					continue
+				}
+
+				var past models.Record
+				if err := json.Unmarshal([]byte(entry.Before), &past); err != nil {
+					return err
+				}
+				past.DomainID = domain.ID
+				// The snapshot's held state belongs to the domain as it was then
+				holdForDomain(&past, domain)
+
+				if current == nil {
+					if err := tx.Create(&past).Error; err != nil {
+						return err
+					}
+					if err := recordHistory(tx, domain.ID, "create", actorID(c), comment, nil, &past); err != nil {
+						return err
+					}
+					restored++
+					continue
+				}
+
+				before := *current
+				if err := tx.Where("record_id = ?", past.ID).Delete(&models.RecordTag{}).Error; err != nil {
+					return err
+				}
+				if err := tx.Save(&past).Error; err != nil {
+					return err
+				}
+				if err := recordHistory(tx, domain.ID, "update", actorID(c), comment, &before, &past); err != nil {
+					return err
+				}
+				updated++
+			}
+			return nil
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Rollback failed: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "Domain rolled back",
+			"to":       target,
+			"restored": restored,
+			"updated":  updated,
+			"deleted":  deleted,
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+func TestRollbackDomainRestoresRecords(t *testing.T) {
+	for _, status := range []string{"active", "redemption"} {
+		t.Run(status, func(t *testing.T) {
+			db := newTestDB(t)
+			user := models.User{Username: "alice", Role: "user"}
+			db.Create(&user)
+			domain := models.Domain{Name: "corp.lan", UserID: user.ID, Status: status, ExpiresAt: time.Now().AddDate(0, 0, 1)}
+			db.Create(&domain)
+
+			www := models.Record{DomainID: domain.ID, Name: "www", Type: "A", Content: "192.0.2.1", TTL: 360}
+			mail := models.Record{DomainID: domain.ID, Name: "mail", Type: "A", Content: "192.0.2.2", TTL: 360}
+			for _, record := range []*models.Record{&www, &mail} {
+				db.Create(record)
+				if err := recordHistory(db, domain.ID, "create", user.ID, "", nil, record); err != nil {
+					t.Fatalf("history: %v", err)
+				}
+			}
+			target := time.Now().Add(-time.Hour)
+			db.Model(&models.DomainHistory{}).Where("domain_id = ?", domain.ID).Update("created_at", target.Add(-time.Hour))
+
+			before := www
+			www.Content = "192.0.2.9"
+			db.Save(&www)
+			recordHistory(db, domain.ID, "update", user.ID, "", &before, &www)
+			db.Delete(&mail)
+			recordHistory(db, domain.ID, "delete", user.ID, "", &mail, nil)
+			ftp := models.Record{DomainID: domain.ID, Name: "ftp", Type: "A", Content: "192.0.2.3", TTL: 360}
+			db.Create(&ftp)
+			recordHistory(db, domain.ID, "create", user.ID, "", nil, &ftp)
+
+			r := signedIn(user)
+			r.POST("/api/domains/:id/rollback", RollbackDomain(db))
+			w := serve(r, jsonRequest(http.MethodPost, fmt.Sprintf("/api/domains/%d/rollback?to=%d", domain.ID, target.Unix()), ""))
+			if w.Code != http.StatusOK {
+				t.Fatalf("rollback: %d %s", w.Code, w.Body)
+			}
+
+			var records []models.Record
+			db.Where("domain_id = ?", domain.ID).Order("name").Find(&records)
+			if len(records) != 2 || records[0].Name != "mail" || records[1].Name != "www" {
+				t.Fatalf("expected mail and www, got %+v", records)
+			}
+			if records[1].Content != "192.0.2.1" {
+				t.Errorf("www not restored: %s", records[1].Content)
+			}
+			held := status != "active"
+			for _, record := range records {
+				if record.Held != held || record.Disabled != held {
+					t.Errorf("%s on a %s domain: held=%v disabled=%v", record.Name, status, record.Held, record.Disabled)
+				}
+			}
+
+			var versions []uint
+			db.Model(&models.DomainHistory{}).Where("domain_id = ?", domain.ID).Order("version").Pluck("version", &versions)
+			for i, version := range versions {
+				if version != uint(i+1) {
+					t.Fatalf("history versions are not a sequence: %v", versions)
+				}
+			}
+			if len(versions) != 8 {
+				t.Errorf("expected 8 history entries, got %d", len(versions))
+			}
+		})
+	}
+}
+
+func TestRecordHistoryNumbersConcurrentWritersInTurn(t *testing.T) {
+	db := newTestDB(t)
+	user := models.User{Username: "alice", Role: "user"}
+	db.Create(&user)
+	domain := models.Domain{Name: "corp.lan", UserID: user.ID, Status: "active"}
+	db.Create(&domain)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := db.Transaction(func(tx *gorm.DB) error {
+				return recordHistory(tx, domain.ID, "update", user.ID, "", nil, nil)
+			})
+			if err != nil {
+				t.Errorf("history: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var versions []uint
+	db.Model(&models.DomainHistory{}).Where("domain_id = ?", domain.ID).Order("version").Pluck("version", &versions)
+	if len(versions) != 10 || versions[0] != 1 || versions[9] != 10 {
+		t.Errorf("expected versions 1 to 10, got %v", versions)
+	}
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/localdns/backend/models"
+	"golang.org/x/net/idna"
+	"gorm.io/gorm"
+)
+
+var (
+	// domainIDNA maps names per UTS 46 (non-transitional, so ß and ς are kept)
+	// and validates them per IDNA2008 with the letters-digits-hyphen rule
+	domainIDNA = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.Transitional(false), idna.VerifyDNSLength(true))
+
+	// recordIDNA is domainIDNA without the LDH rule, so record names such as
+	// _dmarc or *.wiki keep working
+	recordIDNA = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.Transitional(false), idna.StrictDomainName(false))
+)
+
+// hostnameTypes are the record types whose content is a host name
+var hostnameTypes = map[string]bool{"CNAME": true, "NS": true, "MX": true, "PTR": true, "DNAME": true}
+
+// scriptSets are the combinations of scripts a single label may mix;
+// any other combination is treated as a spoofing attempt
+var scriptSets = [][]string{
+	{"Han", "Hiragana", "Katakana"}, // Japanese
+	{"Han", "Hangul"},               // Korean
+	{"Han", "Bopomofo"},             // Chinese with phonetic annotation
+}
+
+// latinLookalikes are Cyrillic and Greek letters that render like Latin
+// ones. A label written only with them reads as a Latin name.
+const latinLookalikes = "аеіјорсухѕԁԛԝһӏαικνορτυχ"
+
+// isASCII reports whether s has no bytes above 0x7F
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// needsIDNA reports whether name holds U-labels or A-labels
+func needsIDNA(name string) bool {
+	return !isASCII(name) || strings.Contains(name, "xn--")
+}
+
+// labelScript returns the script of a rune, or "" for characters shared by
+// all scripts such as digits and hyphens
+func labelScript(r rune) string {
+	if unicode.In(r, unicode.Common, unicode.Inherited) {
+		return ""
+	}
+	for name, table := range unicode.Scripts {
+		if unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
+
+// checkLabelScripts rejects symbols such as emoji, mixed-script labels
+// ("pаypal" with a Cyrillic а) and labels that only use letters confusable
+// with Latin ones ("рое")
+func checkLabelScripts(label string) error {
+	scripts := map[string]bool{}
+	for _, r := range label {
+		if r != '-' && !unicode.In(r, unicode.L, unicode.M, unicode.Nd) {
+			return fmt.Errorf("label %q may only contain letters, digits and hyphens", label)
+		}
+		if script := labelScript(r); script != "" {
+			scripts[script] = true
+		}
+	}
+
+	if len(scripts) > 1 {
+		allowed := false
+		for _, set := range scriptSets {
+			matched := 0
+			for _, script := range set {
+				if scripts[script] {
+					matched++
+				}
+			}
+			if matched == len(scripts) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("label %q mixes scripts", label)
+		}
+	}
+
+	if scripts["Cyrillic"] || scripts["Greek"] {
+		confusable := true
+		for _, r := range label {
+			if unicode.IsLetter(r) && !strings.ContainsRune(latinLookalikes, r) {
+				confusable = false
+				break
+			}
+		}
+		if confusable {
+			return fmt.Errorf("label %q can be confused with a Latin name", label)
+		}
+	}
+	return nil
+}
+
+// toASCIIName converts a name with U-labels or A-labels to its A-label form
+// and checks the scripts of every internationalised label
+func toASCIIName(profile *idna.Profile, name string) (string, error) {
+	ascii, err := profile.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("%s is not a valid internationalised name: %v", name, err)
+	}
+	for _, label := range strings.Split(ascii, ".") {
+		if !strings.HasPrefix(label, "xn--") {
+			continue
+		}
+		unicodeLabel, err := profile.ToUnicode(label)
+		if err != nil {
+			return "", fmt.Errorf("label %q is not valid punycode: %v", label, err)
+		}
+		if err := checkLabelScripts(unicodeLabel); err != nil {
+			return "", err
+		}
+	}
+	return ascii, nil
+}
+
+// toUnicodeName returns the U-label form of a stored A-label name
+func toUnicodeName(name string) string {
+	if !strings.Contains(name, "xn--") {
+		return name
+	}
+	if unicodeName, err := recordIDNA.ToUnicode(name); err == nil {
+		return unicodeName
+	}
+	return name
+}
+
+// lookupName turns a user-supplied domain name, in either form, into the
+// lowercase A-label form used for storage
+func lookupName(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if !isASCII(name) {
+		if ascii, err := domainIDNA.ToASCII(name); err == nil {
+			return ascii
+		}
+	}
+	return name
+}
+
+// normalizeRecordName converts internationalised labels of a record name to
+// A-labels. ASCII names are returned unchanged.
+func normalizeRecordName(name string) (string, error) {
+	if !needsIDNA(name) {
+		return name, nil
+	}
+	fqdn := strings.HasSuffix(name, ".")
+	ascii, err := toASCIIName(recordIDNA, strings.TrimSuffix(strings.TrimSpace(name), "."))
+	if err != nil {
+		return "", err
+	}
+	if fqdn {
+		ascii += "."
+	}
+	return ascii, nil
+}
+
+// normalizeRecordContent converts an internationalised host name in the
+// content of CNAME, NS, MX, PTR and DNAME records to A-labels
+func normalizeRecordContent(recordType, content string) (string, error) {
+	if !hostnameTypes[strings.ToUpper(recordType)] || !needsIDNA(content) {
+		return content, nil
+	}
+	return normalizeRecordName(content)
+}
+
+// withUnicodeNames fills in the U-label form of internationalised record names
+func withUnicodeNames(records []models.Record) {
+	for i := range records {
+		if unicodeName := toUnicodeName(records[i].Name); unicodeName != records[i].Name {
+			records[i].UnicodeName = unicodeName
+		}
+	}
+}
+
+// BackfillUnicodeNames fills in Domain.UnicodeName for domains registered
+// before it existed
+func BackfillUnicodeNames(db *gorm.DB) error {
+	var domains []models.Domain
+	if err := db.Select("id", "name").Where("unicode_name = '' OR unicode_name IS NULL").Find(&domains).Error; err != nil {
+		return err
+	}
+	for _, domain := range domains {
+		if err := db.Model(&models.Domain{}).Where("id = ?", domain.ID).Update("unicode_name", toUnicodeName(domain.Name)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+// impersonationTTL is how long an admin can act as another user before the
+// session ends; it cannot be refreshed
+const impersonationTTL = 15 * time.Minute
+
+// signImpersonationToken creates an access token for user whose "act" claim
+// names the admin really making the requests (RFC 8693 actor claim)
+func signImpersonationToken(user models.User, session models.Session, impersonatorID uint) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss":  "localdns",
+		"sub":  user.ID,
+		"role": user.Role,
+		"sid":  session.ID,
+		"act":  map[string]interface{}{"sub": impersonatorID},
+		"exp":  session.ExpiresAt.Unix(),
+	})
+	return token.SignedString(SecretKey)
+}
+
+// checkImpersonation validates the "act" claim of an access token against
+// its session. Returns the impersonating admin's ID (0 for ordinary
+// sessions) and false when the token must be rejected.
+func checkImpersonation(db *gorm.DB, claims jwt.MapClaims, session models.Session) (uint, bool) {
+	act, hasAct := claims["act"].(map[string]interface{})
+	if session.ImpersonatorID == nil {
+		return 0, !hasAct
+	}
+	if !hasAct {
+		return 0, false
+	}
+	actor, ok := act["sub"].(float64)
+	if !ok || uint(actor) != *session.ImpersonatorID {
+		return 0, false
+	}
+
+	// The admin must still exist and be allowed to impersonate, and the user
+	// must not have gained permissions the admin lacks since
+	var admin, user models.User
+	if err := db.First(&admin, *session.ImpersonatorID).Error; err != nil ||
+		accountStatusError(admin) != "" || !roleHasPermission(db, admin.Role, "user.impersonate") {
+		return 0, false
+	}
+	if err := db.First(&user, session.UserID).Error; err != nil || !roleWithin(actingAs(admin), db, user.Role) {
+		return 0, false
+	}
+	return admin.ID, true
+}
+
+// Impersonate starts a short-lived session as another user, so support can
+// see exactly what the user sees. Requests in the session run with the
+// user's permissions; every change is audited with the admin as impersonator.
+// End it early with POST /api/logout.
+func Impersonate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireSession(c) {
+			return
+		}
+		if !requirePermission(c, db, "user.impersonate") {
+			return
+		}
+
+		adminID := c.MustGet("user_id").(uint)
+		var user models.User
+		if err := db.First(&user, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if user.ID == adminID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot impersonate yourself"})
+			return
+		}
+		if roleHasPermission(db, user.Role, "user.impersonate") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Cannot impersonate a user who can impersonate others"})
+			return
+		}
+		// Impersonation must not reach further than the admin's own role
+		if !roleWithin(c, db, user.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Cannot impersonate a user with permissions you do not hold"})
+			return
+		}
+		if message := accountStatusError(user); message != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": message})
+			return
+		}
+
+		// The refresh token is never handed out, so the session ends at ExpiresAt
+		unused, err := generateToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+			return
+		}
+		now := time.Now()
+		session := models.Session{
+			UserID:         user.ID,
+			RefreshHash:    hashToken(unused),
+			UserAgent:      c.Request.UserAgent(),
+			IP:             c.ClientIP(),
+			LastUsedAt:     now,
+			ExpiresAt:      now.Add(impersonationTTL),
+			ImpersonatorID: &adminID,
+		}
+		if err := db.Create(&session).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start session: " + err.Error()})
+			return
+		}
+		token, err := signImpersonationToken(user, session, adminID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+			return
+		}
+
+		log.Printf("Impersonation: user %d started acting as user %d (session %d)", adminID, user.ID, session.ID)
+		audit(c, "impersonation.start", "user", user.ID, nil, gin.H{"session_id": session.ID, "expires_at": session.ExpiresAt})
+		c.JSON(http.StatusOK, gin.H{
+			"token":        token,
+			"expires_in":   int(impersonationTTL.Seconds()),
+			"expires_at":   session.ExpiresAt,
+			"user":         gin.H{"id": user.ID, "username": user.Username, "role": user.Role},
+			"impersonator": gin.H{"id": adminID},
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/localdns/backend/models"
+)
+
+func TestImpersonationStaysWithinTheAdminsRole(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&models.Role{Name: "support", Permissions: models.StringList{"user.impersonate", "domain.create", "domain.read"}})
+	db.Create(&models.Role{Name: "billing", Permissions: models.StringList{"domain.read", "audit.read"}})
+	support := models.User{Username: "support", Role: "support"}
+	alice := models.User{Username: "alice", Role: "user"}
+	carol := models.User{Username: "carol", Role: "billing"}
+	for _, u := range []*models.User{&support, &alice, &carol} {
+		db.Create(u)
+	}
+
+	r := signedIn(support)
+	r.POST("/api/users/:id/impersonate", Impersonate(db))
+	impersonate := func(user models.User) int {
+		return serve(r, httptest.NewRequest(http.MethodPost, "/api/users/"+fmt.Sprint(user.ID)+"/impersonate", nil)).Code
+	}
+
+	if code := impersonate(carol); code != http.StatusForbidden {
+		t.Errorf("user with audit.read: expected 403, got %d", code)
+	}
+	if code := impersonate(alice); code != http.StatusOK {
+		t.Fatalf("user within the role: expected 200, got %d", code)
+	}
+
+	// A session stops working once the user gains more than the admin has
+	var session models.Session
+	db.Where("user_id = ? AND impersonator_id = ?", alice.ID, support.ID).First(&session)
+	claims := jwt.MapClaims{"act": map[string]interface{}{"sub": float64(support.ID)}}
+	if _, ok := checkImpersonation(db, claims, session); !ok {
+		t.Fatal("fresh impersonation session was rejected")
+	}
+	db.Model(&alice).Update("role", "billing")
+	if _, ok := checkImpersonation(db, claims, session); ok {
+		t.Error("session was accepted after the user's role outgrew the admin's")
+	}
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+// ldapSettings holds the directory configuration read from the environment.
+// Users are located either by trying each DN template in turn, or by
+// searching BaseDN with UserFilter using the service account.
+type ldapSettings struct {
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+
+	UserDNTemplates []string // e.g. uid={username},ou=people,dc=localdns,dc=lan
+	BindDN          string   // service account used for searches
+	BindPassword    string
+	BaseDN          string
+	UserFilter      string // e.g. (uid={username}) or (sAMAccountName={username})
+	UsernameAttr    string
+
+	GroupAttr     string // group DNs on the user entry, e.g. memberOf
+	GroupBaseDN   string // search groups instead when set
+	GroupFilter   string // e.g. (&(objectClass=groupOfNames)(member={dn}))
+	AdminGroups   []string
+	RequiredGroup string // deny login unless the user is in this group
+}
+
+func loadLDAPSettings() ldapSettings {
+	return ldapSettings{
+		URL:                os.Getenv("LDAP_URL"),
+		StartTLS:           os.Getenv("LDAP_START_TLS") == "true",
+		InsecureSkipVerify: os.Getenv("LDAP_INSECURE_SKIP_VERIFY") == "true",
+		Timeout:            10 * time.Second,
+		UserDNTemplates:    strings.Split(os.Getenv("LDAP_USER_DN_TEMPLATES"), ";"),
+		BindDN:             os.Getenv("LDAP_BIND_DN"),
+		BindPassword:       os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:             os.Getenv("LDAP_BASE_DN"),
+		UserFilter:         getEnv("LDAP_USER_FILTER", "(uid={username})"),
+		UsernameAttr:       getEnv("LDAP_USERNAME_ATTRIBUTE", "uid"),
+		GroupAttr:          getEnv("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+		GroupBaseDN:        os.Getenv("LDAP_GROUP_BASE_DN"),
+		GroupFilter:        getEnv("LDAP_GROUP_FILTER", "(&(objectClass=groupOfNames)(member={dn}))"),
+		AdminGroups:        splitList(getEnv("LDAP_ADMIN_GROUPS", "localdns-admins")),
+		RequiredGroup:      os.Getenv("LDAP_REQUIRED_GROUP"),
+	}
+}
+
+var ldapConfig = loadLDAPSettings()
+
+// ldapContactAttributes maps directory attributes onto contact fields
+var ldapContactAttributes = []string{"cn", "displayName", "o", "mail", "telephoneNumber", "street", "l", "st", "postalCode", "c"}
+
+// ldapAuthenticator verifies credentials with an LDAP bind and provisions
+// the user on first login
+type ldapAuthenticator struct {
+	settings ldapSettings
+}
+
+func (ldapAuthenticator) Name() string { return "ldap" }
+
+func (a ldapAuthenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.settings.URL, ldap.DialWithTLSConfig(&tls.Config{InsecureSkipVerify: a.settings.InsecureSkipVerify}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(a.settings.Timeout)
+	if a.settings.StartTLS {
+		if err := conn.StartTLS(&tls.Config{InsecureSkipVerify: a.settings.InsecureSkipVerify}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (a ldapAuthenticator) Authenticate(c *gin.Context, db *gorm.DB, username, password string) (models.User, error) {
+	// An empty password would be an unauthenticated bind, which always succeeds
+	if password == "" || strings.TrimSpace(username) == "" {
+		return models.User{}, errInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return models.User{}, err
+	}
+	defer conn.Close()
+
+	entry, err := a.bindUser(conn, username, password)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	groups, err := a.groups(conn, entry)
+	if err != nil {
+		return models.User{}, err
+	}
+	if a.settings.RequiredGroup != "" && !groupMatches(groups, a.settings.RequiredGroup) {
+		return models.User{}, errInvalidCredentials
+	}
+	role := "user"
+	for _, admin := range a.settings.AdminGroups {
+		if groupMatches(groups, admin) {
+			role = "admin"
+		}
+	}
+
+	if name := entry.GetAttributeValue(a.settings.UsernameAttr); name != "" {
+		username = name
+	}
+
+	// Accounts from other sources must never be taken over by a directory
+	// entry; compare the name the account would be provisioned under
+	var existing models.User
+	if db.Where("username = ?", username).First(&existing).Error == nil && existing.AuthSource != "ldap" {
+		return existing, errUnknownUser
+	}
+
+	name := entry.GetAttributeValue("displayName")
+	if name == "" {
+		name = entry.GetAttributeValue("cn")
+	}
+	return provisionExternalUser(db, c, "ldap", strings.ToLower(entry.DN), username, role, contactInfo{
+		Name:    name,
+		Org:     entry.GetAttributeValue("o"),
+		Email:   entry.GetAttributeValue("mail"),
+		Phone:   entry.GetAttributeValue("telephoneNumber"),
+		Address: entry.GetAttributeValue("street"),
+		City:    entry.GetAttributeValue("l"),
+		State:   entry.GetAttributeValue("st"),
+		Zip:     entry.GetAttributeValue("postalCode"),
+		Country: entry.GetAttributeValue("c"),
+	})
+}
+
+// bindUser authenticates as the user and returns their directory entry
+func (a ldapAuthenticator) bindUser(conn *ldap.Conn, username, password string) (*ldap.Entry, error) {
+	attributes := append([]string{a.settings.UsernameAttr, a.settings.GroupAttr}, ldapContactAttributes...)
+
+	// Search mode: find the entry with the service account, then bind as it
+	if a.settings.BaseDN != "" {
+		if a.settings.BindDN != "" {
+			if err := conn.Bind(a.settings.BindDN, a.settings.BindPassword); err != nil {
+				return nil, fmt.Errorf("service bind: %w", err)
+			}
+		}
+		filter := strings.ReplaceAll(a.settings.UserFilter, "{username}", ldap.EscapeFilter(username))
+		result, err := conn.Search(ldap.NewSearchRequest(a.settings.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+			2, int(a.settings.Timeout.Seconds()), false, filter, attributes, nil))
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Entries) != 1 {
+			return nil, errUnknownUser
+		}
+		entry := result.Entries[0]
+		if err := conn.Bind(entry.DN, password); err != nil {
+			return nil, bindError(err)
+		}
+		return entry, nil
+	}
+
+	// Template mode: try each DN pattern until one binds
+	for _, template := range a.settings.UserDNTemplates {
+		template = strings.TrimSpace(template)
+		if template == "" {
+			continue
+		}
+		dn := strings.ReplaceAll(template, "{username}", ldap.EscapeDN(username))
+		if err := conn.Bind(dn, password); err != nil {
+			if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+				continue
+			}
+			return nil, err
+		}
+		result, err := conn.Search(ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+			1, int(a.settings.Timeout.Seconds()), false, "(objectClass=*)", attributes, nil))
+		if err != nil || len(result.Entries) == 0 {
+			return &ldap.Entry{DN: dn}, nil
+		}
+		return result.Entries[0], nil
+	}
+	return nil, errInvalidCredentials
+}
+
+func bindError(err error) error {
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return errInvalidCredentials
+	}
+	return err
+}
+
+// groups returns the DNs of the groups the entry belongs to
+func (a ldapAuthenticator) groups(conn *ldap.Conn, entry *ldap.Entry) ([]string, error) {
+	if a.settings.GroupBaseDN == "" {
+		return entry.GetAttributeValues(a.settings.GroupAttr), nil
+	}
+	// The user may not be allowed to read groups; search as the service account
+	if a.settings.BindDN != "" {
+		if err := conn.Bind(a.settings.BindDN, a.settings.BindPassword); err != nil {
+			return nil, fmt.Errorf("service bind: %w", err)
+		}
+	}
+	filter := strings.ReplaceAll(a.settings.GroupFilter, "{dn}", ldap.EscapeFilter(entry.DN))
+	result, err := conn.Search(ldap.NewSearchRequest(a.settings.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, int(a.settings.Timeout.Seconds()), false, filter, []string{"dn"}, nil))
+	if err != nil {
+		var ldapErr *ldap.Error
+		if errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.LDAPResultNoSuchObject {
+			return nil, nil
+		}
+		return nil, err
+	}
+	groups := make([]string, 0, len(result.Entries))
+	for _, group := range result.Entries {
+		groups = append(groups, group.DN)
+	}
+	return groups, nil
+}
+
+// groupMatches reports whether want is among groups, either as a full DN or
+// as the group's common name
+func groupMatches(groups []string, want string) bool {
+	for _, group := range groups {
+		if strings.EqualFold(group, want) {
+			return true
+		}
+		if dn, err := ldap.ParseDN(group); err == nil && len(dn.RDNs) > 0 {
+			for _, attr := range dn.RDNs[0].Attributes {
+				if strings.EqualFold(attr.Type, "cn") && strings.EqualFold(attr.Value, want) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/localdns/backend/models"
+)
+
+// The directory tests run against the openldap service from
+// docker-compose.yml, seeded from ldap/bootstrap.ldif:
+//
+//	docker-compose up -d openldap
+//	LDAP_TEST_URL=ldap://localhost:389 go test ./handlers -run LDAP
+const (
+	testPeopleDN = "ou=people,dc=localdns,dc=lan"
+	testGroupsDN = "ou=groups,dc=localdns,dc=lan"
+)
+
+// testLDAPSettings returns settings for the test directory that read groups
+// from memberOf; callers pick template or search mode
+func testLDAPSettings(t *testing.T) ldapSettings {
+	t.Helper()
+	url := os.Getenv("LDAP_TEST_URL")
+	if url == "" {
+		t.Skip("LDAP_TEST_URL is not set")
+	}
+	return ldapSettings{
+		URL:          url,
+		Timeout:      5 * time.Second,
+		UsernameAttr: "uid",
+		GroupAttr:    "memberOf",
+		GroupFilter:  "(&(objectClass=groupOfNames)(member={dn}))",
+		AdminGroups:  []string{"localdns-admins"},
+	}
+}
+
+func templateMode(s ldapSettings) ldapSettings {
+	s.UserDNTemplates = []string{"uid={username},ou=staff,dc=localdns,dc=lan", "uid={username}," + testPeopleDN}
+	return s
+}
+
+func searchMode(s ldapSettings) ldapSettings {
+	s.BaseDN = testPeopleDN
+	s.BindDN = "cn=readonly,dc=localdns,dc=lan"
+	s.BindPassword = "readonly"
+	s.UserFilter = "(uid={username})"
+	return s
+}
+
+func TestLDAPBindWithDNTemplates(t *testing.T) {
+	db := newTestDB(t)
+	a := ldapAuthenticator{settings: templateMode(testLDAPSettings(t))}
+
+	user, err := a.Authenticate(testContext(), db, "alice", "alice123")
+	if err != nil {
+		t.Fatalf("alice: %v", err)
+	}
+	if user.ID == 0 || user.Username != "alice" || user.AuthSource != "ldap" || user.ExternalID != "uid=alice,"+testPeopleDN {
+		t.Errorf("unexpected account: %+v", user)
+	}
+	if user.ContactName != "Alice Admin" || user.ContactEmail != "alice@localdns.lan" || user.ContactPhone != "+1-555-0101" ||
+		user.ContactOrg != "LocalDNS" || user.ContactAddress != "1 Main Street" || user.ContactCity != "Springfield" ||
+		user.ContactState != "IL" || user.ContactZip != "62701" {
+		t.Errorf("contact fields not filled from the directory: %+v", user)
+	}
+
+	for _, tt := range []struct{ username, password string }{
+		{"alice", "wrong"},
+		{"alice", ""},
+		{"carol", "carol123"},
+		{"", "alice123"},
+	} {
+		if _, err := a.Authenticate(testContext(), db, tt.username, tt.password); !errors.Is(err, errInvalidCredentials) {
+			t.Errorf("%q/%q: expected invalid credentials, got %v", tt.username, tt.password, err)
+		}
+	}
+}
+
+func TestLDAPSearchFilter(t *testing.T) {
+	db := newTestDB(t)
+	a := ldapAuthenticator{settings: searchMode(testLDAPSettings(t))}
+
+	user, err := a.Authenticate(testContext(), db, "bob", "bob123")
+	if err != nil {
+		t.Fatalf("bob: %v", err)
+	}
+	if user.Username != "bob" || user.ExternalID != "uid=bob,"+testPeopleDN || user.ContactName != "Bob User" {
+		t.Errorf("unexpected account: %+v", user)
+	}
+	if _, err := a.Authenticate(testContext(), db, "bob", "alice123"); !errors.Is(err, errInvalidCredentials) {
+		t.Errorf("wrong password: expected invalid credentials, got %v", err)
+	}
+
+	// Filter metacharacters in the username are escaped, not interpreted
+	for _, username := range []string{"*", "b*", "bob)(uid=*", "alice)(|(uid=*"} {
+		if _, err := a.Authenticate(testContext(), db, username, "bob123"); !errors.Is(err, errUnknownUser) {
+			t.Errorf("%q: expected unknown user, got %v", username, err)
+		}
+	}
+
+	// Users can be found by another attribute and still get the configured username
+	a.settings.UserFilter = "(&(objectClass=inetOrgPerson)(mail={username}))"
+	user, err = a.Authenticate(testContext(), db, "bob@localdns.lan", "bob123")
+	if err != nil || user.Username != "bob" {
+		t.Errorf("login by mail: got %q, %v", user.Username, err)
+	}
+	var count int64
+	db.Model(&models.User{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected one account, found %d", count)
+	}
+}
+
+func TestLDAPGroupMapping(t *testing.T) {
+	settings := testLDAPSettings(t)
+	modes := map[string]ldapSettings{
+		"memberOf":     templateMode(settings),
+		"group search": searchMode(settings),
+	}
+	modes["group search"] = func(s ldapSettings) ldapSettings {
+		s.GroupBaseDN = testGroupsDN
+		return s
+	}(modes["group search"])
+
+	for name, settings := range modes {
+		t.Run(name, func(t *testing.T) {
+			db := newTestDB(t)
+			a := ldapAuthenticator{settings: settings}
+
+			for username, role := range map[string]string{"alice": "admin", "bob": "user"} {
+				user, err := a.Authenticate(testContext(), db, username, username+"123")
+				if err != nil || user.Role != role {
+					t.Errorf("%s: expected role %q, got %q (%v)", username, role, user.Role, err)
+				}
+			}
+
+			// Admin groups match by full DN as well as by CN
+			a.settings.AdminGroups = []string{"cn=localdns-users," + testGroupsDN}
+			if user, err := a.Authenticate(testContext(), db, "bob", "bob123"); err != nil || user.Role != "admin" {
+				t.Errorf("bob by group DN: expected admin, got %q (%v)", user.Role, err)
+			}
+
+			// Roles follow the directory on every login
+			a.settings.AdminGroups = []string{"nobody"}
+			user, err := a.Authenticate(testContext(), db, "alice", "alice123")
+			if err != nil || user.Role != "user" {
+				t.Errorf("alice after leaving the admin groups: expected user, got %q (%v)", user.Role, err)
+			}
+
+			a.settings.RequiredGroup = "localdns-admins"
+			if _, err := a.Authenticate(testContext(), db, "bob", "bob123"); !errors.Is(err, errInvalidCredentials) {
+				t.Errorf("bob outside the required group: expected invalid credentials, got %v", err)
+			}
+			if _, err := a.Authenticate(testContext(), db, "alice", "alice123"); err != nil {
+				t.Errorf("alice in the required group: %v", err)
+			}
+		})
+	}
+}
+
+func TestLDAPDoesNotTakeOverOtherAccounts(t *testing.T) {
+	db := newTestDB(t)
+	a := ldapAuthenticator{settings: templateMode(testLDAPSettings(t))}
+	db.Create(&models.User{Username: "bob", Role: "admin", AuthSource: "local"})
+	db.Create(&models.User{Username: "alice@localdns.lan", Role: "admin", AuthSource: "oidc", ExternalID: "subject-1"})
+
+	if _, err := a.Authenticate(testContext(), db, "bob", "bob123"); !errors.Is(err, errUnknownUser) {
+		t.Errorf("bob: expected unknown user, got %v", err)
+	}
+
+	// The check uses the name the account is provisioned under, not the one typed
+	a.settings.UsernameAttr = "mail"
+	if _, err := a.Authenticate(testContext(), db, "alice", "alice123"); !errors.Is(err, errUnknownUser) {
+		t.Errorf("alice as alice@localdns.lan: expected unknown user, got %v", err)
+	}
+	user, err := a.Authenticate(testContext(), db, "bob", "bob123")
+	if err != nil || user.Username != "bob@localdns.lan" || user.AuthSource != "ldap" {
+		t.Errorf("bob as bob@localdns.lan: got %+v, %v", user, err)
+	}
+}
+
+func TestGroupMatches(t *testing.T) {
+	groups := []string{"cn=localdns-admins,ou=groups,dc=localdns,dc=lan", "CN=Domain Users,CN=Users,DC=corp,DC=lan"}
+	tests := []struct {
+		want  string
+		match bool
+	}{
+		{"localdns-admins", true},
+		{"LocalDNS-Admins", true},
+		{"cn=localdns-admins,ou=groups,dc=localdns,dc=lan", true},
+		{"domain users", true},
+		{"groups", false},
+		{"localdns", false},
+	}
+	for _, tt := range tests {
+		if got := groupMatches(groups, tt.want); got != tt.match {
+			t.Errorf("groupMatches(%q) = %v, want %v", tt.want, got, tt.match)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+// lifecycleStages are the statuses an expired domain moves through, in
+// order. "expired" is accepted for domains created before the lifecycle.
+var lifecycleStages = []string{"active", "expired", "grace", "redemption", "pending_delete"}
+
+// offlineStatuses are the statuses whose records are not served in DNS
+var offlineStatuses = []string{"redemption", "pending_delete", "suspended"}
+
+// domainServed reports whether a domain's records are served: it is neither
+// in an offline status nor on hold
+func domainServed(domain models.Domain) bool {
+	return !models.StringList(offlineStatuses).Contains(domain.Status) && !onHold(domain)
+}
+
+// holdForDomain sets the held state of a record about to be written from
+// its domain's current state, whatever a snapshot or request said: on a
+// domain that is not served, records that would be enabled or that wait for
+// activate_at are disabled and marked held, as holdRecords does
+func holdForDomain(record *models.Record, domain models.Domain) {
+	if record.Held {
+		record.Held = false
+		record.Disabled = record.ActivateAt != nil
+	}
+	if !domainServed(domain) && (!record.Disabled || record.ActivateAt != nil) {
+		record.Held = true
+		record.Disabled = true
+	}
+}
+
+// stageRank returns the position of a status in lifecycleStages, or -1
+func stageRank(status string) int {
+	for i, stage := range lifecycleStages {
+		if stage == status {
+			return i
+		}
+	}
+	return -1
+}
+
+// lifecycleStage returns the status a domain should have at now given its
+// expiry, or "deleted" once pending delete has ended
+func lifecycleStage(expiresAt time.Time, config models.RegistrarConfig, now time.Time) string {
+	graceEnd := expiresAt.AddDate(0, 0, config.GracePeriod)
+	redemptionEnd := graceEnd.AddDate(0, 0, config.RedemptionPeriod)
+	pendingDeleteEnd := redemptionEnd.AddDate(0, 0, config.PendingDeletePeriod)
+	switch {
+	case now.Before(expiresAt):
+		return "active"
+	case now.Before(graceEnd):
+		return "grace"
+	case now.Before(redemptionEnd):
+		return "redemption"
+	case now.Before(pendingDeleteEnd):
+		return "pending_delete"
+	}
+	return "deleted"
+}
+
+// termDays returns the registration term for a domain: its TLD's default
+// expiry, else the registrar default
+func termDays(db *gorm.DB, name string) int {
+	if dot := strings.Index(name, "."); dot >= 0 {
+		var tld models.TLD
+		if err := db.Where("name = ?", name[dot+1:]).First(&tld).Error; err == nil && tld.DefaultExpiry > 0 {
+			return tld.DefaultExpiry
+		}
+	}
+	var config models.RegistrarConfig
+	if err := db.First(&config).Error; err == nil && config.DefaultExpiry > 0 {
+		return config.DefaultExpiry
+	}
+	return 365
+}
+
+// setDomainStatus changes a domain's status and takes its records out of DNS,
+// or puts them back, to match
+func setDomainStatus(tx *gorm.DB, domain *models.Domain, status string, actor uint, comment string) error {
+	now := time.Now()
+	if err := tx.Model(domain).Updates(map[string]interface{}{"status": status, "status_changed_at": &now}).Error; err != nil {
+		return err
+	}
+	domain.Status = status
+	if err := holdRecords(tx, *domain); err != nil {
+		return err
+	}
+	return recordHistory(tx, domain.ID, "update", actor, comment, nil, nil)
+}
+
+// holdRecords takes a domain's records out of DNS while it is not served,
+// marking them held, and enables the held ones again once it is
+func holdRecords(tx *gorm.DB, domain models.Domain) error {
+	records := tx.Model(&models.Record{}).Where("domain_id = ?", domain.ID).Session(&gorm.Session{})
+	if domainServed(domain) {
+		// Records still waiting for activate_at stay disabled
+		if err := records.Where("held = ? AND activate_at IS NULL", true).
+			Updates(map[string]interface{}{"disabled": false, "held": false}).Error; err != nil {
+			return err
+		}
+		if err := records.Where("held = ?", true).Update("held", false).Error; err != nil {
+			return err
+		}
+	} else {
+		// Records waiting for activate_at are held too, so the scheduler
+		// does not enable them while the domain is not served
+		if err := records.Where("disabled = ? OR activate_at IS NOT NULL", false).
+			Updates(map[string]interface{}{"disabled": true, "held": true}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeDomain deletes a domain with its records, grants and delegations, and
+// cancels its pending transfers and scheduled changes. Each record gets a
+// delete entry in the history so it can still be traced.
+func purgeDomain(tx *gorm.DB, domain models.Domain, actor uint, comment string) error {
+	var records []models.Record
+	if err := tx.Preload("Tags").Where("domain_id = ?", domain.ID).Find(&records).Error; err != nil {
+		return err
+	}
+	for i := range records {
+		if err := recordHistory(tx, domain.ID, "delete", actor, comment, &records[i], nil); err != nil {
+			return err
+		}
+	}
+	for _, model := range []interface{}{&models.Record{}, &models.Grant{}, &models.Delegation{}} {
+		if err := tx.Where("domain_id = ?", domain.ID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	if err := tx.Model(&models.DomainTransfer{}).Where("domain_id = ? AND status = ?", domain.ID, "pending").
+		Updates(map[string]interface{}{"status": "cancelled", "reason": comment, "resolved_by": actor, "resolved_at": &now}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.ScheduledChange{}).Where("domain_id = ? AND status = ?", domain.ID, "pending").
+		Updates(map[string]interface{}{"status": "cancelled", "result": comment}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&domain).Error
+}
+
+// applyLifecycle renews expired domains with auto-renew, moves the others
+// through grace, redemption and pending delete, deletes them at the end, and
+// keeps records of offline domains out of DNS
+func applyLifecycle(db *gorm.DB, now time.Time) {
+	applyAutoRenewals(db, now)
+
+	var config models.RegistrarConfig
+	if err := db.First(&config).Error; err != nil {
+		config = models.RegistrarConfig{GracePeriod: 30, RedemptionPeriod: 30, PendingDeletePeriod: 5}
+	}
+
+	var expired []models.Domain
+	if err := db.Where("status IN ? AND expires_at <= ?", lifecycleStages, now).Find(&expired).Error; err != nil {
+		log.Printf("Lifecycle: failed to load expired domains: %v", err)
+		return
+	}
+	for _, domain := range expired {
+		if domain.ExpiresAt.IsZero() {
+			continue
+		}
+		stage := lifecycleStage(domain.ExpiresAt, config, now)
+		// A delete lock keeps the domain in pending delete until it is lifted
+		if stage == "deleted" && prohibitedBy(domain, "delete") != "" {
+			stage = "pending_delete"
+		}
+		before := gin.H{"status": domain.Status}
+
+		var err error
+		if stage == "deleted" {
+			err = db.Transaction(func(tx *gorm.DB) error {
+				return purgeDomain(tx, domain, 0, "domain deleted after pending delete")
+			})
+		} else if stageRank(stage) > stageRank(domain.Status) {
+			err = db.Transaction(func(tx *gorm.DB) error {
+				return setDomainStatus(tx, &domain, stage, 0, fmt.Sprintf("domain status %s -> %s", domain.Status, stage))
+			})
+		} else {
+			continue
+		}
+		if err != nil {
+			log.Printf("Lifecycle: domain %d (%s) -> %s failed: %v", domain.ID, domain.Name, stage, err)
+			continue
+		}
+
+		log.Printf("Lifecycle: domain %d (%s) is now %s", domain.ID, domain.Name, stage)
+		writeAudit(db, models.AuditLog{
+			Action:     "domain.lifecycle",
+			TargetType: "domain",
+			TargetID:   fmt.Sprint(domain.ID),
+			Diff:       jsonDiff(before, gin.H{"status": stage}),
+		})
+	}
+
+	// Records enabled on an offline or held domain since it went offline
+	offline := db.Model(&models.Domain{}).Select("id").
+		Where("status IN ? OR epp_statuses LIKE ? OR epp_statuses LIKE ?", offlineStatuses, "%clientHold%", "%serverHold%")
+	db.Model(&models.Record{}).Where("disabled = ? AND domain_id IN (?)", false, offline).
+		Updates(map[string]interface{}{"disabled": true, "held": true})
+}
+
+// RestoreDomain brings a domain in grace or redemption back to active for a
+// new term counted from now, and serves its records again
+func RestoreDomain(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		domain, ok := loadDomain(c, db, c.Param("id"), "domain.write", "domains:write")
+		if !ok {
+			return
+		}
+		if domain.Status != "grace" && domain.Status != "redemption" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only domains in grace or redemption can be restored"})
+			return
+		}
+
+		before := gin.H{"status": domain.Status, "expires_at": domain.ExpiresAt}
+		expiresAt := time.Now().AddDate(0, 0, termDays(db, domain.Name))
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&domain).Update("expires_at", expiresAt).Error; err != nil {
+				return err
+			}
+			return setDomainStatus(tx, &domain, "active", actorID(c), "domain restored from "+domain.Status)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore domain: " + err.Error()})
+			return
+		}
+
+		db.First(&domain, domain.ID)
+		audit(c, "domain.restore", "domain", domain.ID, before, gin.H{"status": domain.Status, "expires_at": domain.ExpiresAt})
+		c.JSON(http.StatusOK, domain)
+	}
+}
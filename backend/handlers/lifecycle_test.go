@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+func TestLifecycleKeepsDeleteLockedDomains(t *testing.T) {
+	db := newTestDB(t)
+	user := models.User{Username: "alice", Role: "user"}
+	db.Create(&user)
+	long := time.Now().AddDate(0, 0, -100)
+	locked := models.Domain{Name: "locked.lan", UserID: user.ID, Status: "redemption", ExpiresAt: long,
+		EPPStatuses: models.StringList{"serverDeleteProhibited"}}
+	unlocked := models.Domain{Name: "unlocked.lan", UserID: user.ID, Status: "redemption", ExpiresAt: long}
+	db.Create(&locked)
+	db.Create(&unlocked)
+
+	applyLifecycle(db, time.Now())
+
+	if err := db.First(&unlocked, unlocked.ID).Error; err == nil {
+		t.Error("the unlocked domain was not deleted")
+	}
+	if err := db.First(&locked, locked.ID).Error; err != nil {
+		t.Fatalf("the delete-locked domain was deleted: %v", err)
+	}
+	if locked.Status != "pending_delete" {
+		t.Errorf("expected the locked domain in pending_delete, got %s", locked.Status)
+	}
+}
+
+func TestPurgeDomainCancelsPendingWork(t *testing.T) {
+	db := newTestDB(t)
+	alice := models.User{Username: "alice", Role: "user"}
+	bob := models.User{Username: "bob", Role: "user"}
+	db.Create(&alice)
+	db.Create(&bob)
+	domain := models.Domain{Name: "corp.lan", UserID: alice.ID, Status: "pending_delete", ExpiresAt: time.Now().AddDate(0, 0, -100)}
+	db.Create(&domain)
+	schedule := models.ScheduledChange{DomainID: domain.ID, CreatedBy: alice.ID, RunAt: time.Now().Add(time.Hour), Status: "pending", Changes: "[]"}
+	db.Create(&schedule)
+	transfer := models.DomainTransfer{DomainID: domain.ID, DomainName: domain.Name, FromUserID: alice.ID, ToUserID: bob.ID,
+		Status: "pending", ExpiresAt: time.Now().Add(time.Hour)}
+	db.Create(&transfer)
+
+	if err := db.Transaction(func(tx *gorm.DB) error { return purgeDomain(tx, domain, 0, "domain deleted") }); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+
+	db.First(&schedule, schedule.ID)
+	db.First(&transfer, transfer.ID)
+	if schedule.Status != "cancelled" || transfer.Status != "cancelled" {
+		t.Errorf("expected the schedule and transfer cancelled, got %s and %s", schedule.Status, transfer.Status)
+	}
+}
@@ -0,0 +1,469 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	totpIssuer        = "LocalDNS"
+	totpPeriod        = 30
+	totpDigits        = 6
+	recoveryCodeCount = 10
+	mfaTokenTTL       = 5 * time.Minute
+)
+
+var base32NoPad = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode computes the RFC 6238 code for a time step (HMAC-SHA1, 6 digits)
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// checkTOTP returns the matching time step, allowing one step of clock skew.
+// Steps at or before the last accepted one are rejected so a code works only once.
+func checkTOTP(user models.User, code string) (int64, bool) {
+	secret, err := base32NoPad.DecodeString(user.TOTPSecret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := time.Now().Unix() / totpPeriod
+	for _, step := range []int64{current - 1, current, current + 1} {
+		if step <= user.TOTPLastStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// provisioningURI builds the otpauth:// URI that authenticator apps scan as a QR code
+func provisioningURI(username, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + username)
+	params := url.Values{
+		"secret":    {secret},
+		"issuer":    {totpIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// generateRecoveryCodes replaces the user's recovery codes and returns the
+// new plain codes; only their hashes are stored
+func generateRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(base32NoPad.EncodeToString(buf))
+		code := raw[:4] + "-" + raw[4:]
+		if err := tx.Create(&models.RecoveryCode{UserID: userID, CodeHash: hashToken(raw)}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery code
+func verifySecondFactor(db *gorm.DB, user models.User, code string) bool {
+	code = strings.TrimSpace(code)
+	if step, ok := checkTOTP(user, code); ok {
+		// Conditional update so two concurrent requests cannot both use the step
+		result := db.Model(&models.User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).Update("totp_last_step", step)
+		return result.Error == nil && result.RowsAffected == 1
+	}
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// beginEnrolment stores a fresh, not yet active TOTP secret for the user
+func beginEnrolment(db *gorm.DB, user models.User) (gin.H, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	secret := base32NoPad.EncodeToString(buf)
+	if err := db.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_enabled":   false,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return gin.H{"secret": secret, "provisioning_uri": provisioningURI(user.Username, secret)}, nil
+}
+
+// completeEnrolment activates TOTP once the user proves their authenticator
+// works, and returns a fresh set of recovery codes
+func completeEnrolment(db *gorm.DB, user models.User, code string) ([]string, error) {
+	if user.TOTPEnabled || user.TOTPSecret == "" {
+		return nil, fmt.Errorf("no two-factor enrolment in progress")
+	}
+	step, ok := checkTOTP(user, strings.TrimSpace(code))
+	if !ok {
+		return nil, fmt.Errorf("invalid code")
+	}
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"totp_enabled":   true,
+			"totp_last_step": step,
+		}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = generateRecoveryCodes(tx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+// signMFAToken issues a short-lived token that only the /api/login/mfa
+// endpoints accept. It has no session id, so AuthMiddleware rejects it.
+func signMFAToken(user models.User, purpose string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": "localdns",
+		"sub": user.ID,
+		"typ": purpose,
+		"exp": time.Now().Add(mfaTokenTTL).Unix(),
+	})
+	return token.SignedString(SecretKey)
+}
+
+// parseMFAToken validates an MFA token and loads its user
+func parseMFAToken(db *gorm.DB, tokenString string) (models.User, string, error) {
+	var user models.User
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method")
+		}
+		return SecretKey, nil
+	})
+	if err != nil {
+		return user, "", err
+	}
+	purpose, _ := claims["typ"].(string)
+	sub, ok := claims["sub"].(float64)
+	if !ok || (purpose != "mfa_pending" && purpose != "mfa_enroll") {
+		return user, "", fmt.Errorf("not an MFA token")
+	}
+	if err := db.First(&user, uint(sub)).Error; err != nil {
+		return user, "", err
+	}
+	return user, purpose, nil
+}
+
+// mfaChallenge decides whether a login needs a second step. It returns the
+// response to send instead of a session, or nil when none is needed.
+func mfaChallenge(db *gorm.DB, user models.User) (gin.H, error) {
+	purpose := ""
+	if user.TOTPEnabled {
+		purpose = "mfa_pending"
+	} else if user.Role == "admin" {
+		var config models.RegistrarConfig
+		if db.First(&config).Error == nil && config.RequireAdmin2FA {
+			purpose = "mfa_enroll"
+		}
+	}
+	if purpose == "" {
+		return nil, nil
+	}
+
+	token, err := signMFAToken(user, purpose)
+	if err != nil {
+		return nil, err
+	}
+	if purpose == "mfa_enroll" {
+		return gin.H{"mfa_enrollment_required": true, "mfa_token": token}, nil
+	}
+	return gin.H{"mfa_required": true, "mfa_token": token}, nil
+}
+
+// LoginMFASetup starts enrolment for an admin who must enable two-factor
+// authentication before they can log in
+func LoginMFASetup(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			MFAToken string `json:"mfa_token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		user, purpose, err := parseMFAToken(db, input.MFAToken)
+		if err != nil || purpose != "mfa_enroll" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+			return
+		}
+		if user.TOTPEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is already enabled"})
+			return
+		}
+
+		enrolment, err := beginEnrolment(db, user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start enrolment"})
+			return
+		}
+		c.JSON(http.StatusOK, enrolment)
+	}
+}
+
+// LoginMFA completes a login that returned an mfa_token. For mfa_pending
+// tokens the code may be a TOTP or recovery code; for mfa_enroll tokens it
+// confirms the new authenticator.
+func LoginMFA(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			MFAToken string `json:"mfa_token" binding:"required"`
+			Code     string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		user, purpose, err := parseMFAToken(db, input.MFAToken)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+			return
+		}
+
+		// Wrong codes count towards the same throttling and lockout as wrong passwords
+		if throttled(c, map[*throttle]string{ipThrottle: c.ClientIP(), userThrottle: userThrottleKey(user.Username)}) {
+			return
+		}
+		if until, locked := accountLocked(db, user.Username); locked {
+			c.JSON(http.StatusLocked, gin.H{"error": "Account is temporarily locked", "locked_until": until})
+			return
+		}
+
+		var recoveryCodes []string
+		switch purpose {
+		case "mfa_pending":
+			if !user.TOTPEnabled || !verifySecondFactor(db, user, input.Code) {
+				recordLoginFailure(db, c, user.Username)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+				return
+			}
+		case "mfa_enroll":
+			recoveryCodes, err = completeEnrolment(db, user, input.Code)
+			if err != nil {
+				recordLoginFailure(db, c, user.Username)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		response, err := issueSession(db, c, user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+			return
+		}
+		recordLoginSuccess(db, c, user)
+		if recoveryCodes != nil {
+			response["recovery_codes"] = recoveryCodes
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// SetupTwoFactor generates a new TOTP secret for the current user. It only
+// becomes active after VerifyTwoFactor.
+func SetupTwoFactor(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireSession(c) {
+			return
+		}
+		user, ok := currentUser(c, db)
+		if !ok {
+			return
+		}
+		if user.TOTPEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is already enabled"})
+			return
+		}
+
+		enrolment, err := beginEnrolment(db, user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start enrolment"})
+			return
+		}
+		c.JSON(http.StatusOK, enrolment)
+	}
+}
+
+// VerifyTwoFactor enables TOTP with the first valid code and returns the recovery codes
+func VerifyTwoFactor(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireSession(c) {
+			return
+		}
+		var input struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		user, ok := currentUser(c, db)
+		if !ok {
+			return
+		}
+
+		codes, err := completeEnrolment(db, user, input.Code)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		audit(c, "user.2fa_enable", "user", user.ID, nil, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled", "recovery_codes": codes})
+	}
+}
+
+// DisableTwoFactor turns TOTP off after checking a current code
+func DisableTwoFactor(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireSession(c) {
+			return
+		}
+		var input struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		user, ok := currentUser(c, db)
+		if !ok {
+			return
+		}
+		if !user.TOTPEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+			return
+		}
+		if user.Role == "admin" {
+			var config models.RegistrarConfig
+			if db.First(&config).Error == nil && config.RequireAdmin2FA {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for admins"})
+				return
+			}
+		}
+		if !verifySecondFactor(db, user, input.Code) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+
+		if err := resetTwoFactor(db, user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication: " + err.Error()})
+			return
+		}
+		audit(c, "user.2fa_disable", "user", user.ID, nil, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+	}
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a current code
+func RegenerateRecoveryCodes(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireSession(c) {
+			return
+		}
+		var input struct {
+			Code string `json:"code" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		user, ok := currentUser(c, db)
+		if !ok {
+			return
+		}
+		if !user.TOTPEnabled || !verifySecondFactor(db, user, input.Code) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+
+		codes, err := generateRecoveryCodes(db, user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+			return
+		}
+		audit(c, "user.2fa_recovery_codes", "user", user.ID, nil, nil)
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	}
+}
+
+// resetTwoFactor removes a user's TOTP secret and recovery codes
+func resetTwoFactor(db *gorm.DB, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_secret":    "",
+			"totp_enabled":   false,
+			"totp_last_step": 0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+// ResetUserTwoFactor clears a user's two-factor setup, e.g. after a lost
+// device (admin only)
+func ResetUserTwoFactor(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "user.manage", "users:admin") {
+			return
+		}
+
+		var user models.User
+		if result := db.First(&user, c.Param("id")); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		if err := resetTwoFactor(db, user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset two-factor authentication: " + err.Error()})
+			return
+		}
+		audit(c, "user.2fa_reset", "user", user.ID, nil, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication reset"})
+	}
+}
@@ -2,14 +2,18 @@ package handlers
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+func AuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString := c.GetHeader("Authorization")
 		if tokenString == "" {
@@ -21,6 +25,14 @@ func AuthMiddleware() gin.HandlerFunc {
 			tokenString = tokenString[7:]
 		}
 
+		// Personal API keys are accepted alongside session JWTs
+		if strings.HasPrefix(tokenString, apiKeyPrefix) {
+			if authenticateAPIKey(db, c, tokenString) {
+				c.Next()
+			}
+			return
+		}
+
 		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -33,14 +45,53 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			c.Set("user_id", uint(claims["sub"].(float64)))
-			c.Set("role", claims["role"].(string))
-		} else {
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+			return
+		}
+		sub, subOK := claims["sub"].(float64)
+		sid, sidOK := claims["sid"].(float64)
+		if !subOK || !sidOK {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
 			return
 		}
 
+		// The session must still be live; logout and revocation take effect immediately
+		var session models.Session
+		if err := db.First(&session, uint(sid)).Error; err != nil ||
+			session.UserID != uint(sub) || session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session expired or revoked"})
+			return
+		}
+
+		// Load the user so deletions and role changes apply without waiting for token expiry
+		var user models.User
+		if err := db.First(&user, session.UserID).Error; err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User no longer exists"})
+			return
+		}
+		if message := accountStatusError(user); message != "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": message})
+			return
+		}
+
+		// Impersonation tokens must name the admin behind the session, and
+		// only while that admin may still impersonate
+		impersonatorID, ok := checkImpersonation(db, claims, session)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid impersonation session"})
+			return
+		}
+
+		c.Set("user_id", user.ID)
+		c.Set("role", user.Role)
+		c.Set("session_id", session.ID)
+		if impersonatorID != 0 {
+			c.Set("impersonator_id", impersonatorID)
+			log.Printf("Impersonation: user %d acting as user %d: %s %s", impersonatorID, user.ID, c.Request.Method, c.Request.URL.Path)
+		}
+
 		c.Next()
 	}
 }
@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/localdns/backend/models"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+// oidcSettings holds the single sign-on configuration read from the environment
+type oidcSettings struct {
+	IssuerURL       string // used by the backend for discovery and token exchange
+	PublicIssuerURL string // issuer URL as reachable by the browser, if different
+	ClientID        string
+	ClientSecret    string
+	RedirectURL     string
+	Scopes          []string
+	GroupsClaim     string
+	AdminGroups     []string
+	ProviderName    string
+	FrontendURL     string
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		items = append(items, strings.TrimSpace(item))
+	}
+	return items
+}
+
+func loadOIDCSettings() oidcSettings {
+	return oidcSettings{
+		IssuerURL:       strings.TrimSuffix(os.Getenv("OIDC_ISSUER_URL"), "/"),
+		PublicIssuerURL: strings.TrimSuffix(os.Getenv("OIDC_PUBLIC_ISSUER_URL"), "/"),
+		ClientID:        os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:    os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:     getEnv("OIDC_REDIRECT_URL", "http://localhost:3000/api/auth/oidc/callback"),
+		Scopes:          splitList(getEnv("OIDC_SCOPES", "openid profile email phone address")),
+		GroupsClaim:     getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		AdminGroups:     splitList(getEnv("OIDC_ADMIN_GROUPS", "localdns-admins")),
+		ProviderName:    getEnv("OIDC_PROVIDER_NAME", "Single Sign-On"),
+		FrontendURL:     strings.TrimSuffix(getEnv("FRONTEND_URL", "http://localhost:3000"), "/"),
+	}
+}
+
+func (s oidcSettings) enabled() bool {
+	return s.IssuerURL != "" && s.ClientID != ""
+}
+
+var (
+	oidcConfig   = loadOIDCSettings()
+	oidcMu       sync.Mutex
+	oidcProvider *oidc.Provider
+)
+
+// getOIDCProvider runs discovery on first use so the backend can start
+// before the identity provider is reachable
+func getOIDCProvider(ctx context.Context) (*oidc.Provider, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if oidcProvider != nil {
+		return oidcProvider, nil
+	}
+	provider, err := oidc.NewProvider(ctx, oidcConfig.IssuerURL)
+	if err != nil {
+		return nil, err
+	}
+	oidcProvider = provider
+	return provider, nil
+}
+
+func oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     oidcConfig.ClientID,
+		ClientSecret: oidcConfig.ClientSecret,
+		RedirectURL:  oidcConfig.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       oidcConfig.Scopes,
+	}
+}
+
+// oidcClaims are the ID token claims LocalDNS understands
+type oidcClaims struct {
+	Subject           string      `json:"sub"`
+	PreferredUsername string      `json:"preferred_username"`
+	Email             string      `json:"email"`
+	Name              string      `json:"name"`
+	PhoneNumber       string      `json:"phone_number"`
+	Organization      string      `json:"organization"`
+	Address           oidcAddress `json:"address"`
+}
+
+type oidcAddress struct {
+	StreetAddress string `json:"street_address"`
+	Locality      string `json:"locality"`
+	Region        string `json:"region"`
+	PostalCode    string `json:"postal_code"`
+	Country       string `json:"country"`
+}
+
+// groupsFromClaims reads the configured groups claim, which IdPs send either
+// as a list or as a single string
+func groupsFromClaims(raw map[string]interface{}) []string {
+	switch v := raw[oidcConfig.GroupsClaim].(type) {
+	case []interface{}:
+		groups := make([]string, 0, len(v))
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+		return groups
+	case string:
+		return splitList(v)
+	}
+	return nil
+}
+
+// roleForGroups maps IdP groups onto the LocalDNS roles
+func roleForGroups(groups []string) string {
+	for _, group := range groups {
+		for _, admin := range oidcConfig.AdminGroups {
+			if strings.EqualFold(group, admin) {
+				return "admin"
+			}
+		}
+	}
+	return "user"
+}
+
+// contactInfo is the contact data an external identity source provides
+type contactInfo struct {
+	Name, Org, Email, Phone, Address, City, State, Zip, Country string
+}
+
+// fillContact copies external contact data into the user's contact fields.
+// Fields the user has already filled in are left alone.
+func fillContact(user *models.User, info contactInfo) {
+	set := func(field *string, value string) {
+		if *field == "" && value != "" {
+			*field = value
+		}
+	}
+	set(&user.ContactName, info.Name)
+	set(&user.ContactOrg, info.Org)
+	set(&user.ContactEmail, info.Email)
+	set(&user.ContactPhone, info.Phone)
+	set(&user.ContactAddress, info.Address)
+	set(&user.ContactCity, info.City)
+	set(&user.ContactState, info.State)
+	set(&user.ContactZip, info.Zip)
+	set(&user.ContactCountry, info.Country)
+}
+
+// provisionExternalUser finds the user linked to an external identity,
+// creating it on first login, and keeps its role in sync with the source
+func provisionExternalUser(db *gorm.DB, c *gin.Context, source, externalID, username, role string, info contactInfo) (models.User, error) {
+	var user models.User
+	err := db.Where("auth_source = ? AND external_id = ?", source, externalID).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		var taken int64
+		db.Model(&models.User{}).Where("username = ?", username).Count(&taken)
+		if taken > 0 {
+			return user, fmt.Errorf("username %q is already used by another account", username)
+		}
+
+		user = models.User{
+			Username:   username,
+			Role:       role,
+			AuthSource: source,
+			ExternalID: externalID,
+		}
+		fillContact(&user, info)
+		if err := db.Create(&user).Error; err != nil {
+			return user, err
+		}
+		writeAudit(db, models.AuditLog{
+			ActorID:    user.ID,
+			Action:     "user.provision",
+			TargetType: "user",
+			TargetID:   fmt.Sprint(user.ID),
+			IP:         c.ClientIP(),
+			Diff:       jsonDiff(nil, user),
+		})
+		return user, nil
+	}
+
+	before := user
+	user.Role = role
+	fillContact(&user, info)
+	if err := db.Save(&user).Error; err != nil {
+		return user, err
+	}
+	if before.Role != user.Role {
+		writeAudit(db, models.AuditLog{
+			ActorID:    user.ID,
+			Action:     "user.role_change",
+			TargetType: "user",
+			TargetID:   fmt.Sprint(user.ID),
+			IP:         c.ClientIP(),
+			Diff:       jsonDiff(before, user),
+		})
+	}
+	return user, nil
+}
+
+// provisionOIDCUser finds or creates the user for the ID token subject
+func provisionOIDCUser(db *gorm.DB, c *gin.Context, claims oidcClaims, groups []string) (models.User, error) {
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Email
+	}
+	if username == "" {
+		username = claims.Subject
+	}
+	return provisionExternalUser(db, c, "oidc", claims.Subject, username, roleForGroups(groups), contactInfo{
+		Name:    claims.Name,
+		Org:     claims.Organization,
+		Email:   claims.Email,
+		Phone:   claims.PhoneNumber,
+		Address: claims.Address.StreetAddress,
+		City:    claims.Address.Locality,
+		State:   claims.Address.Region,
+		Zip:     claims.Address.PostalCode,
+		Country: claims.Address.Country,
+	})
+}
+
+// redirectToFrontend finishes the browser flow. Values are passed in the URL
+// fragment so tokens never reach server logs.
+func redirectToFrontend(c *gin.Context, values url.Values) {
+	c.Redirect(http.StatusFound, oidcConfig.FrontendURL+"/oidc/callback#"+values.Encode())
+}
+
+func oidcError(c *gin.Context, message string) {
+	redirectToFrontend(c, url.Values{"error": {message}})
+}
+
+// OIDCConfig tells the login page whether single sign-on is available
+func OIDCConfig() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"enabled": oidcConfig.enabled(),
+			"name":    oidcConfig.ProviderName,
+		})
+	}
+}
+
+// OIDCLogin starts the authorization-code flow with PKCE
+func OIDCLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !oidcConfig.enabled() {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+			return
+		}
+		provider, err := getOIDCProvider(c.Request.Context())
+		if err != nil {
+			log.Printf("OIDC discovery failed: %v", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider unavailable"})
+			return
+		}
+
+		state, _ := generateToken(16)
+		nonce, _ := generateToken(16)
+		verifier := oauth2.GenerateVerifier()
+
+		// The flow state lives in a short-lived signed cookie, not on the server
+		cookie, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"state":    state,
+			"nonce":    nonce,
+			"verifier": verifier,
+			"exp":      time.Now().Add(oidcStateTTL).Unix(),
+		}).SignedString(SecretKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start login"})
+			return
+		}
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(oidcStateCookie, cookie, int(oidcStateTTL.Seconds()), "/api/auth/oidc", "", c.Request.TLS != nil, true)
+
+		authURL := oauth2Config(provider).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+		if oidcConfig.PublicIssuerURL != "" {
+			authURL = strings.Replace(authURL, oidcConfig.IssuerURL, oidcConfig.PublicIssuerURL, 1)
+		}
+		c.Redirect(http.StatusFound, authURL)
+	}
+}
+
+// OIDCCallback completes the flow: it exchanges the code, verifies the ID
+// token, provisions the user and hands a LocalDNS session to the frontend
+func OIDCCallback(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !oidcConfig.enabled() {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+			return
+		}
+		if idpError := c.Query("error"); idpError != "" {
+			oidcError(c, "Identity provider returned: "+idpError)
+			return
+		}
+
+		cookie, err := c.Cookie(oidcStateCookie)
+		c.SetCookie(oidcStateCookie, "", -1, "/api/auth/oidc", "", c.Request.TLS != nil, true)
+		if err != nil {
+			oidcError(c, "Login session expired, please try again")
+			return
+		}
+		flow := jwt.MapClaims{}
+		_, err = jwt.ParseWithClaims(cookie, flow, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method")
+			}
+			return SecretKey, nil
+		})
+		if err != nil || flow["state"] != c.Query("state") {
+			oidcError(c, "Invalid login state, please try again")
+			return
+		}
+		verifier, _ := flow["verifier"].(string)
+		nonce, _ := flow["nonce"].(string)
+
+		provider, err := getOIDCProvider(c.Request.Context())
+		if err != nil {
+			log.Printf("OIDC discovery failed: %v", err)
+			oidcError(c, "Identity provider unavailable")
+			return
+		}
+		token, err := oauth2Config(provider).Exchange(c.Request.Context(), c.Query("code"), oauth2.VerifierOption(verifier))
+		if err != nil {
+			log.Printf("OIDC code exchange failed: %v", err)
+			oidcError(c, "Could not complete login with the identity provider")
+			return
+		}
+		rawIDToken, ok := token.Extra("id_token").(string)
+		if !ok {
+			oidcError(c, "Identity provider did not return an ID token")
+			return
+		}
+		idToken, err := provider.Verifier(&oidc.Config{ClientID: oidcConfig.ClientID}).Verify(c.Request.Context(), rawIDToken)
+		if err != nil || idToken.Nonce != nonce {
+			log.Printf("OIDC ID token rejected: %v", err)
+			oidcError(c, "Invalid ID token")
+			return
+		}
+
+		var claims oidcClaims
+		var raw map[string]interface{}
+		if err := idToken.Claims(&claims); err != nil || idToken.Claims(&raw) != nil {
+			oidcError(c, "Could not read ID token claims")
+			return
+		}
+
+		user, err := provisionOIDCUser(db, c, claims, groupsFromClaims(raw))
+		if err != nil {
+			oidcError(c, err.Error())
+			return
+		}
+		if message := accountStatusError(user); message != "" {
+			oidcError(c, message)
+			return
+		}
+
+		// Two-factor authentication applies to SSO logins too; the frontend
+		// finishes them at /api/login/mfa like a password login
+		challenge, err := mfaChallenge(db, user)
+		if err != nil {
+			oidcError(c, "Could not generate token")
+			return
+		}
+		if challenge != nil {
+			values := url.Values{"mfa_token": {challenge["mfa_token"].(string)}}
+			for _, flag := range []string{"mfa_required", "mfa_enrollment_required"} {
+				if challenge[flag] == true {
+					values.Set(flag, "true")
+				}
+			}
+			redirectToFrontend(c, values)
+			return
+		}
+
+		response, err := issueSession(db, c, user)
+		if err != nil {
+			oidcError(c, "Could not generate token")
+			return
+		}
+		userInfo := response["user"].(gin.H)
+		redirectToFrontend(c, url.Values{
+			"token":         {response["token"].(string)},
+			"refresh_token": {response["refresh_token"].(string)},
+			"user_id":       {fmt.Sprint(userInfo["id"])},
+			"username":      {user.Username},
+			"role":          {user.Role},
+		})
+	}
+}
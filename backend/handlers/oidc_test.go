@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+// mockOIDCProvider is a minimal OpenID provider: it issues a code for every
+// authorization request and checks the PKCE verifier when it is redeemed
+type mockOIDCProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	claims jwt.MapClaims // extra ID token claims for the next login
+	codes  map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	challenge, nonce string
+	claims           jwt.MapClaims
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockOIDCProvider{key: key, codes: map[string]mockAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+			http.Error(w, "PKCE required", http.StatusBadRequest)
+			return
+		}
+		code, _ := generateToken(16)
+		p.mu.Lock()
+		p.codes[code] = mockAuthorization{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), claims: p.claims}
+		p.mu.Unlock()
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		p.mu.Lock()
+		auth, ok := p.codes[r.PostForm.Get("code")]
+		delete(p.codes, r.PostForm.Get("code"))
+		p.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   p.URL,
+			"aud":   "localdns",
+			"sub":   "subject-1",
+			"nonce": auth.nonce,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		}
+		for k, v := range auth.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     idToken,
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// useMockOIDC points the SSO configuration at the mock provider for one test
+func useMockOIDC(t *testing.T, p *mockOIDCProvider) {
+	saved := oidcConfig
+	oidcConfig = oidcSettings{
+		IssuerURL:    p.URL,
+		ClientID:     "localdns",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localdns.test/api/auth/oidc/callback",
+		Scopes:       []string{"openid", "profile", "email"},
+		GroupsClaim:  "groups",
+		AdminGroups:  []string{"localdns-admins"},
+		FrontendURL:  "http://localdns.test",
+	}
+	oidcProvider = nil
+	t.Cleanup(func() {
+		oidcConfig = saved
+		oidcProvider = nil
+	})
+}
+
+func oidcRouter(db *gorm.DB) *gin.Engine {
+	r := gin.New()
+	r.GET("/api/auth/oidc/login", OIDCLogin())
+	r.GET("/api/auth/oidc/callback", OIDCCallback(db))
+	return r
+}
+
+// oidcFlow is a login in progress: the state cookie and the callback the
+// provider redirected the browser to
+type oidcFlow struct {
+	cookie   *http.Cookie
+	callback *url.URL
+}
+
+// startOIDCLogin runs the login redirect and the provider's authorization step
+func startOIDCLogin(t *testing.T, r *gin.Engine, p *mockOIDCProvider, claims jwt.MapClaims) oidcFlow {
+	t.Helper()
+	p.mu.Lock()
+	p.claims = claims
+	p.mu.Unlock()
+
+	w := serve(r, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: status %d: %s", w.Code, w.Body.String())
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oidcStateCookie {
+		t.Fatalf("login: expected the %s cookie, got %v", oidcStateCookie, cookies)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d", resp.StatusCode)
+	}
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+	return oidcFlow{cookie: cookies[0], callback: callback}
+}
+
+// finish delivers the callback and returns the values handed to the frontend
+func (f oidcFlow) finish(t *testing.T, r *gin.Engine) url.Values {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, f.callback.RequestURI(), nil)
+	if f.cookie != nil {
+		req.AddCookie(f.cookie)
+	}
+	w := serve(r, req)
+	if w.Code != http.StatusFound {
+		t.Fatalf("callback: status %d: %s", w.Code, w.Body.String())
+	}
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, "http://localdns.test/oidc/callback#") {
+		t.Fatalf("callback: unexpected redirect %q", location)
+	}
+	values, _ := url.ParseQuery(location[strings.Index(location, "#")+1:])
+	return values
+}
+
+// flowCookie signs a state cookie like OIDCLogin does, for tampered flows
+func flowCookie(t *testing.T, claims jwt.MapClaims) *http.Cookie {
+	t.Helper()
+	claims["exp"] = time.Now().Add(oidcStateTTL).Unix()
+	value, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(SecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Cookie{Name: oidcStateCookie, Value: value}
+}
+
+// flowClaims reads back the state, nonce and verifier of a login
+func flowClaims(t *testing.T, cookie *http.Cookie) jwt.MapClaims {
+	t.Helper()
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(cookie.Value, claims, func(*jwt.Token) (interface{}, error) { return SecretKey, nil }); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	db := newTestDB(t)
+	p := newMockOIDCProvider(t)
+	useMockOIDC(t, p)
+	r := oidcRouter(db)
+
+	values := startOIDCLogin(t, r, p, jwt.MapClaims{
+		"preferred_username": "jdoe",
+		"email":              "jdoe@example.com",
+		"name":               "Jane Doe",
+		"phone_number":       "+1-555-0100",
+		"address":            map[string]string{"locality": "Springfield", "country": "US"},
+	}).finish(t, r)
+	if values.Get("error") != "" || values.Get("token") == "" || values.Get("refresh_token") == "" {
+		t.Fatalf("expected a session, got %v", values)
+	}
+
+	var user models.User
+	if err := db.Where("username = ?", "jdoe").First(&user).Error; err != nil {
+		t.Fatalf("user was not provisioned: %v", err)
+	}
+	if user.AuthSource != "oidc" || user.ExternalID != "subject-1" || user.Role != "user" {
+		t.Errorf("unexpected account: source %q, external id %q, role %q", user.AuthSource, user.ExternalID, user.Role)
+	}
+	if user.ContactName != "Jane Doe" || user.ContactEmail != "jdoe@example.com" || user.ContactPhone != "+1-555-0100" ||
+		user.ContactCity != "Springfield" || user.ContactCountry != "US" {
+		t.Errorf("contact fields not filled from claims: %+v", user)
+	}
+
+	// Later logins find the account by subject, even when the username claim changes
+	startOIDCLogin(t, r, p, jwt.MapClaims{"preferred_username": "jane"}).finish(t, r)
+	var count int64
+	db.Model(&models.User{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected one account, found %d", count)
+	}
+}
+
+func TestOIDCGroupsMapToRoles(t *testing.T) {
+	db := newTestDB(t)
+	p := newMockOIDCProvider(t)
+	useMockOIDC(t, p)
+	r := oidcRouter(db)
+
+	values := startOIDCLogin(t, r, p, jwt.MapClaims{
+		"preferred_username": "jdoe",
+		"groups":             []string{"staff", "LocalDNS-Admins"},
+	}).finish(t, r)
+	if values.Get("role") != "admin" {
+		t.Fatalf("expected admin from the group claim, got %v", values)
+	}
+
+	// Leaving the group at the IdP demotes the account on its next login
+	values = startOIDCLogin(t, r, p, jwt.MapClaims{"groups": []string{"staff"}}).finish(t, r)
+	if values.Get("role") != "user" {
+		t.Fatalf("expected user after leaving the admin group, got %v", values)
+	}
+	var changes int64
+	db.Model(&models.AuditLog{}).Where("action = ?", "user.role_change").Count(&changes)
+	if changes != 1 {
+		t.Errorf("expected the demotion to be audited, found %d role changes", changes)
+	}
+}
+
+func TestOIDCCallbackRejectsInvalidFlows(t *testing.T) {
+	db := newTestDB(t)
+	p := newMockOIDCProvider(t)
+	useMockOIDC(t, p)
+	r := oidcRouter(db)
+
+	tests := []struct {
+		name   string
+		tamper func(f *oidcFlow)
+		error  string
+	}{
+		{"missing state cookie", func(f *oidcFlow) { f.cookie = nil }, "Login session expired, please try again"},
+		{"state mismatch", func(f *oidcFlow) {
+			q := f.callback.Query()
+			q.Set("state", "forged")
+			f.callback.RawQuery = q.Encode()
+		}, "Invalid login state, please try again"},
+		{"cookie not signed by LocalDNS", func(f *oidcFlow) {
+			claims := flowClaims(t, f.cookie)
+			value, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("another secret"))
+			f.cookie = &http.Cookie{Name: oidcStateCookie, Value: value}
+		}, "Invalid login state, please try again"},
+		{"wrong PKCE verifier", func(f *oidcFlow) {
+			claims := flowClaims(t, f.cookie)
+			claims["verifier"] = "not-the-verifier-the-challenge-was-made-from"
+			f.cookie = flowCookie(t, claims)
+		}, "Could not complete login with the identity provider"},
+		{"nonce mismatch", func(f *oidcFlow) {
+			claims := flowClaims(t, f.cookie)
+			claims["nonce"] = "replayed"
+			f.cookie = flowCookie(t, claims)
+		}, "Invalid ID token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow := startOIDCLogin(t, r, p, jwt.MapClaims{"preferred_username": "jdoe"})
+			tt.tamper(&flow)
+			values := flow.finish(t, r)
+			if values.Get("error") != tt.error || values.Get("token") != "" {
+				t.Errorf("expected error %q, got %v", tt.error, values)
+			}
+		})
+	}
+
+	var count int64
+	db.Model(&models.User{}).Count(&count)
+	if count != 0 {
+		t.Errorf("rejected logins provisioned %d account(s)", count)
+	}
+}
+
+func TestOIDCDoesNotTakeOverLocalAccounts(t *testing.T) {
+	db := newTestDB(t)
+	p := newMockOIDCProvider(t)
+	useMockOIDC(t, p)
+	r := oidcRouter(db)
+	db.Create(&models.User{Username: "admin", Role: "admin", AuthSource: "local"})
+
+	values := startOIDCLogin(t, r, p, jwt.MapClaims{"preferred_username": "admin"}).finish(t, r)
+	if values.Get("token") != "" || !strings.Contains(values.Get("error"), "already used") {
+		t.Fatalf("expected the login to be refused, got %v", values)
+	}
+}
+
+func TestGroupsFromClaims(t *testing.T) {
+	tests := []struct {
+		raw  map[string]interface{}
+		want []string
+	}{
+		{map[string]interface{}{"groups": []interface{}{"a", "b", 3}}, []string{"a", "b"}},
+		{map[string]interface{}{"groups": "a, b"}, []string{"a", "b"}},
+		{map[string]interface{}{"roles": []interface{}{"a"}}, nil},
+	}
+	for _, tt := range tests {
+		got := groupsFromClaims(tt.raw)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("groupsFromClaims(%v) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestOIDCLoginRequiresSecondFactor(t *testing.T) {
+	db := newTestDB(t)
+	p := newMockOIDCProvider(t)
+	useMockOIDC(t, p)
+	r := oidcRouter(db)
+	db.Create(&models.RegistrarConfig{RequireAdmin2FA: true})
+
+	// Admins without TOTP must enrol before they get a session
+	values := startOIDCLogin(t, r, p, jwt.MapClaims{"preferred_username": "jdoe", "groups": []string{"localdns-admins"}}).finish(t, r)
+	if values.Get("mfa_enrollment_required") != "true" || values.Get("mfa_token") == "" || values.Get("token") != "" {
+		t.Fatalf("expected an enrolment challenge, got %v", values)
+	}
+	if _, purpose, err := parseMFAToken(db, values.Get("mfa_token")); err != nil || purpose != "mfa_enroll" {
+		t.Errorf("expected an mfa_enroll token, got %q (%v)", purpose, err)
+	}
+
+	// Users with TOTP enabled get the second step whatever their role
+	db.Model(&models.User{}).Where("username = ?", "jdoe").Update("totp_enabled", true)
+	values = startOIDCLogin(t, r, p, jwt.MapClaims{"groups": []string{"staff"}}).finish(t, r)
+	if values.Get("mfa_required") != "true" || values.Get("token") != "" {
+		t.Fatalf("expected a TOTP challenge, got %v", values)
+	}
+	if _, purpose, err := parseMFAToken(db, values.Get("mfa_token")); err != nil || purpose != "mfa_pending" {
+		t.Errorf("expected an mfa_pending token, got %q (%v)", purpose, err)
+	}
+
+	var sessions int64
+	db.Model(&models.Session{}).Count(&sessions)
+	if sessions != 0 {
+		t.Errorf("challenged logins started %d session(s)", sessions)
+	}
+}
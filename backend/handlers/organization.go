@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+// organizationView is an organization together with the caller's role in it
+type organizationView struct {
+	models.Organization
+	Role string `json:"role"` // empty for admins who are not members
+}
+
+// organizationMemberView is a member entry without the user's private details
+type organizationMemberView struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// loadOrganization loads the organization named in the URL and checks that
+// the caller holds at least minRole in it (organization.manage always
+// passes). Writes a 404 or 403 response and returns false when denied.
+func loadOrganization(c *gin.Context, db *gorm.DB, minRole, scope string) (models.Organization, bool) {
+	var org models.Organization
+	if err := db.First(&org, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return org, false
+	}
+	if !can(c, db, "organization.manage") {
+		role := orgRole(db, org.ID, c.MustGet("user_id").(uint))
+		if role == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			return org, false
+		}
+		if orgRoleRank[role] < orgRoleRank[minRole] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Requires the " + minRole + " role in this organization"})
+			return org, false
+		}
+	}
+	return org, requireScope(c, scope, "")
+}
+
+// lastOwner reports whether userID is the only owner of an organization
+func lastOwner(db *gorm.DB, orgID, userID uint) bool {
+	var owners []models.OrganizationMember
+	db.Where("organization_id = ? AND role = ?", orgID, "owner").Find(&owners)
+	return len(owners) == 1 && owners[0].UserID == userID
+}
+
+// organizationOwners returns the user IDs of an organization's owners,
+// earliest member first
+func organizationOwners(db *gorm.DB, orgID uint) []uint {
+	var owners []uint
+	db.Model(&models.OrganizationMember{}).Where("organization_id = ? AND role = ?", orgID, "owner").
+		Order("id ASC").Pluck("user_id", &owners)
+	return owners
+}
+
+// ListOrganizations returns the caller's organizations (all of them with organization.manage)
+func ListOrganizations(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireScope(c, "organizations:read", "") {
+			return
+		}
+		userID := c.MustGet("user_id").(uint)
+
+		var memberships []models.OrganizationMember
+		db.Where("user_id = ?", userID).Find(&memberships)
+		roles := map[uint]string{}
+		for _, m := range memberships {
+			roles[m.OrganizationID] = m.Role
+		}
+
+		query := db.Order("name ASC")
+		if !can(c, db, "organization.manage") {
+			query = query.Where("id IN (?)", db.Model(&models.OrganizationMember{}).Select("organization_id").Where("user_id = ?", userID))
+		}
+		var orgs []models.Organization
+		query.Find(&orgs)
+
+		views := []organizationView{}
+		for _, org := range orgs {
+			views = append(views, organizationView{Organization: org, Role: roles[org.ID]})
+		}
+		c.JSON(http.StatusOK, views)
+	}
+}
+
+// CreateOrganization creates an organization with the caller as its owner
+func CreateOrganization(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireScope(c, "organizations:write", "") {
+			return
+		}
+		var input struct {
+			Name        string `json:"name" binding:"required"`
+			Description string `json:"description"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		org := models.Organization{Name: strings.TrimSpace(input.Name), Description: input.Description}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&org).Error; err != nil {
+				return err
+			}
+			return tx.Create(&models.OrganizationMember{
+				OrganizationID: org.ID,
+				UserID:         c.MustGet("user_id").(uint),
+				Role:           "owner",
+			}).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Organization name probably exists"})
+			return
+		}
+		audit(c, "organization.create", "organization", org.ID, nil, org)
+		c.JSON(http.StatusCreated, organizationView{Organization: org, Role: "owner"})
+	}
+}
+
+// GetOrganization returns an organization with its members and domains
+func GetOrganization(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org, ok := loadOrganization(c, db, "viewer", "organizations:read")
+		if !ok {
+			return
+		}
+
+		members := []organizationMemberView{}
+		db.Model(&models.OrganizationMember{}).
+			Select("organization_members.user_id, users.username, organization_members.role").
+			Joins("JOIN users ON users.id = organization_members.user_id").
+			Where("organization_members.organization_id = ?", org.ID).
+			Order("users.username ASC").
+			Scan(&members)
+
+		var domains []models.Domain
+		db.Select("id", "name", "status", "expires_at").Where("organization_id = ?", org.ID).Order("name ASC").Find(&domains)
+
+		c.JSON(http.StatusOK, gin.H{
+			"organization": org,
+			"role":         orgRole(db, org.ID, c.MustGet("user_id").(uint)),
+			"members":      members,
+			"domains":      domains,
+		})
+	}
+}
+
+// UpdateOrganization renames an organization or changes its description (owners only)
+func UpdateOrganization(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org, ok := loadOrganization(c, db, "owner", "organizations:write")
+		if !ok {
+			return
+		}
+		var input struct {
+			Name        string  `json:"name"`
+			Description *string `json:"description"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		before := org
+		if name := strings.TrimSpace(input.Name); name != "" {
+			org.Name = name
+		}
+		if input.Description != nil {
+			org.Description = *input.Description
+		}
+		if err := db.Save(&org).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Organization name probably exists"})
+			return
+		}
+		audit(c, "organization.update", "organization", org.ID, before, org)
+		c.JSON(http.StatusOK, org)
+	}
+}
+
+// DeleteOrganization deletes an organization that no longer owns domains (owners only)
+func DeleteOrganization(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org, ok := loadOrganization(c, db, "owner", "organizations:write")
+		if !ok {
+			return
+		}
+
+		var domains int64
+		db.Model(&models.Domain{}).Where("organization_id = ?", org.ID).Count(&domains)
+		if domains > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Move or delete the organization's domains first"})
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("organization_id = ?", org.ID).Delete(&models.Delegation{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("organization_id = ?", org.ID).Delete(&models.OrganizationMember{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&org).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete organization: " + err.Error()})
+			return
+		}
+		audit(c, "organization.delete", "organization", org.ID, org, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Organization deleted"})
+	}
+}
+
+// AddOrganizationMember adds a user to an organization (owners only)
+func AddOrganizationMember(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org, ok := loadOrganization(c, db, "owner", "organizations:write")
+		if !ok {
+			return
+		}
+		var input struct {
+			Username string `json:"username" binding:"required"`
+			Role     string `json:"role"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if input.Role == "" {
+			input.Role = "viewer"
+		}
+		if _, known := orgRoleRank[input.Role]; !known {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be 'owner', 'editor' or 'viewer'"})
+			return
+		}
+
+		var user models.User
+		if err := db.Where("username = ?", input.Username).First(&user).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		member := models.OrganizationMember{OrganizationID: org.ID, UserID: user.ID, Role: input.Role}
+		if err := db.Create(&member).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User is already a member"})
+			return
+		}
+		audit(c, "organization.member_add", "organization", org.ID, nil, member)
+		c.JSON(http.StatusCreated, organizationMemberView{UserID: user.ID, Username: user.Username, Role: member.Role})
+	}
+}
+
+// UpdateOrganizationMember changes a member's role (owners only)
+func UpdateOrganizationMember(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org, ok := loadOrganization(c, db, "owner", "organizations:write")
+		if !ok {
+			return
+		}
+		var input struct {
+			Role string `json:"role" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, known := orgRoleRank[input.Role]; !known {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be 'owner', 'editor' or 'viewer'"})
+			return
+		}
+
+		var member models.OrganizationMember
+		if err := db.Where("organization_id = ? AND user_id = ?", org.ID, c.Param("userId")).First(&member).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+		if input.Role != "owner" && lastOwner(db, org.ID, member.UserID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot demote the last owner"})
+			return
+		}
+
+		before := member
+		member.Role = input.Role
+		if err := db.Save(&member).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member: " + err.Error()})
+			return
+		}
+		audit(c, "organization.member_update", "organization", org.ID, before, member)
+		c.JSON(http.StatusOK, member)
+	}
+}
+
+// RemoveOrganizationMember removes a member. Owners can remove anyone;
+// other members can only remove themselves (leave).
+func RemoveOrganizationMember(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var member models.OrganizationMember
+		found := db.Where("organization_id = ? AND user_id = ?", c.Param("id"), c.Param("userId")).First(&member).Error == nil
+
+		minRole := "owner"
+		if found && member.UserID == c.MustGet("user_id").(uint) {
+			minRole = "viewer"
+		}
+		org, ok := loadOrganization(c, db, minRole, "organizations:write")
+		if !ok {
+			return
+		}
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+		if lastOwner(db, org.ID, member.UserID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot remove the last owner"})
+			return
+		}
+
+		if err := db.Delete(&member).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member: " + err.Error()})
+			return
+		}
+		audit(c, "organization.member_remove", "organization", org.ID, member, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+	}
+}
+
+// SetDomainOwner moves a domain into an organization, or back to a single
+// user with organization_id null. The caller needs domain.transfer on the
+// domain and, for an organization, the owner or editor role in it.
+func SetDomainOwner(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		domain, ok := loadDomain(c, db, c.Param("id"), "domain.transfer", "domains:write")
+		if !ok {
+			return
+		}
+		if !requireUnlocked(c, domain, "transfer") {
+			return
+		}
+		var input struct {
+			OrganizationID *uint `json:"organization_id"`
+			UserID         uint  `json:"user_id"` // needs domain.transfer through the role; defaults to the caller
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userID := c.MustGet("user_id").(uint)
+		updates := map[string]interface{}{"organization_id": input.OrganizationID}
+		if input.OrganizationID != nil {
+			var org models.Organization
+			if err := db.First(&org, *input.OrganizationID).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+				return
+			}
+			if !canInOrganization(c, db, org.ID, "domain.create") {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Only owners and editors can move domains into an organization"})
+				return
+			}
+			// The domain stays recorded under an owner of the organization,
+			// the caller when they are one
+			owners := organizationOwners(db, org.ID)
+			if len(owners) == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Organization has no owner"})
+				return
+			}
+			updates["user_id"] = owners[0]
+			for _, owner := range owners {
+				if owner == userID {
+					updates["user_id"] = owner
+				}
+			}
+		} else {
+			owner := userID
+			if input.UserID != 0 && can(c, db, "domain.transfer") {
+				owner = input.UserID
+			}
+			if err := db.First(&models.User{}, owner).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+			updates["user_id"] = owner
+		}
+
+		before := gin.H{"user_id": domain.UserID, "organization_id": domain.OrganizationID}
+		sameOrganization := (domain.OrganizationID == nil) == (input.OrganizationID == nil) &&
+			(input.OrganizationID == nil || *domain.OrganizationID == *input.OrganizationID)
+		changed := updates["user_id"] != domain.UserID || !sameOrganization
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.Domain{}).Where("id = ?", domain.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+			if !changed {
+				return nil
+			}
+			if err := releaseOwnership(tx, domain.ID, actorID(c), "domain changed owner"); err != nil {
+				return err
+			}
+			return recordHistory(tx, domain.ID, "update", actorID(c), "owner changed", nil, nil)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change owner: " + err.Error()})
+			return
+		}
+		db.Preload("Organization").First(&domain, domain.ID)
+		audit(c, "domain.owner_change", "domain", domain.ID, before, gin.H{"user_id": domain.UserID, "organization_id": domain.OrganizationID})
+		c.JSON(http.StatusOK, domain)
+	}
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/localdns/backend/models"
+)
+
+func TestSetDomainOwnerRecordsOrganizationOwner(t *testing.T) {
+	db := newTestDB(t)
+	alice := models.User{Username: "alice", Role: "user"}
+	bob := models.User{Username: "bob", Role: "user"}
+	db.Create(&alice)
+	db.Create(&bob)
+	org := models.Organization{Name: "Ops"}
+	db.Create(&org)
+	db.Create(&models.OrganizationMember{OrganizationID: org.ID, UserID: bob.ID, Role: "owner"})
+	db.Create(&models.OrganizationMember{OrganizationID: org.ID, UserID: alice.ID, Role: "editor"})
+	domain := models.Domain{Name: "ops.lan", UserID: alice.ID, Status: "active"}
+	db.Create(&domain)
+
+	r := signedIn(alice)
+	r.PUT("/api/domains/:id/owner", SetDomainOwner(db))
+	w := serve(r, jsonRequest(http.MethodPut, "/api/domains/"+fmt.Sprint(domain.ID)+"/owner", `{"organization_id":`+fmt.Sprint(org.ID)+`}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("move into organization: %d %s", w.Code, w.Body)
+	}
+	db.First(&domain, domain.ID)
+	if domain.OrganizationID == nil || *domain.OrganizationID != org.ID || domain.UserID != bob.ID {
+		t.Errorf("expected the domain under bob in the organization, got user %d org %v", domain.UserID, domain.OrganizationID)
+	}
+}
+
+func TestSetDomainOwnerDropsWhatThePreviousOwnerSetUp(t *testing.T) {
+	db := newTestDB(t)
+	admin := models.User{Username: "admin", Role: "admin"}
+	alice := models.User{Username: "alice", Role: "user"}
+	bob := models.User{Username: "bob", Role: "user"}
+	carol := models.User{Username: "carol", Role: "user"}
+	for _, u := range []*models.User{&admin, &alice, &bob, &carol} {
+		db.Create(u)
+	}
+	expires := time.Now().Add(time.Hour)
+	domain := models.Domain{Name: "corp.lan", UserID: alice.ID, Status: "active", AuthCodeHash: hashToken("code"), AuthCodeExpiresAt: &expires}
+	db.Create(&domain)
+	db.Create(&models.Grant{UserID: carol.ID, DomainID: domain.ID, Name: "corp.lan", Permissions: models.StringList{"record.write"}})
+	db.Create(&models.Delegation{DomainID: domain.ID, Name: "ci", UserID: &carol.ID, Nameservers: models.StringList{"ns1.carol.lan."}})
+	schedule := models.ScheduledChange{DomainID: domain.ID, CreatedBy: alice.ID, RunAt: expires, Status: "pending", Changes: "[]"}
+	db.Create(&schedule)
+	transfer := models.DomainTransfer{DomainID: domain.ID, DomainName: domain.Name, FromUserID: alice.ID, ToUserID: carol.ID,
+		Status: "pending", ExpiresAt: expires}
+	db.Create(&transfer)
+
+	r := signedIn(admin)
+	r.PUT("/api/domains/:id/owner", SetDomainOwner(db))
+	w := serve(r, jsonRequest(http.MethodPut, "/api/domains/"+fmt.Sprint(domain.ID)+"/owner", `{"user_id":`+fmt.Sprint(bob.ID)+`}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("change owner: %d %s", w.Code, w.Body)
+	}
+
+	var stored models.Domain
+	db.First(&stored, domain.ID)
+	if stored.UserID != bob.ID || stored.AuthCodeHash != "" || stored.AuthCodeExpiresAt != nil {
+		t.Errorf("expected bob to own the domain without an auth code, got user %d code %q", stored.UserID, stored.AuthCodeHash)
+	}
+	for name, model := range map[string]interface{}{"grants": &models.Grant{}, "delegations": &models.Delegation{}} {
+		var count int64
+		db.Model(model).Where("domain_id = ?", domain.ID).Count(&count)
+		if count != 0 {
+			t.Errorf("%d %s left on the domain", count, name)
+		}
+	}
+	db.First(&schedule, schedule.ID)
+	db.First(&transfer, transfer.ID)
+	if schedule.Status != "cancelled" || transfer.Status != "cancelled" {
+		t.Errorf("expected the schedule and transfer cancelled, got %s and %s", schedule.Status, transfer.Status)
+	}
+}
+
+func TestDeleteUserKeepsOwners(t *testing.T) {
+	db := newTestDB(t)
+	admin := models.User{Username: "admin", Role: "admin"}
+	alice := models.User{Username: "alice", Role: "user"}
+	bob := models.User{Username: "bob", Role: "user"}
+	db.Create(&admin)
+	db.Create(&alice)
+	db.Create(&bob)
+	org := models.Organization{Name: "Ops"}
+	db.Create(&org)
+	db.Create(&models.OrganizationMember{OrganizationID: org.ID, UserID: alice.ID, Role: "owner"})
+	orgDomain := models.Domain{Name: "ops.lan", UserID: alice.ID, OrganizationID: &org.ID, Status: "active"}
+	db.Create(&orgDomain)
+	personal := models.Domain{Name: "alice.lan", UserID: alice.ID, Status: "active"}
+	db.Create(&personal)
+
+	r := signedIn(admin)
+	r.DELETE("/api/users/:id", DeleteUser(db))
+	del := func() *httptest.ResponseRecorder {
+		return serve(r, httptest.NewRequest(http.MethodDelete, "/api/users/"+fmt.Sprint(alice.ID), nil))
+	}
+
+	if w := del(); w.Code != http.StatusBadRequest {
+		t.Errorf("last owner: expected 400, got %d", w.Code)
+	}
+	db.Create(&models.OrganizationMember{OrganizationID: org.ID, UserID: bob.ID, Role: "owner"})
+	if w := del(); w.Code != http.StatusBadRequest {
+		t.Errorf("owner of a personal domain: expected 400, got %d", w.Code)
+	}
+	db.Delete(&personal)
+
+	if w := del(); w.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s", w.Code, w.Body)
+	}
+	db.First(&orgDomain, orgDomain.ID)
+	if orgDomain.UserID != bob.ID {
+		t.Errorf("organization domain should move to bob, is under user %d", orgDomain.UserID)
+	}
+	if owners := organizationOwners(db, org.ID); len(owners) != 1 || owners[0] != bob.ID {
+		t.Errorf("unexpected owners %v", owners)
+	}
+}
+
+func TestOrganizationAccessFollowsPermission(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&models.Role{Name: "support", Permissions: models.StringList{"organization.manage"}})
+	support := models.User{Username: "support", Role: "support"}
+	outsider := models.User{Username: "outsider", Role: "user"}
+	db.Create(&support)
+	db.Create(&outsider)
+	org := models.Organization{Name: "Ops"}
+	db.Create(&org)
+
+	for _, tt := range []struct {
+		user models.User
+		code int
+	}{
+		{support, http.StatusOK},
+		{outsider, http.StatusNotFound},
+	} {
+		r := signedIn(tt.user)
+		r.GET("/api/organizations/:id", GetOrganization(db))
+		if w := serve(r, httptest.NewRequest(http.MethodGet, "/api/organizations/"+fmt.Sprint(org.ID), nil)); w.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.user.Username, tt.code, w.Code)
+		}
+	}
+}
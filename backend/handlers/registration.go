@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Registration modes of the registrar config
+var registrationModes = map[string]bool{"open": true, "invite": true, "closed": true}
+
+const defaultInvitationDays = 7
+
+var errInvalidInvitation = errors.New("invitation is invalid or has expired")
+
+// quotaError is returned when a user already owns as many domains as allowed
+type quotaError struct{ quota int }
+
+func (e quotaError) Error() string {
+	return fmt.Sprintf("Domain quota reached (%d domains)", e.quota)
+}
+
+// checkDomainQuota locks the user row and fails with a quotaError if the user
+// is at their quota. Run it in the transaction that adds the domain, so
+// parallel registrations are counted one after the other.
+func checkDomainQuota(tx *gorm.DB, userID uint) error {
+	var user models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+		return err
+	}
+	if user.DomainQuota <= 0 {
+		return nil
+	}
+	var owned int64
+	if err := tx.Model(&models.Domain{}).Where("user_id = ?", userID).Count(&owned).Error; err != nil {
+		return err
+	}
+	if owned >= int64(user.DomainQuota) {
+		return quotaError{quota: user.DomainQuota}
+	}
+	return nil
+}
+
+func registrationMode(config models.RegistrarConfig) string {
+	if config.RegistrationMode == "" {
+		return "open"
+	}
+	return config.RegistrationMode
+}
+
+// accountStatusError explains why a user who is not active may not sign in
+func accountStatusError(user models.User) string {
+	switch user.Status {
+	case "pending":
+		return "Account is awaiting admin approval"
+	case "rejected":
+		return "Account registration was rejected"
+	}
+	return ""
+}
+
+// findInvitation returns the open invitation for token
+func findInvitation(db *gorm.DB, token string) (models.Invitation, error) {
+	var invitation models.Invitation
+	if err := db.Where("token_hash = ?", hashToken(token)).First(&invitation).Error; err != nil {
+		return invitation, errInvalidInvitation
+	}
+	if invitation.AcceptedAt != nil || time.Now().After(invitation.ExpiresAt) {
+		return invitation, errInvalidInvitation
+	}
+	return invitation, nil
+}
+
+// RegistrationPolicy tells the register form whether sign-up is open. With
+// ?invite=<token> it also checks the invitation and returns its email address.
+func RegistrationPolicy(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var config models.RegistrarConfig
+		db.First(&config)
+		response := gin.H{"mode": registrationMode(config), "require_approval": config.RequireApproval}
+
+		if token := c.Query("invite"); token != "" {
+			invitation, err := findInvitation(db, token)
+			if err != nil {
+				response["invitation"] = gin.H{"valid": false}
+			} else {
+				response["invitation"] = gin.H{"valid": true, "email": invitation.Email, "expires_at": invitation.ExpiresAt}
+			}
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// ListInvitations returns all invitations, newest first (admin only).
+// ?status=pending limits the list to invitations that can still be used.
+func ListInvitations(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "user.manage", "users:admin") {
+			return
+		}
+
+		query := db.Order("created_at DESC")
+		if c.Query("status") == "pending" {
+			query = query.Where("accepted_at IS NULL AND expires_at > ?", time.Now())
+		}
+		invitations := []models.Invitation{}
+		query.Find(&invitations)
+		c.JSON(http.StatusOK, invitations)
+	}
+}
+
+// CreateInvitation emails a single-use registration link, optionally preset
+// with a role and domain quota (admin only)
+func CreateInvitation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "user.manage", "users:admin") {
+			return
+		}
+
+		var input struct {
+			Email         string `json:"email" binding:"required,email"`
+			Role          string `json:"role"`
+			DomainQuota   int    `json:"domain_quota"`
+			ExpiresInDays int    `json:"expires_in_days"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if input.Role == "" {
+			input.Role = "user"
+		}
+		if !roleExists(db, input.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role: " + input.Role})
+			return
+		}
+		if !requireAssignableRole(c, db, input.Role) {
+			return
+		}
+		if input.DomainQuota < 0 || input.ExpiresInDays < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "domain_quota and expires_in_days must not be negative"})
+			return
+		}
+		if input.ExpiresInDays == 0 {
+			input.ExpiresInDays = defaultInvitationDays
+		}
+
+		token, err := generateToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate invitation"})
+			return
+		}
+		ttl := time.Duration(input.ExpiresInDays) * 24 * time.Hour
+		invitation := models.Invitation{
+			TokenHash:   hashToken(token),
+			Email:       input.Email,
+			Role:        input.Role,
+			DomainQuota: input.DomainQuota,
+			CreatedBy:   actorID(c),
+			ExpiresAt:   time.Now().Add(ttl),
+		}
+		if err := db.Create(&invitation).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation: " + err.Error()})
+			return
+		}
+
+		// The link is returned as well, so it can be passed on when mail is not configured
+		link := frontendLink(db, "/register", token)
+		emailSent := true
+		if err := sendEmail(db, "invitation", invitation.Email, emailData{
+			Email:     invitation.Email,
+			Link:      link,
+			ExpiresIn: humanDuration(ttl),
+		}); err != nil {
+			log.Printf("Failed to send invitation %d: %v", invitation.ID, err)
+			emailSent = false
+		}
+
+		audit(c, "invitation.create", "invitation", invitation.ID, nil, invitation)
+		c.JSON(http.StatusCreated, gin.H{"invitation": invitation, "link": link, "email_sent": emailSent})
+	}
+}
+
+// RevokeInvitation deletes an invitation that has not been used yet (admin only)
+func RevokeInvitation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "user.manage", "users:admin") {
+			return
+		}
+
+		var invitation models.Invitation
+		if err := db.Where("accepted_at IS NULL").First(&invitation, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found or already used"})
+			return
+		}
+		if err := db.Delete(&invitation).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation: " + err.Error()})
+			return
+		}
+		audit(c, "invitation.revoke", "invitation", invitation.ID, invitation, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+	}
+}
+
+// ApproveUser activates a pending (or previously rejected) registration (admin only)
+func ApproveUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "user.manage", "users:admin") {
+			return
+		}
+
+		var user models.User
+		if result := db.First(&user, c.Param("id")); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if accountStatusError(user) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User is already active"})
+			return
+		}
+
+		if err := db.Model(&models.User{}).Where("id = ?", user.ID).Update("status", "active").Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve user: " + err.Error()})
+			return
+		}
+		if user.ContactEmail != "" {
+			if err := sendEmail(db, "account_approved", user.ContactEmail, emailData{
+				Username: user.Username,
+				Email:    user.ContactEmail,
+				Link:     frontendLink(db, "/", ""),
+			}); err != nil {
+				log.Printf("Failed to send approval email to user %d: %v", user.ID, err)
+			}
+		}
+		audit(c, "user.approve", "user", user.ID, gin.H{"status": user.Status}, gin.H{"status": "active"})
+		c.JSON(http.StatusOK, gin.H{"message": "User approved"})
+	}
+}
+
+// RejectUser turns down a pending registration (admin only). The account is
+// kept, so the username stays taken until an admin deletes it.
+func RejectUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "user.manage", "users:admin") {
+			return
+		}
+
+		var user models.User
+		if result := db.First(&user, c.Param("id")); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if user.Status != "pending" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only pending registrations can be rejected"})
+			return
+		}
+
+		if err := db.Model(&models.User{}).Where("id = ?", user.ID).Update("status", "rejected").Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject user: " + err.Error()})
+			return
+		}
+		revokeSessions(db, user.ID, 0)
+		audit(c, "user.reject", "user", user.ID, gin.H{"status": user.Status}, gin.H{"status": "rejected"})
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Registration of %s rejected", user.Username)})
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/localdns/backend/models"
+)
+
+func TestCheckDomainQuota(t *testing.T) {
+	db := newTestDB(t)
+	user := models.User{Username: "alice", Role: "user", DomainQuota: 2}
+	db.Create(&user)
+	unlimited := models.User{Username: "bob", Role: "user"}
+	db.Create(&unlimited)
+
+	for i, name := range []string{"one.lan", "two.lan"} {
+		if err := checkDomainQuota(db, user.ID); err != nil {
+			t.Fatalf("domain %d: %v", i+1, err)
+		}
+		db.Create(&models.Domain{Name: name, UserID: user.ID, Status: "active"})
+	}
+	var quota quotaError
+	if err := checkDomainQuota(db, user.ID); !errors.As(err, &quota) || quota.quota != 2 {
+		t.Errorf("at the quota: expected a quota error, got %v", err)
+	}
+	if err := checkDomainQuota(db, unlimited.ID); err != nil {
+		t.Errorf("no quota: %v", err)
+	}
+}
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// A failed reminder is retried this often, up to reminderMaxAttempts
+	reminderRetryAfter  = time.Hour
+	reminderMaxAttempts = 3
+
+	// A delivery still pending after this long was interrupted, for example
+	// by a restart, and is claimed again. It is well above the mailer and
+	// webhook timeouts.
+	reminderStaleAfter = 15 * time.Minute
+)
+
+// reminder is an expiry reminder due for a domain
+type reminder struct {
+	Domain     models.Domain
+	Owner      models.User
+	DaysBefore int // the configured offset that made it due
+	DaysLeft   int
+}
+
+// reminderChannel delivers expiry reminders. recipient returns the address
+// the reminder goes to, or "" when the channel cannot deliver it.
+type reminderChannel interface {
+	recipient(config models.RegistrarConfig, r reminder) string
+	send(db *gorm.DB, config models.RegistrarConfig, r reminder, to string) error
+}
+
+// reminderChannels are the channels RegistrarConfig.ReminderChannels may name
+var reminderChannels = map[string]reminderChannel{
+	"email":   emailReminders{},
+	"webhook": webhookReminders{client: &http.Client{Timeout: 10 * time.Second}},
+}
+
+// emailReminders mails the registrant, or the owning user when the domain
+// has no registrant email
+type emailReminders struct{}
+
+func (emailReminders) recipient(config models.RegistrarConfig, r reminder) string {
+	if r.Domain.RegistrantEmail != "" {
+		return r.Domain.RegistrantEmail
+	}
+	return r.Owner.ContactEmail
+}
+
+func (emailReminders) send(db *gorm.DB, config models.RegistrarConfig, r reminder, to string) error {
+	return sendEmail(db, "expiry_reminder", to, emailData{
+		Username:  r.Owner.Username,
+		Email:     to,
+		Link:      frontendLink(db, "/dashboard", ""),
+		Domain:    displayName(r.Domain),
+		ExpiresAt: r.Domain.ExpiresAt.Format("2006-01-02"),
+		DaysLeft:  r.DaysLeft,
+	})
+}
+
+// webhookReminders posts reminders as JSON to the configured URL. With a
+// secret set, the body is signed with HMAC-SHA256 in X-LocalDNS-Signature.
+type webhookReminders struct {
+	client *http.Client
+}
+
+func (webhookReminders) recipient(config models.RegistrarConfig, r reminder) string {
+	return config.ReminderWebhookURL
+}
+
+func (w webhookReminders) send(db *gorm.DB, config models.RegistrarConfig, r reminder, to string) error {
+	body, err := json.Marshal(gin.H{
+		"event":            "domain.expiry_reminder",
+		"domain_id":        r.Domain.ID,
+		"domain":           r.Domain.Name,
+		"unicode_name":     displayName(r.Domain),
+		"expires_at":       r.Domain.ExpiresAt,
+		"days_left":        r.DaysLeft,
+		"days_before":      r.DaysBefore,
+		"auto_renew":       r.Domain.AutoRenew,
+		"registrant_email": r.Domain.RegistrantEmail,
+		"owner":            r.Owner.Username,
+		"owner_email":      r.Owner.ContactEmail,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, to, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if config.ReminderWebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(config.ReminderWebhookSecret))
+		mac.Write(body)
+		req.Header.Set("X-LocalDNS-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// displayName returns the U-label form of a domain's name
+func displayName(domain models.Domain) string {
+	if domain.UnicodeName != "" {
+		return domain.UnicodeName
+	}
+	return domain.Name
+}
+
+// reminderOffsets parses the configured reminder offsets, smallest first
+func reminderOffsets(config models.RegistrarConfig) []int {
+	offsets := []int{}
+	for _, value := range config.ReminderDays {
+		if days, err := strconv.Atoi(value); err == nil && days > 0 {
+			offsets = append(offsets, days)
+		}
+	}
+	sort.Ints(offsets)
+	return offsets
+}
+
+// validateReminderSettings checks the reminder offsets, channels and
+// webhook URL of a registrar configuration
+func validateReminderSettings(config models.RegistrarConfig) error {
+	for _, value := range config.ReminderDays {
+		if days, err := strconv.Atoi(value); err != nil || days <= 0 {
+			return fmt.Errorf("reminder_days must be positive numbers of days")
+		}
+	}
+	for _, name := range config.ReminderChannels {
+		if _, ok := reminderChannels[name]; !ok {
+			return fmt.Errorf("unknown reminder channel %q", name)
+		}
+	}
+	if config.ReminderWebhookURL != "" {
+		u, err := url.Parse(config.ReminderWebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("reminder_webhook_url must be an http or https URL")
+		}
+	}
+	return nil
+}
+
+// reminderKey identifies a delivery in the log
+func reminderKey(domainID uint, expiresAt time.Time, daysBefore int, channel string) string {
+	return fmt.Sprintf("%d/%d/%d/%s", domainID, expiresAt.Unix(), daysBefore, channel)
+}
+
+// applyReminders claims the expiry reminders that have come due and sends
+// them in the background. Only the closest offset is sent, so a domain
+// registered 5 days before expiry gets the 7-day reminder but not the 30-day one.
+func applyReminders(db *gorm.DB, now time.Time) {
+	var config models.RegistrarConfig
+	if err := db.First(&config).Error; err != nil {
+		return
+	}
+	offsets := reminderOffsets(config)
+	if len(offsets) == 0 || len(config.ReminderChannels) == 0 {
+		return
+	}
+
+	var domains []models.Domain
+	if err := db.Where("status = ? AND expires_at > ? AND expires_at <= ?", "active", now, now.AddDate(0, 0, offsets[len(offsets)-1])).
+		Find(&domains).Error; err != nil {
+		log.Printf("Reminders: failed to load expiring domains: %v", err)
+		return
+	}
+	if len(domains) == 0 {
+		return
+	}
+
+	// Deliveries that are finished, in progress, or failed and not yet due
+	// for a retry
+	ids := make([]uint, len(domains))
+	for i, domain := range domains {
+		ids[i] = domain.ID
+	}
+	var logged []models.ReminderDelivery
+	db.Where("domain_id IN ?", ids).Find(&logged)
+	done := map[string]bool{}
+	for _, delivery := range logged {
+		if !reminderClaimable(delivery, now) {
+			done[reminderKey(delivery.DomainID, delivery.ExpiresAt, delivery.DaysBefore, delivery.Channel)] = true
+		}
+	}
+
+	// Sending happens outside the scheduler loop, so a slow mail server or
+	// webhook does not hold up scheduled changes and the lifecycle
+	var claimed []reminderJob
+
+	for _, domain := range domains {
+		r := reminder{Domain: domain, DaysLeft: int(math.Ceil(domain.ExpiresAt.Sub(now).Hours() / 24))}
+		for _, days := range offsets {
+			if !domain.ExpiresAt.After(now.AddDate(0, 0, days)) {
+				r.DaysBefore = days
+				break
+			}
+		}
+		loaded := false
+		for _, name := range config.ReminderChannels {
+			channel, ok := reminderChannels[name]
+			if !ok || done[reminderKey(domain.ID, domain.ExpiresAt, r.DaysBefore, name)] {
+				continue
+			}
+			if !loaded {
+				db.First(&r.Owner, domain.UserID)
+				loaded = true
+			}
+			if delivery, ok := claimReminder(db, name, r, now); ok {
+				claimed = append(claimed, reminderJob{delivery: delivery, channel: channel, reminder: r})
+			}
+		}
+	}
+	if len(claimed) > 0 {
+		go sendReminders(db, config, claimed)
+	}
+}
+
+// reminderClaimable reports whether a logged delivery may be attempted again:
+// it failed an hour ago, or was left pending by an interrupted run
+func reminderClaimable(delivery models.ReminderDelivery, now time.Time) bool {
+	if delivery.Attempts >= reminderMaxAttempts {
+		return false
+	}
+	age := now.Sub(delivery.UpdatedAt)
+	return delivery.Status == "failed" && age >= reminderRetryAfter ||
+		delivery.Status == "pending" && age >= reminderStaleAfter
+}
+
+// reminderJob is a claimed delivery waiting to be sent
+type reminderJob struct {
+	delivery models.ReminderDelivery
+	channel  reminderChannel
+	reminder reminder
+}
+
+// claimReminder marks a reminder pending in the delivery log and counts the
+// attempt. It returns false when another run holds it or it is not due.
+func claimReminder(db *gorm.DB, name string, r reminder, now time.Time) (models.ReminderDelivery, bool) {
+	delivery := models.ReminderDelivery{
+		DomainID:   r.Domain.ID,
+		DomainName: r.Domain.Name,
+		ExpiresAt:  r.Domain.ExpiresAt,
+		DaysBefore: r.DaysBefore,
+		Channel:    name,
+		Status:     "pending",
+		Attempts:   1,
+	}
+	if err := db.Create(&delivery).Error; err == nil {
+		return delivery, true
+	}
+
+	// The row exists already; claim it for a retry if it is still claimable
+	key := db.Where("domain_id = ? AND expires_at = ? AND days_before = ? AND channel = ?",
+		r.Domain.ID, r.Domain.ExpiresAt, r.DaysBefore, name).Session(&gorm.Session{})
+	claim := key.Model(&models.ReminderDelivery{}).
+		Where("attempts < ?", reminderMaxAttempts).
+		Where("(status = ? AND updated_at <= ?) OR (status = ? AND updated_at <= ?)",
+			"failed", now.Add(-reminderRetryAfter), "pending", now.Add(-reminderStaleAfter)).
+		Updates(map[string]interface{}{"status": "pending", "attempts": gorm.Expr("attempts + 1")})
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		return delivery, false
+	}
+	return delivery, key.First(&delivery).Error == nil
+}
+
+// sendReminders sends claimed reminders one after the other and records the
+// outcome of each
+func sendReminders(db *gorm.DB, config models.RegistrarConfig, jobs []reminderJob) {
+	for _, job := range jobs {
+		r := job.reminder
+		status, errText := "sent", ""
+		to := job.channel.recipient(config, r)
+		if to == "" {
+			status, errText = "skipped", "no recipient"
+		} else if err := job.channel.send(db, config, r, to); err != nil {
+			status, errText = "failed", err.Error()
+			log.Printf("Reminders: %s reminder for domain %d (%s) failed: %v", job.delivery.Channel, r.Domain.ID, r.Domain.Name, err)
+		}
+		db.Model(&models.ReminderDelivery{}).Where("id = ?", job.delivery.ID).Updates(map[string]interface{}{
+			"recipient": to,
+			"status":    status,
+			"error":     errText,
+		})
+	}
+}
+
+// ListReminderDeliveries returns the expiry reminder delivery log, newest
+// first. Filter with ?domain_id=, ?channel= and ?status=.
+func ListReminderDeliveries(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "config.write", "whois:admin") {
+			return
+		}
+
+		query := db.Model(&models.ReminderDelivery{})
+		for _, field := range []string{"domain_id", "channel", "status"} {
+			if value := c.Query(field); value != "" {
+				query = query.Where(field+" = ?", value)
+			}
+		}
+		limit := 500
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 10000 {
+			limit = l
+		}
+
+		deliveries := []models.ReminderDelivery{}
+		if err := query.Order("id DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, deliveries)
+	}
+}
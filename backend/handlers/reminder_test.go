@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/localdns/backend/models"
+)
+
+func TestClaimReminderReclaimsStaleDeliveries(t *testing.T) {
+	db := newTestDB(t)
+	expires := time.Now().AddDate(0, 0, 5)
+	r := reminder{Domain: models.Domain{ID: 1, Name: "corp.lan", ExpiresAt: expires}, DaysBefore: 7}
+	now := time.Now()
+
+	delivery, ok := claimReminder(db, "email", r, now)
+	if !ok || delivery.Attempts != 1 {
+		t.Fatalf("first claim: %v, attempts %d", ok, delivery.Attempts)
+	}
+	if _, ok := claimReminder(db, "email", r, now); ok {
+		t.Error("a delivery in progress was claimed twice")
+	}
+
+	// A run that died mid-send leaves the row pending
+	db.Model(&models.ReminderDelivery{}).Where("id = ?", delivery.ID).UpdateColumn("updated_at", now.Add(-reminderStaleAfter-time.Minute))
+	delivery, ok = claimReminder(db, "email", r, now)
+	if !ok || delivery.Attempts != 2 || delivery.Status != "pending" {
+		t.Fatalf("stale claim: %v, %+v", ok, delivery)
+	}
+
+	// Failed deliveries wait for the retry interval and stop after the last attempt
+	db.Model(&models.ReminderDelivery{}).Where("id = ?", delivery.ID).UpdateColumns(map[string]interface{}{
+		"status": "failed", "updated_at": now.Add(-time.Minute),
+	})
+	if _, ok := claimReminder(db, "email", r, now); ok {
+		t.Error("a failed delivery was retried before the retry interval")
+	}
+	db.Model(&models.ReminderDelivery{}).Where("id = ?", delivery.ID).UpdateColumns(map[string]interface{}{
+		"attempts": reminderMaxAttempts, "updated_at": now.Add(-2 * reminderRetryAfter),
+	})
+	if _, ok := claimReminder(db, "email", r, now); ok {
+		t.Error("a delivery was retried beyond the last attempt")
+	}
+}
+
+func TestApplyRemindersSendsInTheBackground(t *testing.T) {
+	db := newTestDB(t)
+	out := useOutbox(t)
+	db.Create(&models.RegistrarConfig{ReminderDays: models.StringList{"7"}, ReminderChannels: models.StringList{"email"}})
+	user := models.User{Username: "alice", Role: "user", ContactEmail: "alice@localdns.lan"}
+	db.Create(&user)
+	db.Create(&models.Domain{Name: "corp.lan", UserID: user.ID, Status: "active", ExpiresAt: time.Now().AddDate(0, 0, 3)})
+
+	applyReminders(db, time.Now())
+	var delivery models.ReminderDelivery
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		db.First(&delivery)
+		if delivery.Status != "pending" {
+			break
+		}
+	}
+	if delivery.Status != "sent" || delivery.Recipient != "alice@localdns.lan" || delivery.Attempts != 1 {
+		t.Fatalf("unexpected delivery: %+v", delivery)
+	}
+	if sent := out.sent(); len(sent) != 1 || sent[0].To != "alice@localdns.lan" {
+		t.Errorf("unexpected mail: %+v", sent)
+	}
+
+	// The reminder is not sent again
+	applyReminders(db, time.Now())
+	time.Sleep(50 * time.Millisecond)
+	if n := len(out.sent()); n != 1 {
+		t.Errorf("expected one mail, %d sent", n)
+	}
+}
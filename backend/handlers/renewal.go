@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+// renewableStatuses are the statuses in which a domain can be renewed;
+// after grace it has to be restored instead
+var renewableStatuses = []string{"active", "expired", "grace"}
+
+// checkRenewal validates extending a domain to expiresAt: the added term
+// must be within its TLD's minimum and maximum, and the registration may not
+// run longer than the registrar's cap from now
+func checkRenewal(db *gorm.DB, domain models.Domain, expiresAt, now time.Time) error {
+	days := int(math.Round(expiresAt.Sub(domain.ExpiresAt).Hours() / 24))
+	if dot := strings.Index(domain.Name, "."); dot >= 0 {
+		var tld models.TLD
+		if err := db.Where("name = ?", domain.Name[dot+1:]).First(&tld).Error; err == nil {
+			if tld.MinTerm > 0 && days < tld.MinTerm {
+				return fmt.Errorf(".%s domains must be renewed for at least %d days", tld.Name, tld.MinTerm)
+			}
+			if tld.MaxTerm > 0 && days > tld.MaxTerm {
+				return fmt.Errorf(".%s domains can be renewed for at most %d days", tld.Name, tld.MaxTerm)
+			}
+		}
+	}
+
+	var config models.RegistrarConfig
+	if err := db.First(&config).Error; err == nil && config.MaxRegistration > 0 &&
+		expiresAt.After(now.AddDate(0, 0, config.MaxRegistration)) {
+		return fmt.Errorf("registrations cannot run longer than %d days from today", config.MaxRegistration)
+	}
+	return nil
+}
+
+// renewDomain moves a domain's expiry to expiresAt, records the renewal in
+// its history and returns it to active if it was in grace
+func renewDomain(tx *gorm.DB, domain *models.Domain, expiresAt time.Time, actor uint, comment string) error {
+	if err := tx.Model(domain).Update("expires_at", expiresAt).Error; err != nil {
+		return err
+	}
+	if domain.Status != "active" && expiresAt.After(time.Now()) {
+		return setDomainStatus(tx, domain, "active", actor, comment)
+	}
+	return recordHistory(tx, domain.ID, "update", actor, comment, nil, nil)
+}
+
+// applyAutoRenewals renews expired domains that have auto-renew enabled by
+// their TLD's default term, before the lifecycle moves them on
+func applyAutoRenewals(db *gorm.DB, now time.Time) {
+	var due []models.Domain
+	if err := db.Where("auto_renew = ? AND status IN ? AND expires_at <= ?", true, renewableStatuses, now).Find(&due).Error; err != nil {
+		log.Printf("Lifecycle: failed to load auto-renewals: %v", err)
+		return
+	}
+	for _, domain := range due {
+		if domain.ExpiresAt.IsZero() || prohibitedBy(domain, "renew") != "" {
+			continue
+		}
+		before := gin.H{"status": domain.Status, "expires_at": domain.ExpiresAt}
+		expiresAt := domain.ExpiresAt.AddDate(0, 0, termDays(db, domain.Name))
+		if err := checkRenewal(db, domain, expiresAt, now); err != nil {
+			log.Printf("Lifecycle: cannot auto-renew domain %d (%s): %v", domain.ID, domain.Name, err)
+			continue
+		}
+		comment := "auto-renewed until " + expiresAt.Format("2006-01-02")
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return renewDomain(tx, &domain, expiresAt, 0, comment)
+		}); err != nil {
+			log.Printf("Lifecycle: auto-renewal of domain %d (%s) failed: %v", domain.ID, domain.Name, err)
+			continue
+		}
+
+		log.Printf("Lifecycle: domain %d (%s) %s", domain.ID, domain.Name, comment)
+		writeAudit(db, models.AuditLog{
+			Action:     "domain.renew",
+			TargetType: "domain",
+			TargetID:   fmt.Sprint(domain.ID),
+			Diff:       jsonDiff(before, gin.H{"status": domain.Status, "expires_at": expiresAt}),
+		})
+	}
+}
+
+// RenewDomain extends a domain's registration from its current expiry by
+// years or days. Domains past grace must be restored instead.
+func RenewDomain(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		domain, ok := loadDomain(c, db, c.Param("id"), "domain.write", "domains:write")
+		if !ok {
+			return
+		}
+
+		var input struct {
+			Years int `json:"years"`
+			Days  int `json:"days"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if (input.Years > 0) == (input.Days > 0) || input.Years < 0 || input.Days < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Give a positive number of either years or days"})
+			return
+		}
+		if !requireUnlocked(c, domain, "renew") {
+			return
+		}
+		if !models.StringList(renewableStatuses).Contains(domain.Status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A domain in %s cannot be renewed; restore it instead", domain.Status)})
+			return
+		}
+
+		before := gin.H{"status": domain.Status, "expires_at": domain.ExpiresAt}
+		now := time.Now()
+		base := domain.ExpiresAt
+		if base.IsZero() {
+			base = now
+		}
+		expiresAt := base.AddDate(input.Years, 0, input.Days)
+		domain.ExpiresAt = base
+		if err := checkRenewal(db, domain, expiresAt, now); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		comment := "renewed until " + expiresAt.Format("2006-01-02")
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return renewDomain(tx, &domain, expiresAt, actorID(c), comment)
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to renew domain: " + err.Error()})
+			return
+		}
+
+		db.First(&domain, domain.ID)
+		audit(c, "domain.renew", "domain", domain.ID, before, gin.H{"status": domain.Status, "expires_at": domain.ExpiresAt})
+		c.JSON(http.StatusOK, domain)
+	}
+}
+
+// SetAutoRenew turns automatic renewal of a domain on or off
+func SetAutoRenew(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		domain, ok := loadDomain(c, db, c.Param("id"), "domain.write", "domains:write")
+		if !ok {
+			return
+		}
+
+		var input struct {
+			AutoRenew *bool `json:"auto_renew" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !requireUnlocked(c, domain, "update") {
+			return
+		}
+
+		before := gin.H{"auto_renew": domain.AutoRenew}
+		if err := db.Model(&domain).Update("auto_renew", *input.AutoRenew).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update domain: " + err.Error()})
+			return
+		}
+		audit(c, "domain.update", "domain", domain.ID, before, gin.H{"auto_renew": domain.AutoRenew})
+		c.JSON(http.StatusOK, domain)
+	}
+}
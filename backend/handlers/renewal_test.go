@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/localdns/backend/models"
+)
+
+func TestCheckRenewal(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&models.RegistrarConfig{MaxRegistration: 1000})
+	db.Create(&models.TLD{Name: "lan", Enabled: true, MinTerm: 365, MaxTerm: 730})
+	now := time.Now()
+	soon := models.Domain{Name: "corp.lan", ExpiresAt: now.AddDate(0, 0, 30)}
+	late := models.Domain{Name: "late.lan", ExpiresAt: now.AddDate(0, 0, 700)}
+	open := models.Domain{Name: "corp.home", ExpiresAt: now.AddDate(0, 0, 30)}
+
+	tests := []struct {
+		domain models.Domain
+		days   int
+		ok     bool
+	}{
+		{soon, 365, true},
+		{soon, 730, true},
+		{soon, 100, false},
+		{soon, 731, false},
+		{late, 365, false},
+		{open, 30, true},
+		{open, 970, true},
+		{open, 971, false},
+	}
+	for _, tt := range tests {
+		err := checkRenewal(db, tt.domain, tt.domain.ExpiresAt.AddDate(0, 0, tt.days), now)
+		if (err == nil) != tt.ok {
+			t.Errorf("renewing %s by %d days: expected ok=%v, got %v", tt.domain.Name, tt.days, tt.ok, err)
+		}
+	}
+}
+
+func TestAutoRenewalRunsBeforeGrace(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&models.RegistrarConfig{})
+	db.Create(&models.TLD{Name: "lan", Enabled: true, DefaultExpiry: 365})
+	user := models.User{Username: "alice", Role: "user"}
+	db.Create(&user)
+	expired := time.Now().Add(-time.Hour)
+	renewed := models.Domain{Name: "renewed.lan", UserID: user.ID, Status: "active", ExpiresAt: expired, AutoRenew: true}
+	manual := models.Domain{Name: "manual.lan", UserID: user.ID, Status: "active", ExpiresAt: expired}
+	locked := models.Domain{Name: "locked.lan", UserID: user.ID, Status: "active", ExpiresAt: expired, AutoRenew: true,
+		EPPStatuses: models.StringList{"clientRenewProhibited"}}
+	for _, domain := range []*models.Domain{&renewed, &manual, &locked} {
+		db.Create(domain)
+	}
+
+	applyLifecycle(db, time.Now())
+
+	tests := []struct {
+		domain  models.Domain
+		status  string
+		expires time.Time
+	}{
+		{renewed, "active", expired.AddDate(0, 0, 365)},
+		{manual, "grace", expired},
+		{locked, "grace", expired},
+	}
+	for _, tt := range tests {
+		var stored models.Domain
+		db.First(&stored, tt.domain.ID)
+		if stored.Status != tt.status || !stored.ExpiresAt.Equal(tt.expires) {
+			t.Errorf("%s: expected %s until %v, got %s until %v", tt.domain.Name, tt.status, tt.expires, stored.Status, stored.ExpiresAt)
+		}
+	}
+}
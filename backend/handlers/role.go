@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+// roleExists reports whether a role with this name has been defined
+func roleExists(db *gorm.DB, name string) bool {
+	var count int64
+	db.Model(&models.Role{}).Where("name = ?", name).Count(&count)
+	return count > 0
+}
+
+// roleWithin reports whether the caller holds every permission of a role
+func roleWithin(c *gin.Context, db *gorm.DB, name string) bool {
+	var role models.Role
+	if err := db.Where("name = ?", name).First(&role).Error; err != nil {
+		return false
+	}
+	held := rolePermissions(c, db)
+	for _, perm := range role.Permissions {
+		if !permits(held, perm) {
+			return false
+		}
+	}
+	return true
+}
+
+// requireAssignableRole checks that the caller may give a user this role:
+// they need role.manage or every permission the role carries, so user.manage
+// alone cannot hand out more than the caller has. Writes a 403 response and
+// returns false when denied.
+func requireAssignableRole(c *gin.Context, db *gorm.DB, name string) bool {
+	if can(c, db, "role.manage") || roleWithin(c, db, name) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Cannot assign role " + name + ": it has permissions you do not hold"})
+	return false
+}
+
+// validatePermissions checks that every permission is in allowed (the whole
+// catalogue when allowed is nil) and returns them de-duplicated and sorted
+func validatePermissions(perms []string, allowed []string) (models.StringList, error) {
+	seen := map[string]bool{}
+	list := models.StringList{}
+	for _, perm := range perms {
+		perm = strings.TrimSpace(perm)
+		if _, known := permissions[perm]; !known {
+			return nil, fmt.Errorf("unknown permission %q", perm)
+		}
+		if allowed != nil && !models.StringList(allowed).Contains(perm) {
+			return nil, fmt.Errorf("permission %q cannot be granted here", perm)
+		}
+		if !seen[perm] {
+			seen[perm] = true
+			list = append(list, perm)
+		}
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// ListPermissions returns the permission catalogue
+func ListPermissions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		names := make([]string, 0, len(permissions))
+		for name := range permissions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		list := make([]gin.H, 0, len(names))
+		for _, name := range names {
+			list = append(list, gin.H{"name": name, "description": permissions[name]})
+		}
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+// ListRoles returns all roles with their permissions
+func ListRoles(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var roles []models.Role
+		if result := db.Order("built_in DESC, name ASC").Find(&roles); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, roles)
+	}
+}
+
+// CreateRole defines a custom role from permissions in the catalogue
+func CreateRole(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "role.manage", "users:admin") {
+			return
+		}
+
+		var input struct {
+			Name        string   `json:"name" binding:"required"`
+			Description string   `json:"description"`
+			Permissions []string `json:"permissions"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		name := strings.ToLower(strings.TrimSpace(input.Name))
+		if name == "" || len(name) > 20 || strings.ContainsAny(name, " ,") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role name must be 1-20 characters without spaces or commas"})
+			return
+		}
+		perms, err := validatePermissions(input.Permissions, nil)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		role := models.Role{Name: name, Description: input.Description, Permissions: perms}
+		if err := db.Create(&role).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role already exists"})
+			return
+		}
+		audit(c, "role.create", "role", role.ID, nil, role)
+		c.JSON(http.StatusCreated, role)
+	}
+}
+
+// UpdateRole changes a role's description and permissions. The built-in
+// admin role always holds every permission and cannot be changed.
+func UpdateRole(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "role.manage", "users:admin") {
+			return
+		}
+
+		var role models.Role
+		if err := db.First(&role, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		if role.Name == "admin" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The admin role cannot be changed"})
+			return
+		}
+
+		var input struct {
+			Description *string   `json:"description"`
+			Permissions *[]string `json:"permissions"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		before := role
+		if input.Description != nil {
+			role.Description = *input.Description
+		}
+		if input.Permissions != nil {
+			perms, err := validatePermissions(*input.Permissions, nil)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			role.Permissions = perms
+		}
+
+		if err := db.Save(&role).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save role: " + err.Error()})
+			return
+		}
+		audit(c, "role.update", "role", role.ID, before, role)
+		c.JSON(http.StatusOK, role)
+	}
+}
+
+// DeleteRole removes a custom role that no user holds
+func DeleteRole(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "role.manage", "users:admin") {
+			return
+		}
+
+		var role models.Role
+		if err := db.First(&role, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		if role.BuiltIn {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Built-in roles cannot be deleted"})
+			return
+		}
+		var holders int64
+		db.Model(&models.User{}).Where("role = ?", role.Name).Count(&holders)
+		if holders > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role is still assigned to users"})
+			return
+		}
+
+		if err := db.Delete(&role).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role: " + err.Error()})
+			return
+		}
+		audit(c, "role.delete", "role", role.ID, role, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Role deleted"})
+	}
+}
+
+// ListGrants returns per-domain grants, optionally filtered by ?user_id= or
+// ?domain_id=
+func ListGrants(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "role.manage", "users:admin") {
+			return
+		}
+
+		query := db.Order("name ASC, user_id ASC")
+		if userID := c.Query("user_id"); userID != "" {
+			query = query.Where("user_id = ?", userID)
+		}
+		if domainID := c.Query("domain_id"); domainID != "" {
+			query = query.Where("domain_id = ?", domainID)
+		}
+		grants := []models.Grant{}
+		query.Find(&grants)
+		c.JSON(http.StatusOK, grants)
+	}
+}
+
+// CreateGrant gives a user permissions on a domain, or on the records at and
+// below a subdomain of it. The domain is the longest registered domain that
+// contains name.
+func CreateGrant(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "role.manage", "users:admin") {
+			return
+		}
+
+		var input struct {
+			Username    string   `json:"username" binding:"required"`
+			Name        string   `json:"name" binding:"required"`
+			Permissions []string `json:"permissions" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		name, err := normalizeRecordName(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(input.Name)), "."))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var user models.User
+		if err := db.Where("username = ?", input.Username).First(&user).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		var domain models.Domain
+		candidates := []string{}
+		for labels := strings.Split(name, "."); len(labels) > 0; labels = labels[1:] {
+			candidates = append(candidates, strings.Join(labels, "."))
+		}
+		if err := db.Where("name IN ?", candidates).Order("LENGTH(name) DESC").First(&domain).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No registered domain contains " + name})
+			return
+		}
+
+		// Below the domain itself only record permissions make sense
+		allowed := domainPermissions
+		if name != domain.Name {
+			allowed = subtreePermissions
+		}
+		perms, err := validatePermissions(input.Permissions, allowed)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(perms) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one permission is required"})
+			return
+		}
+
+		grant := models.Grant{
+			UserID:      user.ID,
+			DomainID:    domain.ID,
+			Name:        name,
+			Permissions: perms,
+			CreatedBy:   actorID(c),
+		}
+		if err := db.Create(&grant).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create grant: " + err.Error()})
+			return
+		}
+		audit(c, "grant.create", "grant", grant.ID, nil, grant)
+		c.JSON(http.StatusCreated, grant)
+	}
+}
+
+// DeleteGrant revokes a grant
+func DeleteGrant(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "role.manage", "users:admin") {
+			return
+		}
+
+		var grant models.Grant
+		if err := db.First(&grant, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Grant not found"})
+			return
+		}
+		if err := db.Delete(&grant).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete grant: " + err.Error()})
+			return
+		}
+		audit(c, "grant.delete", "grant", grant.ID, grant, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Grant revoked"})
+	}
+}
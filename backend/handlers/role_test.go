@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/localdns/backend/models"
+)
+
+func TestRoleAssignmentNeedsThePermissions(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&models.Role{Name: "support", Permissions: models.StringList{"user.manage", "domain.create"}})
+	support := models.User{Username: "support", Role: "support"}
+	alice := models.User{Username: "alice", Role: "user"}
+	admin := models.User{Username: "admin", Role: "admin"}
+	db.Create(&support)
+	db.Create(&alice)
+	db.Create(&admin)
+
+	r := signedIn(support)
+	r.POST("/api/users", CreateUser(db))
+	r.PUT("/api/users/:id", UpdateUser(db))
+	update := func(user models.User, role string) int {
+		return serve(r, jsonRequest(http.MethodPut, "/api/users/"+fmt.Sprint(user.ID), `{"role":"`+role+`"}`)).Code
+	}
+
+	if code := update(alice, "admin"); code != http.StatusForbidden {
+		t.Errorf("promote to admin: expected 403, got %d", code)
+	}
+	if code := update(admin, "user"); code != http.StatusForbidden {
+		t.Errorf("demote an admin: expected 403, got %d", code)
+	}
+	if code := update(support, "user"); code != http.StatusForbidden {
+		t.Errorf("own role: expected 403, got %d", code)
+	}
+	if code := update(alice, "support"); code != http.StatusOK {
+		t.Errorf("assign a role within reach: expected 200, got %d", code)
+	}
+
+	w := serve(r, jsonRequest(http.MethodPost, "/api/users", `{"username":"mallory","password":"password123","role":"admin"}`))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("create an admin: expected 403, got %d", w.Code)
+	}
+	var count int64
+	db.Model(&models.User{}).Where("username = ?", "mallory").Count(&count)
+	if count != 0 {
+		t.Error("the admin account was created")
+	}
+}
@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+// ListScheduledChanges returns the scheduled change sets of a domain.
+// Only pending schedules are returned unless ?status=all or another status is given.
+func ListScheduledChanges(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		domainID := c.Param("id")
+
+		domain, ok := loadZone(c, db, domainID, "record.read", "records:read")
+		if !ok {
+			return
+		}
+
+		query := db.Where("domain_id = ?", domain.ID)
+		// Callers with access to part of the domain only see their own
+		if !permitted(c, db, domain, domain.Name, "record.read") {
+			query = query.Where("created_by = ?", actorID(c))
+		}
+		if status := c.DefaultQuery("status", "pending"); status != "all" {
+			query = query.Where("status = ?", status)
+		}
+
+		var schedules []models.ScheduledChange
+		if result := query.Order("run_at ASC").Find(&schedules); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, schedules)
+	}
+}
+
+// normalizeChange converts internationalised names in a change to A-labels.
+// recordType is the type of the record an update leaves the type of unchanged.
+func normalizeChange(change *models.RecordChange, recordType string) error {
+	if change.Type != "" {
+		recordType = change.Type
+	}
+	var err error
+	if change.Name, err = normalizeRecordName(change.Name); err != nil {
+		return err
+	}
+	change.Content, err = normalizeRecordContent(recordType, change.Content)
+	return err
+}
+
+// CreateScheduledChange stages a set of record changes to be applied at run_at
+func CreateScheduledChange(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		domainID := c.Param("id")
+
+		domain, ok := loadZone(c, db, domainID, "record.write", "records:write")
+		if !ok {
+			return
+		}
+		if !requireUnlocked(c, domain, "update") {
+			return
+		}
+
+		var input struct {
+			RunAt       time.Time             `json:"run_at" binding:"required"`
+			Description string                `json:"description"`
+			Changes     []models.RecordChange `json:"changes" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !input.RunAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "run_at must be in the future"})
+			return
+		}
+		if len(input.Changes) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one change is required"})
+			return
+		}
+		access := recordAccess(c, db, domain, "record.write")
+		for i := range input.Changes {
+			change := &input.Changes[i]
+			var recordType string
+			var names []string
+			switch change.Action {
+			case "create":
+				if change.Name == "" || change.Type == "" || change.Content == "" {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Change %d: create requires name, type and content", i)})
+					return
+				}
+			case "update", "delete":
+				var record models.Record
+				if err := db.Where("id = ? AND domain_id = ?", change.RecordID, domain.ID).First(&record).Error; err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Change %d: record %d not found in this domain", i, change.RecordID)})
+					return
+				}
+				recordType = record.Type
+				names = append(names, record.Name)
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Change %d: action must be create, update or delete", i)})
+				return
+			}
+			// Stored as they will be served, like records added directly
+			if err := normalizeChange(change, recordType); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Change %d: %v", i, err)})
+				return
+			}
+			if change.Name != "" && change.Action != "delete" {
+				names = append(names, change.Name)
+			}
+			for _, name := range names {
+				if !authorizeRecord(c, access, name) {
+					return
+				}
+			}
+		}
+
+		changes, _ := json.Marshal(input.Changes)
+		schedule := models.ScheduledChange{
+			DomainID:    domain.ID,
+			CreatedBy:   actorID(c),
+			Description: input.Description,
+			RunAt:       input.RunAt,
+			Status:      "pending",
+			Changes:     models.JSONText(changes),
+		}
+		if result := db.Create(&schedule); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, schedule)
+	}
+}
+
+// CancelScheduledChange cancels a pending scheduled change
+func CancelScheduledChange(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var schedule models.ScheduledChange
+		if result := db.First(&schedule, c.Param("scheduleId")); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled change not found"})
+			return
+		}
+
+		domain, ok := loadZone(c, db, schedule.DomainID, "record.write", "records:write")
+		if !ok {
+			return
+		}
+		if schedule.CreatedBy != actorID(c) && !permitted(c, db, domain, domain.Name, "record.write") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+
+		result := db.Model(&models.ScheduledChange{}).
+			Where("id = ? AND status = ?", schedule.ID, "pending").
+			Update("status", "cancelled")
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only pending changes can be cancelled"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Scheduled change cancelled"})
+	}
+}
+
+// applyRecordWindow validates a record's activate_at/expire_at window and keeps
+// records with a future activation time disabled until the scheduler enables them
+func applyRecordWindow(record *models.Record) error {
+	now := time.Now()
+	if record.ExpireAt != nil && !record.ExpireAt.After(now) {
+		return fmt.Errorf("expire_at must be in the future")
+	}
+	if record.ActivateAt != nil && record.ExpireAt != nil && !record.ExpireAt.After(*record.ActivateAt) {
+		return fmt.Errorf("expire_at must be after activate_at")
+	}
+	if record.ActivateAt != nil {
+		if record.ActivateAt.After(now) {
+			record.Disabled = true
+		} else {
+			record.ActivateAt = nil
+		}
+	}
+	return nil
+}
+
+// RunScheduler applies due scheduled changes, record validity windows and
+// the domain lifecycle. It blocks, so it should be started in its own
+// goroutine.
+func RunScheduler(db *gorm.DB, interval time.Duration) {
+	// A schedule still running was interrupted before its transaction
+	// committed, so nothing of it was applied and it can run again
+	if result := db.Model(&models.ScheduledChange{}).Where("status = ?", "running").Update("status", "pending"); result.RowsAffected > 0 {
+		log.Printf("Scheduler: requeued %d interrupted scheduled change(s)", result.RowsAffected)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		applyDueChanges(db, now)
+		applyRecordWindows(db, now)
+		applyLifecycle(db, now)
+		applyReminders(db, now)
+		applyTransfers(db, now)
+		<-ticker.C
+	}
+}
+
+func applyDueChanges(db *gorm.DB, now time.Time) {
+	var due []models.ScheduledChange
+	if err := db.Where("status = ? AND run_at <= ?", "pending", now).Order("run_at ASC").Find(&due).Error; err != nil {
+		log.Printf("Scheduler: failed to load scheduled changes: %v", err)
+		return
+	}
+
+	for _, schedule := range due {
+		// Claim the schedule so it is never applied twice
+		claim := db.Model(&models.ScheduledChange{}).
+			Where("id = ? AND status = ?", schedule.ID, "pending").
+			Update("status", "running")
+		if claim.RowsAffected == 0 {
+			continue
+		}
+
+		// A successful schedule is marked applied in its own transaction
+		if err := applyScheduledChange(db, schedule); err != nil {
+			log.Printf("Scheduler: change %d for domain %d failed: %v", schedule.ID, schedule.DomainID, err)
+			appliedAt := time.Now()
+			db.Model(&models.ScheduledChange{}).Where("id = ?", schedule.ID).Updates(map[string]interface{}{
+				"status":     "failed",
+				"result":     err.Error(),
+				"applied_at": &appliedAt,
+			})
+		}
+	}
+}
+
+// applyScheduledChange applies all changes of a schedule in one transaction.
+// The creator must still hold record.write on every name it touches.
+func applyScheduledChange(db *gorm.DB, schedule models.ScheduledChange) error {
+	var changes []models.RecordChange
+	if err := json.Unmarshal([]byte(schedule.Changes), &changes); err != nil {
+		return fmt.Errorf("invalid change set: %w", err)
+	}
+
+	var domain models.Domain
+	if err := db.First(&domain, schedule.DomainID).Error; err != nil {
+		return fmt.Errorf("domain no longer exists")
+	}
+	if status := prohibitedBy(domain, "update"); status != "" {
+		return fmt.Errorf("domain status %s prohibits updates", status)
+	}
+	var creator models.User
+	if err := db.First(&creator, schedule.CreatedBy).Error; err != nil {
+		return fmt.Errorf("creator no longer exists")
+	}
+	if message := accountStatusError(creator); message != "" {
+		return fmt.Errorf("creator cannot make changes: %s", message)
+	}
+	access := recordAccess(actingAs(creator), db, domain, "record.write")
+
+	comment := fmt.Sprintf("scheduled change #%d", schedule.ID)
+	return db.Transaction(func(tx *gorm.DB) error {
+		for i, change := range changes {
+			switch change.Action {
+			case "create":
+				// Schedules stored before names were normalised on creation
+				if err := normalizeChange(&change, ""); err != nil {
+					return fmt.Errorf("change %d: %w", i, err)
+				}
+				if !access(change.Name) {
+					return fmt.Errorf("change %d: creator no longer has access to %s", i, change.Name)
+				}
+				record := models.Record{
+					DomainID: schedule.DomainID,
+					Name:     change.Name,
+					Type:     change.Type,
+					Content:  change.Content,
+					TTL:      change.TTL,
+				}
+				if change.Prio != nil {
+					record.Prio = *change.Prio
+				}
+				if record.TTL == 0 {
+					record.TTL = 360
+				}
+				holdForDomain(&record, domain)
+				if err := tx.Create(&record).Error; err != nil {
+					return fmt.Errorf("change %d: %w", i, err)
+				}
+				if err := recordHistory(tx, schedule.DomainID, "create", schedule.CreatedBy, comment, nil, &record); err != nil {
+					return err
+				}
+			case "update":
+				var record models.Record
+				if err := tx.Where("id = ? AND domain_id = ?", change.RecordID, schedule.DomainID).First(&record).Error; err != nil {
+					return fmt.Errorf("change %d: record %d not found", i, change.RecordID)
+				}
+				if err := normalizeChange(&change, record.Type); err != nil {
+					return fmt.Errorf("change %d: %w", i, err)
+				}
+				if !access(record.Name) || change.Name != "" && !access(change.Name) {
+					return fmt.Errorf("change %d: creator no longer has access to record %d", i, change.RecordID)
+				}
+				before := record
+				if change.Name != "" {
+					record.Name = change.Name
+				}
+				if change.Type != "" {
+					record.Type = change.Type
+				}
+				if change.Content != "" {
+					record.Content = change.Content
+				}
+				if change.TTL > 0 {
+					record.TTL = change.TTL
+				}
+				if change.Prio != nil {
+					record.Prio = *change.Prio
+				}
+				if err := tx.Save(&record).Error; err != nil {
+					return fmt.Errorf("change %d: %w", i, err)
+				}
+				if err := recordHistory(tx, schedule.DomainID, "update", schedule.CreatedBy, comment, &before, &record); err != nil {
+					return err
+				}
+			case "delete":
+				var record models.Record
+				if err := tx.Where("id = ? AND domain_id = ?", change.RecordID, schedule.DomainID).First(&record).Error; err != nil {
+					return fmt.Errorf("change %d: record %d not found", i, change.RecordID)
+				}
+				if !access(record.Name) {
+					return fmt.Errorf("change %d: creator no longer has access to record %d", i, change.RecordID)
+				}
+				if err := tx.Delete(&record).Error; err != nil {
+					return fmt.Errorf("change %d: %w", i, err)
+				}
+				if err := recordHistory(tx, schedule.DomainID, "delete", schedule.CreatedBy, comment, &record, nil); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("change %d: unknown action %q", i, change.Action)
+			}
+		}
+		appliedAt := time.Now()
+		return tx.Model(&models.ScheduledChange{}).Where("id = ?", schedule.ID).Updates(map[string]interface{}{
+			"status":     "applied",
+			"result":     fmt.Sprintf("%d change(s) applied", len(changes)),
+			"applied_at": &appliedAt,
+		}).Error
+	})
+}
+
+// applyRecordWindows enables records whose activate_at has passed and removes
+// records whose expire_at has passed. Records held with their domain stay
+// disabled; holdRecords releases them when the domain is served again.
+func applyRecordWindows(db *gorm.DB, now time.Time) {
+	var activating []models.Record
+	db.Where("activate_at IS NOT NULL AND activate_at <= ? AND held = ?", now, false).Find(&activating)
+	for _, record := range activating {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			before := record
+			record.Disabled = false
+			record.ActivateAt = nil
+			if err := tx.Save(&record).Error; err != nil {
+				return err
+			}
+			return recordHistory(tx, record.DomainID, "update", 0, "record activated", &before, &record)
+		})
+		if err != nil {
+			log.Printf("Scheduler: failed to activate record %d: %v", record.ID, err)
+		}
+	}
+
+	var expiring []models.Record
+	db.Where("expire_at IS NOT NULL AND expire_at <= ?", now).Find(&expiring)
+	for _, record := range expiring {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(&record).Error; err != nil {
+				return err
+			}
+			return recordHistory(tx, record.DomainID, "delete", 0, "record expired", &record, nil)
+		})
+		if err != nil {
+			log.Printf("Scheduler: failed to expire record %d: %v", record.ID, err)
+		}
+	}
+}
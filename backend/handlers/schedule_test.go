@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/localdns/backend/models"
+)
+
+func TestRenderTemplateNormalisesNames(t *testing.T) {
+	template := models.RecordTemplate{Records: []models.TemplateRecord{
+		{Name: "{{host}}", Type: "cname", Content: "{{target}}"},
+		{Name: "@", Type: "TXT", Content: "bücher"},
+	}}
+	records, err := renderTemplate(template, "corp.lan", map[string]string{"host": "bücher", "target": "münchen.corp.lan."})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if records[0].Name != "xn--bcher-kva" || records[0].Content != "xn--mnchen-3ya.corp.lan." {
+		t.Errorf("CNAME not normalised: %s -> %s", records[0].Name, records[0].Content)
+	}
+	if records[1].Content != "bücher" {
+		t.Errorf("TXT content must be left alone, got %q", records[1].Content)
+	}
+}
+
+func TestScheduledChangeNormalisesNames(t *testing.T) {
+	db := newTestDB(t)
+	user := models.User{Username: "alice", Role: "user"}
+	db.Create(&user)
+	domain := models.Domain{Name: "corp.lan", UserID: user.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0)}
+	db.Create(&domain)
+	record := models.Record{DomainID: domain.ID, Name: "www", Type: "CNAME", Content: "corp.lan.", TTL: 360}
+	db.Create(&record)
+
+	// Stored as older versions did, without normalising
+	changes, _ := json.Marshal([]models.RecordChange{
+		{Action: "create", Name: "bücher", Type: "A", Content: "192.0.2.1"},
+		{Action: "update", RecordID: record.ID, Content: "münchen.corp.lan."},
+	})
+	schedule := models.ScheduledChange{DomainID: domain.ID, CreatedBy: user.ID, RunAt: time.Now(), Status: "running", Changes: models.JSONText(changes)}
+	db.Create(&schedule)
+
+	if err := applyScheduledChange(db, schedule); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	var created models.Record
+	if err := db.Where("domain_id = ? AND type = ?", domain.ID, "A").First(&created).Error; err != nil || created.Name != "xn--bcher-kva" {
+		t.Errorf("created record: %q (%v)", created.Name, err)
+	}
+	db.First(&record, record.ID)
+	if record.Content != "xn--mnchen-3ya.corp.lan." {
+		t.Errorf("updated CNAME content: %q", record.Content)
+	}
+}
+
+func TestRecordWindowsLeaveHeldRecords(t *testing.T) {
+	db := newTestDB(t)
+	user := models.User{Username: "alice", Role: "user"}
+	db.Create(&user)
+	domain := models.Domain{Name: "corp.lan", UserID: user.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0)}
+	db.Create(&domain)
+	soon := time.Now().Add(time.Hour)
+	record := models.Record{DomainID: domain.ID, Name: "www", Type: "A", Content: "192.0.2.1", TTL: 360, Disabled: true, ActivateAt: &soon}
+	db.Create(&record)
+
+	// The domain stops being served before the record's activation time
+	domain.Status = "redemption"
+	db.Model(&domain).Update("status", domain.Status)
+	if err := holdRecords(db, domain); err != nil {
+		t.Fatalf("hold: %v", err)
+	}
+	applyRecordWindows(db, soon.Add(time.Minute))
+	db.First(&record, record.ID)
+	if !record.Disabled || !record.Held {
+		t.Fatalf("record was enabled while its domain is not served: disabled=%v held=%v", record.Disabled, record.Held)
+	}
+
+	// Once restored, the activation time applies again
+	domain.Status = "active"
+	db.Model(&domain).Update("status", domain.Status)
+	if err := holdRecords(db, domain); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	db.First(&record, record.ID)
+	if !record.Disabled || record.Held {
+		t.Fatalf("record waiting for activation after release: disabled=%v held=%v", record.Disabled, record.Held)
+	}
+	applyRecordWindows(db, soon.Add(time.Minute))
+	record = models.Record{}
+	db.Where("name = ?", "www").First(&record)
+	if record.Disabled || record.ActivateAt != nil {
+		t.Errorf("record was not activated: disabled=%v activate_at=%v", record.Disabled, record.ActivateAt)
+	}
+}
+
+func TestScheduledAndTemplateRecordsAreHeldOnOfflineDomains(t *testing.T) {
+	db := newTestDB(t)
+	user := models.User{Username: "alice", Role: "user"}
+	db.Create(&user)
+	domain := models.Domain{Name: "corp.lan", UserID: user.ID, Status: "redemption", ExpiresAt: time.Now().AddDate(0, 0, -40)}
+	db.Create(&domain)
+
+	changes, _ := json.Marshal([]models.RecordChange{{Action: "create", Name: "www", Type: "A", Content: "192.0.2.1"}})
+	schedule := models.ScheduledChange{DomainID: domain.ID, CreatedBy: user.ID, RunAt: time.Now(), Status: "running", Changes: models.JSONText(changes)}
+	db.Create(&schedule)
+	if err := applyScheduledChange(db, schedule); err != nil {
+		t.Fatalf("apply schedule: %v", err)
+	}
+
+	template := models.RecordTemplate{Records: []models.TemplateRecord{{Name: "mail", Type: "A", Content: "192.0.2.2"}}}
+	rendered, err := renderTemplate(template, domain.Name, nil)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	for i := range rendered {
+		rendered[i].DomainID = domain.ID
+	}
+	if err := applyTemplatePlan(db, domain, user.ID, "template", planTemplate(db, domain.ID, rendered)); err != nil {
+		t.Fatalf("apply template: %v", err)
+	}
+
+	var records []models.Record
+	db.Where("domain_id = ?", domain.ID).Find(&records)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	for _, record := range records {
+		if !record.Disabled || !record.Held {
+			t.Errorf("%s is served on a domain in redemption: disabled=%v held=%v", record.Name, record.Disabled, record.Held)
+		}
+	}
+}
+
+func TestScheduledUpdateKeepsPrioWhenUnset(t *testing.T) {
+	db := newTestDB(t)
+	user := models.User{Username: "alice", Role: "user"}
+	db.Create(&user)
+	domain := models.Domain{Name: "corp.lan", UserID: user.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0)}
+	db.Create(&domain)
+	record := models.Record{DomainID: domain.ID, Name: "@", Type: "MX", Content: "mail.corp.lan.", TTL: 360, Prio: 10}
+	db.Create(&record)
+
+	changes, _ := json.Marshal([]models.RecordChange{{Action: "update", RecordID: record.ID, TTL: 600}})
+	schedule := models.ScheduledChange{DomainID: domain.ID, CreatedBy: user.ID, RunAt: time.Now(), Status: "running", Changes: models.JSONText(changes)}
+	db.Create(&schedule)
+	if err := applyScheduledChange(db, schedule); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+
+	var stored models.Record
+	db.First(&stored, record.ID)
+	if stored.TTL != 600 || stored.Prio != 10 {
+		t.Errorf("expected TTL 600 and prio 10, got %d and %d", stored.TTL, stored.Prio)
+	}
+}
+
+func TestCreateScheduledChangeChecksEveryName(t *testing.T) {
+	db := newTestDB(t)
+	alice := models.User{Username: "alice", Role: "user"}
+	carol := models.User{Username: "carol", Role: "user"}
+	db.Create(&alice)
+	db.Create(&carol)
+	domain := models.Domain{Name: "corp.lan", UserID: alice.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0)}
+	db.Create(&domain)
+	db.Create(&models.Grant{UserID: carol.ID, DomainID: domain.ID, Name: "dev.corp.lan", Permissions: models.StringList{"record.write"}})
+	inside := models.Record{DomainID: domain.ID, Name: "ci.dev", Type: "A", Content: "192.0.2.1", TTL: 360}
+	outside := models.Record{DomainID: domain.ID, Name: "www", Type: "A", Content: "192.0.2.2", TTL: 360}
+	db.Create(&inside)
+	db.Create(&outside)
+
+	r := signedIn(carol)
+	r.POST("/api/domains/:id/schedules", CreateScheduledChange(db))
+	runAt := time.Now().Add(time.Hour).Format(time.RFC3339)
+	tests := []struct {
+		change string
+		want   int
+	}{
+		{`{"action":"create","name":"www.dev","type":"A","content":"192.0.2.3"}`, http.StatusCreated},
+		{`{"action":"create","name":"mail","type":"A","content":"192.0.2.3"}`, http.StatusForbidden},
+		{fmt.Sprintf(`{"action":"update","record_id":%d,"name":"ci"}`, inside.ID), http.StatusForbidden},
+		{fmt.Sprintf(`{"action":"update","record_id":%d,"content":"192.0.2.4"}`, outside.ID), http.StatusForbidden},
+		{fmt.Sprintf(`{"action":"delete","record_id":%d}`, outside.ID), http.StatusForbidden},
+		{fmt.Sprintf(`{"action":"delete","record_id":%d}`, inside.ID), http.StatusCreated},
+	}
+	for _, tt := range tests {
+		body := `{"run_at":"` + runAt + `","changes":[` + tt.change + `]}`
+		w := serve(r, jsonRequest(http.MethodPost, fmt.Sprintf("/api/domains/%d/schedules", domain.ID), body))
+		if w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d %s", tt.change, tt.want, w.Code, w.Body)
+		}
+	}
+}
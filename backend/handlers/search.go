@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+// normalizeTags trims tag keys/values and rejects empty or duplicate keys
+func normalizeTags(tags []models.RecordTag) ([]models.RecordTag, error) {
+	seen := map[string]bool{}
+	normalized := make([]models.RecordTag, 0, len(tags))
+	for _, tag := range tags {
+		key := strings.TrimSpace(tag.Key)
+		if key == "" {
+			return nil, fmt.Errorf("tag key must not be empty")
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate tag key %q", key)
+		}
+		seen[key] = true
+		normalized = append(normalized, models.RecordTag{Key: key, Value: strings.TrimSpace(tag.Value)})
+	}
+	return normalized, nil
+}
+
+// likeEscaper escapes the LIKE wildcards so user input matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// containsPattern returns a LIKE pattern matching values that contain s
+func containsPattern(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
+
+// Record content is only cast to inet when it matches these, so a malformed
+// A or AAAA record cannot make the search fail
+const (
+	ipv4Pattern = `^((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])\.){3}(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])$`
+	ipv6Pattern = `^(([0-9a-f]{1,4}:){7}[0-9a-f]{1,4}|([0-9a-f]{1,4}:){1,7}:|([0-9a-f]{1,4}:){1,6}:[0-9a-f]{1,4}|` +
+		`([0-9a-f]{1,4}:){1,5}(:[0-9a-f]{1,4}){1,2}|([0-9a-f]{1,4}:){1,4}(:[0-9a-f]{1,4}){1,3}|` +
+		`([0-9a-f]{1,4}:){1,3}(:[0-9a-f]{1,4}){1,4}|([0-9a-f]{1,4}:){1,2}(:[0-9a-f]{1,4}){1,5}|` +
+		`[0-9a-f]{1,4}:(:[0-9a-f]{1,4}){1,6}|:((:[0-9a-f]{1,4}){1,7}|:))$`
+)
+
+// SearchRecords searches records across all domains visible to the caller.
+// Filters (all optional, combined with AND):
+//
+//	q        substring of name, content or comment
+//	name     substring of record name
+//	content  substring of record content
+//	type     exact record type (A, CNAME, ...)
+//	tag      "key" or "key=value", may be repeated
+//	ip       IP address or CIDR matched against A/AAAA record content
+func SearchRecords(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireScope(c, "records:read", "") {
+			return
+		}
+
+		visible := db.Model(&models.Domain{}).Scopes(visibleDomains(c)).Select("domains.id")
+		query := db.Preload("Tags").Where("domain_id IN (?)", visible)
+
+		if q := strings.TrimSpace(c.Query("q")); q != "" {
+			like := containsPattern(q)
+			query = query.Where("name ILIKE ? OR content ILIKE ? OR comment ILIKE ?", like, like, like)
+		}
+		if name := strings.TrimSpace(c.Query("name")); name != "" {
+			query = query.Where("name ILIKE ?", containsPattern(name))
+		}
+		if content := strings.TrimSpace(c.Query("content")); content != "" {
+			query = query.Where("content ILIKE ?", containsPattern(content))
+		}
+		if recordType := strings.TrimSpace(c.Query("type")); recordType != "" {
+			query = query.Where("UPPER(type) = ?", strings.ToUpper(recordType))
+		}
+		for _, tag := range c.QueryArray("tag") {
+			key, value, hasValue := strings.Cut(tag, "=")
+			tagged := db.Model(&models.RecordTag{}).Select("record_id").Where("key = ?", strings.TrimSpace(key))
+			if hasValue {
+				tagged = tagged.Where("value = ?", strings.TrimSpace(value))
+			}
+			query = query.Where("id IN (?)", tagged)
+		}
+
+		if ip := strings.TrimSpace(c.Query("ip")); ip != "" {
+			if !strings.Contains(ip, "/") {
+				if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() != nil {
+					ip += "/32"
+				} else {
+					ip += "/128"
+				}
+			}
+			_, ipNet, err := net.ParseCIDR(ip)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ip must be an IP address or CIDR"})
+				return
+			}
+			query = query.Where(`CASE
+				WHEN UPPER(type) = 'A' AND btrim(content) ~ ? THEN btrim(content)::inet <<= ?::inet
+				WHEN UPPER(type) = 'AAAA' AND btrim(content) ~* ? THEN btrim(content)::inet <<= ?::inet
+				ELSE false END`, ipv4Pattern, ipNet.String(), ipv6Pattern, ipNet.String())
+		}
+
+		var records []models.Record
+		if result := query.Order("domain_id ASC, name ASC").Limit(1000).Find(&records); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+			return
+		}
+
+		// Attach the domain name so results from different zones can be told apart
+		var domains []models.Domain
+		db.Scopes(visibleDomains(c)).Find(&domains)
+		byID := make(map[uint]models.Domain, len(domains))
+		for _, d := range domains {
+			byID[d.ID] = d
+		}
+
+		type result struct {
+			models.Record
+			DomainName string `json:"domain_name"`
+		}
+		// Callers with a subdomain grant only see the records below it
+		access := map[uint]func(string) bool{}
+		results := make([]result, 0, len(records))
+		for _, record := range records {
+			domain := byID[record.DomainID]
+			if access[domain.ID] == nil {
+				access[domain.ID] = recordAccess(c, db, domain, "record.read")
+			}
+			if access[domain.ID](record.Name) {
+				results = append(results, result{Record: record, DomainName: domain.Name})
+			}
+		}
+		c.JSON(http.StatusOK, results)
+	}
+}
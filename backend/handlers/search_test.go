@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/localdns/backend/models"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := normalizeTags([]models.RecordTag{{Key: " env ", Value: " prod "}, {Key: "owner"}})
+	if err != nil || len(tags) != 2 || tags[0].Key != "env" || tags[0].Value != "prod" {
+		t.Errorf("expected trimmed tags, got %+v (%v)", tags, err)
+	}
+	for _, bad := range [][]models.RecordTag{
+		{{Key: " "}},
+		{{Key: "env", Value: "a"}, {Key: " env", Value: "b"}},
+	} {
+		if _, err := normalizeTags(bad); err == nil {
+			t.Errorf("expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestContainsPatternMatchesLiterally(t *testing.T) {
+	for input, want := range map[string]string{
+		"www":      "%www%",
+		"100%":     `%100\%%`,
+		"my_host":  `%my\_host%`,
+		`back\sl`:  `%back\\sl%`,
+		`50%_off\`: `%50\%\_off\\%`,
+	} {
+		if got := containsPattern(input); got != want {
+			t.Errorf("containsPattern(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestIPPatternsOnlyAdmitAddresses(t *testing.T) {
+	ipv4 := regexp.MustCompile(ipv4Pattern)
+	ipv6 := regexp.MustCompile("(?i)" + ipv6Pattern)
+	for _, content := range []string{
+		"192.0.2.1", "0.0.0.0", "255.255.255.255", "256.0.0.1", "192.0.2", "192.0.2.1.5", "01.2.3.4", "host.corp.lan",
+		"2001:db8::1", "::1", "::", "fe80::", "2001:DB8:0:0:0:0:0:1", "2001:db8::g", "2001:db8:::1", "1:2:3:4:5:6:7:8:9",
+	} {
+		parsed := net.ParseIP(content)
+		isV4 := parsed != nil && strings.Contains(content, ".")
+		isV6 := parsed != nil && strings.Contains(content, ":")
+		// Every content the patterns let through must be castable to inet
+		if ipv4.MatchString(content) && !isV4 {
+			t.Errorf("IPv4 pattern admits %q", content)
+		}
+		if ipv6.MatchString(content) && !isV6 {
+			t.Errorf("IPv6 pattern admits %q", content)
+		}
+		if isV4 && !ipv4.MatchString(content) {
+			t.Errorf("IPv4 pattern misses %q", content)
+		}
+		if isV6 && !ipv6.MatchString(content) {
+			t.Errorf("IPv6 pattern misses %q", content)
+		}
+	}
+}
+
+func TestSearchRecordsOnlyReturnsVisibleRecords(t *testing.T) {
+	db := newTestDB(t)
+	alice := models.User{Username: "alice", Role: "user"}
+	bob := models.User{Username: "bob", Role: "user"}
+	carol := models.User{Username: "carol", Role: "user"}
+	for _, u := range []*models.User{&alice, &bob, &carol} {
+		db.Create(u)
+	}
+	corp := models.Domain{Name: "corp.lan", UserID: alice.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0)}
+	other := models.Domain{Name: "other.lan", UserID: bob.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0)}
+	db.Create(&corp)
+	db.Create(&other)
+	db.Create(&models.Grant{UserID: carol.ID, DomainID: corp.ID, Name: "dev.corp.lan", Permissions: models.StringList{"record.read"}})
+	for _, record := range []models.Record{
+		{DomainID: corp.ID, Name: "www", Type: "A", Content: "192.0.2.1", Tags: []models.RecordTag{{Key: "env", Value: "prod"}}},
+		{DomainID: corp.ID, Name: "ci.dev", Type: "A", Content: "192.0.2.2", Tags: []models.RecordTag{{Key: "env", Value: "dev"}}},
+		{DomainID: corp.ID, Name: "docs", Type: "CNAME", Content: "www.corp.lan."},
+		{DomainID: other.ID, Name: "www", Type: "A", Content: "192.0.2.3", Tags: []models.RecordTag{{Key: "env", Value: "prod"}}},
+	} {
+		record.TTL = 360
+		db.Create(&record)
+	}
+
+	tests := []struct {
+		user  models.User
+		query string
+		want  []string
+	}{
+		{alice, "?type=a", []string{"ci.dev.corp.lan", "www.corp.lan"}},
+		{bob, "?type=A", []string{"www.other.lan"}},
+		{carol, "?type=A", []string{"ci.dev.corp.lan"}},
+		{alice, "?tag=env", []string{"ci.dev.corp.lan", "www.corp.lan"}},
+		{alice, "?tag=env=prod", []string{"www.corp.lan"}},
+		{carol, "?tag=env=prod", nil},
+	}
+	for _, tt := range tests {
+		r := signedIn(tt.user)
+		r.GET("/api/records/search", SearchRecords(db))
+		w := serve(r, jsonRequest(http.MethodGet, "/api/records/search"+tt.query, ""))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: %d %s", tt.user.Username, tt.query, w.Code, w.Body)
+		}
+		var results []struct {
+			Name       string `json:"name"`
+			DomainName string `json:"domain_name"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &results)
+		var got []string
+		for _, result := range results {
+			got = append(got, result.Name+"."+result.DomainName)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s %s: expected %v, got %v", tt.user.Username, tt.query, tt.want, got)
+		}
+	}
+
+	r := signedIn(alice)
+	r.GET("/api/records/search", SearchRecords(db))
+	if w := serve(r, jsonRequest(http.MethodGet, "/api/records/search?ip=not-an-ip", "")); w.Code != http.StatusBadRequest {
+		t.Errorf("malformed ip filter: expected 400, got %d", w.Code)
+	}
+}
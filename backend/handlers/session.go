@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// generateToken returns a random URL-safe token of n bytes of entropy
+func generateToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the SHA-256 hex digest used to store opaque tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// signAccessToken creates a short-lived JWT bound to a session
+func signAccessToken(user models.User, sessionID uint) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss":  "localdns",
+		"sub":  user.ID,
+		"role": user.Role,
+		"sid":  sessionID,
+		"exp":  time.Now().Add(accessTokenTTL).Unix(),
+	})
+	return token.SignedString(SecretKey)
+}
+
+// issueSession starts a new session for user and returns the login response
+func issueSession(db *gorm.DB, c *gin.Context, user models.User) (gin.H, error) {
+	refreshToken, err := generateToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		UserID:      user.ID,
+		RefreshHash: hashToken(refreshToken),
+		UserAgent:   c.Request.UserAgent(),
+		IP:          c.ClientIP(),
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(refreshTokenTTL),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+
+	accessToken, err := signAccessToken(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(accessTokenTTL.Seconds()),
+		"user":          gin.H{"id": user.ID, "username": user.Username, "role": user.Role},
+	}, nil
+}
+
+// revokeSessions revokes every active session of a user except keepID (0 revokes all)
+func revokeSessions(db *gorm.DB, userID uint, keepID uint) (int64, error) {
+	result := db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL AND id <> ?", userID, keepID).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new
+// refresh token. Presenting an already-rotated refresh token revokes the session.
+func RefreshToken(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			RefreshToken string `json:"refresh_token" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		hash := hashToken(input.RefreshToken)
+		var session models.Session
+		if err := db.Where("refresh_hash = ?", hash).First(&session).Error; err != nil {
+			// A rotated-out token being replayed means it leaked; kill the session
+			if db.Where("previous_hash = ?", hash).First(&session).Error == nil {
+				db.Model(&session).Update("revoked_at", time.Now())
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
+			return
+		}
+
+		var user models.User
+		if err := db.First(&user, session.UserID).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User no longer exists"})
+			return
+		}
+
+		refreshToken, err := generateToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+			return
+		}
+		now := time.Now()
+		rotated := db.Model(&models.Session{}).
+			Where("id = ? AND refresh_hash = ?", session.ID, hash).
+			Updates(map[string]interface{}{
+				"refresh_hash":  hashToken(refreshToken),
+				"previous_hash": hash,
+				"last_used_at":  now,
+				"expires_at":    now.Add(refreshTokenTTL),
+			})
+		if rotated.Error != nil || rotated.RowsAffected == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+
+		accessToken, err := signAccessToken(user, session.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"token":         accessToken,
+			"refresh_token": refreshToken,
+			"expires_in":    int(accessTokenTTL.Seconds()),
+			"user":          gin.H{"id": user.ID, "username": user.Username, "role": user.Role},
+		})
+	}
+}
+
+// Logout revokes the session of the current access token
+func Logout(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionID, ok := c.Get("session_id")
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "API keys cannot log out; delete the key instead"})
+			return
+		}
+		db.Model(&models.Session{}).Where("id = ?", sessionID).Update("revoked_at", time.Now())
+		if _, impersonating := c.Get("impersonator_id"); impersonating {
+			audit(c, "impersonation.end", "session", sessionID, nil, nil)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+	}
+}
+
+// ListUserSessions returns the active sessions of a user (admin only)
+func ListUserSessions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "user.manage", "users:admin") {
+			return
+		}
+
+		var sessions []models.Session
+		db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", c.Param("id"), time.Now()).
+			Order("last_used_at DESC").Find(&sessions)
+		c.JSON(http.StatusOK, sessions)
+	}
+}
+
+// RevokeUserSessions signs a user out everywhere (admin only)
+func RevokeUserSessions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "user.manage", "users:admin") {
+			return
+		}
+
+		var user models.User
+		if result := db.First(&user, c.Param("id")); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		revoked, err := revokeSessions(db, user.ID, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions: " + err.Error()})
+			return
+		}
+		audit(c, "user.sessions_revoke", "user", user.ID, nil, gin.H{"revoked": revoked})
+		c.JSON(http.StatusOK, gin.H{"message": "Sessions revoked", "revoked": revoked})
+	}
+}
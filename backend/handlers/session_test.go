@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+// sessionRouter serves refresh, logout, session revocation and one
+// authenticated route, as main wires them
+func sessionRouter(db *gorm.DB) *gin.Engine {
+	r := gin.New()
+	r.POST("/api/token/refresh", RefreshToken(db))
+	api := r.Group("/api", AuthMiddleware(db))
+	api.GET("/domains", ListDomains(db))
+	api.POST("/logout", Logout(db))
+	api.POST("/users/:id/sessions/revoke", RevokeUserSessions(db))
+	return r
+}
+
+// signIn starts a session for user and returns its access and refresh tokens
+func signIn(t *testing.T, db *gorm.DB, user models.User) (string, string) {
+	t.Helper()
+	response, err := issueSession(db, testContext(), user)
+	if err != nil {
+		t.Fatalf("issue session: %v", err)
+	}
+	return response["token"].(string), response["refresh_token"].(string)
+}
+
+// authorized sends an authenticated request with token
+func authorized(r *gin.Engine, method, path, token string) int {
+	req := jsonRequest(method, path, "")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return serve(r, req).Code
+}
+
+// refresh exchanges a refresh token and returns the status and new tokens
+func refresh(r *gin.Engine, token string) (int, string, string) {
+	w := serve(r, jsonRequest(http.MethodPost, "/api/token/refresh", `{"refresh_token":"`+token+`"}`))
+	var body struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return w.Code, body.Token, body.RefreshToken
+}
+
+func TestRefreshTokenRotatesAndDetectsReuse(t *testing.T) {
+	db := newTestDB(t)
+	user := models.User{Username: "alice", Role: "user"}
+	db.Create(&user)
+	r := sessionRouter(db)
+	_, first := signIn(t, db, user)
+
+	code, access, second := refresh(r, first)
+	if code != http.StatusOK || second == "" || second == first {
+		t.Fatalf("refresh: %d, new refresh token %q", code, second)
+	}
+	if code := authorized(r, http.MethodGet, "/api/domains", access); code != http.StatusOK {
+		t.Fatalf("new access token rejected: %d", code)
+	}
+
+	// Replaying the rotated-out token revokes the whole session
+	if code, _, _ := refresh(r, first); code != http.StatusUnauthorized {
+		t.Errorf("replayed refresh token: expected 401, got %d", code)
+	}
+	if code, _, _ := refresh(r, second); code != http.StatusUnauthorized {
+		t.Errorf("current refresh token after reuse: expected 401, got %d", code)
+	}
+	if code := authorized(r, http.MethodGet, "/api/domains", access); code != http.StatusUnauthorized {
+		t.Errorf("access token after reuse: expected 401, got %d", code)
+	}
+}
+
+func TestLogoutAndRevocationEndAccessTokens(t *testing.T) {
+	db := newTestDB(t)
+	admin := models.User{Username: "admin", Role: "admin"}
+	user := models.User{Username: "alice", Role: "user"}
+	db.Create(&admin)
+	db.Create(&user)
+	r := sessionRouter(db)
+
+	access, refreshToken := signIn(t, db, user)
+	if code := authorized(r, http.MethodPost, "/api/logout", access); code != http.StatusOK {
+		t.Fatalf("logout: %d", code)
+	}
+	if code := authorized(r, http.MethodGet, "/api/domains", access); code != http.StatusUnauthorized {
+		t.Errorf("access token after logout: expected 401, got %d", code)
+	}
+	if code, _, _ := refresh(r, refreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh after logout: expected 401, got %d", code)
+	}
+
+	laptop, _ := signIn(t, db, user)
+	phone, _ := signIn(t, db, user)
+	adminAccess, _ := signIn(t, db, admin)
+	if code := authorized(r, http.MethodPost, fmt.Sprintf("/api/users/%d/sessions/revoke", user.ID), adminAccess); code != http.StatusOK {
+		t.Fatalf("revoke sessions: %d", code)
+	}
+	for _, token := range []string{laptop, phone} {
+		if code := authorized(r, http.MethodGet, "/api/domains", token); code != http.StatusUnauthorized {
+			t.Errorf("access token after revocation: expected 401, got %d", code)
+		}
+	}
+	if code := authorized(r, http.MethodGet, "/api/domains", adminAccess); code != http.StatusOK {
+		t.Errorf("the admin's own session was revoked: %d", code)
+	}
+}
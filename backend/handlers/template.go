@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+var templateVarPattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+// templateVariables lists the placeholders used by a template, excluding {{domain}}
+func templateVariables(template models.RecordTemplate) []string {
+	seen := map[string]bool{}
+	vars := []string{}
+	for _, r := range template.Records {
+		for _, field := range []string{r.Name, r.Type, r.Content} {
+			for _, m := range templateVarPattern.FindAllStringSubmatch(field, -1) {
+				if m[1] != "domain" && !seen[m[1]] {
+					seen[m[1]] = true
+					vars = append(vars, m[1])
+				}
+			}
+		}
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+// renderTemplate expands a template's records for a domain. It fails if a
+// required variable has not been provided.
+func renderTemplate(template models.RecordTemplate, domain string, vars map[string]string) ([]models.Record, error) {
+	values := map[string]string{"domain": domain}
+	for k, v := range vars {
+		values[k] = v
+	}
+
+	var missing []string
+	for _, name := range templateVariables(template) {
+		if strings.TrimSpace(values[name]) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing template variables: %s", strings.Join(missing, ", "))
+	}
+
+	expand := func(s string) string {
+		return templateVarPattern.ReplaceAllStringFunc(s, func(m string) string {
+			return values[templateVarPattern.FindStringSubmatch(m)[1]]
+		})
+	}
+
+	records := make([]models.Record, 0, len(template.Records))
+	for _, r := range template.Records {
+		record := models.Record{
+			Name:    expand(r.Name),
+			Type:    strings.ToUpper(expand(r.Type)),
+			Content: expand(r.Content),
+			TTL:     r.TTL,
+			Prio:    r.Prio,
+		}
+		// Variables may hold internationalised names; records store A-labels
+		var err error
+		if record.Name, err = normalizeRecordName(record.Name); err != nil {
+			return nil, fmt.Errorf("record %s: %w", record.Name, err)
+		}
+		if record.Content, err = normalizeRecordContent(record.Type, record.Content); err != nil {
+			return nil, fmt.Errorf("record %s: %w", record.Name, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// templateChange is one step of applying a template to a domain
+type templateChange struct {
+	Action   string         `json:"action"` // create, update, skip
+	Record   models.Record  `json:"record"`
+	Existing *models.Record `json:"existing,omitempty"`
+}
+
+// planTemplate compares rendered records against the domain's current records.
+// Identical records are skipped and a CNAME replaces any existing CNAME of the same name.
+func planTemplate(db *gorm.DB, domainID uint, rendered []models.Record) []templateChange {
+	var existing []models.Record
+	db.Where("domain_id = ?", domainID).Find(&existing)
+
+	plan := make([]templateChange, 0, len(rendered))
+	for _, r := range rendered {
+		r.DomainID = domainID
+		if r.TTL == 0 {
+			r.TTL = 360
+		}
+		change := templateChange{Action: "create", Record: r}
+		for i := range existing {
+			e := existing[i]
+			if !strings.EqualFold(e.Name, r.Name) || !strings.EqualFold(e.Type, r.Type) {
+				continue
+			}
+			if e.Content == r.Content {
+				change = templateChange{Action: "skip", Record: r, Existing: &e}
+				break
+			}
+			if r.Type == "CNAME" {
+				change = templateChange{Action: "update", Record: r, Existing: &e}
+			}
+		}
+		plan = append(plan, change)
+	}
+	return plan
+}
+
+// applyTemplatePlan executes a plan produced by planTemplate. New records
+// of a domain that is not served are held like records added directly.
+func applyTemplatePlan(tx *gorm.DB, domain models.Domain, actor uint, comment string, plan []templateChange) error {
+	domainID := domain.ID
+	for _, change := range plan {
+		switch change.Action {
+		case "create":
+			record := change.Record
+			holdForDomain(&record, domain)
+			if err := tx.Create(&record).Error; err != nil {
+				return err
+			}
+			if err := recordHistory(tx, domainID, "create", actor, comment, nil, &record); err != nil {
+				return err
+			}
+		case "update":
+			before := *change.Existing
+			record := before
+			record.Content = change.Record.Content
+			record.TTL = change.Record.TTL
+			record.Prio = change.Record.Prio
+			if err := tx.Save(&record).Error; err != nil {
+				return err
+			}
+			if err := recordHistory(tx, domainID, "update", actor, comment, &before, &record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadTemplate fetches a template with its records and variable list
+func loadTemplate(db *gorm.DB, id interface{}) (models.RecordTemplate, error) {
+	var template models.RecordTemplate
+	if err := db.Preload("Records").First(&template, id).Error; err != nil {
+		return template, err
+	}
+	template.Variables = templateVariables(template)
+	return template, nil
+}
+
+// ListTemplates returns all record templates
+func ListTemplates(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireScope(c, "templates:read", "") {
+			return
+		}
+		var templates []models.RecordTemplate
+		if result := db.Preload("Records").Order("name ASC").Find(&templates); result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+			return
+		}
+		for i := range templates {
+			templates[i].Variables = templateVariables(templates[i])
+		}
+		c.JSON(http.StatusOK, templates)
+	}
+}
+
+// GetTemplate returns a single record template
+func GetTemplate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireScope(c, "templates:read", "") {
+			return
+		}
+		template, err := loadTemplate(db, c.Param("templateId"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+			return
+		}
+		c.JSON(http.StatusOK, template)
+	}
+}
+
+type templateInput struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	Records     []struct {
+		Name    string `json:"name" binding:"required"`
+		Type    string `json:"type" binding:"required"`
+		Content string `json:"content" binding:"required"`
+		TTL     int    `json:"ttl"`
+		Prio    int    `json:"prio"`
+	} `json:"records" binding:"required"`
+}
+
+func (input templateInput) records() []models.TemplateRecord {
+	records := make([]models.TemplateRecord, 0, len(input.Records))
+	for _, r := range input.Records {
+		records = append(records, models.TemplateRecord{
+			Name:    r.Name,
+			Type:    r.Type,
+			Content: r.Content,
+			TTL:     r.TTL,
+			Prio:    r.Prio,
+		})
+	}
+	return records
+}
+
+// CreateTemplate defines a new record template (admin only)
+func CreateTemplate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "template.write", "templates:write") {
+			return
+		}
+
+		var input templateInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		template := models.RecordTemplate{
+			Name:        input.Name,
+			Description: input.Description,
+			Records:     input.records(),
+		}
+		if err := db.Create(&template).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create template: " + err.Error()})
+			return
+		}
+		template.Variables = templateVariables(template)
+		audit(c, "template.create", "template", template.ID, nil, template)
+		c.JSON(http.StatusCreated, template)
+	}
+}
+
+// UpdateTemplate replaces a record template's definition (admin only)
+func UpdateTemplate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "template.write", "templates:write") {
+			return
+		}
+
+		template, err := loadTemplate(db, c.Param("templateId"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+			return
+		}
+
+		var input templateInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		before := template
+		template.Name = input.Name
+		template.Description = input.Description
+		template.Records = input.records()
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("template_id = ?", template.ID).Delete(&models.TemplateRecord{}).Error; err != nil {
+				return err
+			}
+			return tx.Save(&template).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save template: " + err.Error()})
+			return
+		}
+		template.Variables = templateVariables(template)
+		audit(c, "template.update", "template", template.ID, before, template)
+		c.JSON(http.StatusOK, template)
+	}
+}
+
+// DeleteTemplate removes a record template (admin only)
+func DeleteTemplate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "template.write", "templates:write") {
+			return
+		}
+
+		template, err := loadTemplate(db, c.Param("templateId"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("template_id = ?", template.ID).Delete(&models.TemplateRecord{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&template).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete template: " + err.Error()})
+			return
+		}
+		audit(c, "template.delete", "template", template.ID, template, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Template deleted"})
+	}
+}
+
+// templateForDomain loads the domain and template named in the URL and renders
+// the template with the variables from the request body
+func templateForDomain(c *gin.Context, db *gorm.DB, perm, scope string) (models.Domain, []templateChange, bool) {
+	domain, ok := loadDomain(c, db, c.Param("id"), perm, scope)
+	if !ok {
+		return domain, nil, false
+	}
+
+	template, err := loadTemplate(db, c.Param("templateId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return domain, nil, false
+	}
+
+	var input struct {
+		Variables map[string]string `json:"variables"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return domain, nil, false
+		}
+	}
+
+	rendered, err := renderTemplate(template, domain.Name, input.Variables)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return domain, nil, false
+	}
+	return domain, planTemplate(db, domain.ID, rendered), true
+}
+
+// PreviewTemplate shows which records applying a template would create or change
+func PreviewTemplate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, plan, ok := templateForDomain(c, db, "record.read", "records:read")
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"changes": plan})
+	}
+}
+
+// ApplyTemplate applies a template to an existing domain
+func ApplyTemplate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		domain, plan, ok := templateForDomain(c, db, "record.write", "records:write")
+		if !ok {
+			return
+		}
+		if !requireUnlocked(c, domain, "update") {
+			return
+		}
+
+		comment := "template #" + c.Param("templateId")
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return applyTemplatePlan(tx, domain, actorID(c), comment, plan)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply template: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Template applied", "changes": plan})
+	}
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/localdns/backend/models"
+)
+
+func TestTemplateReadsNeedTemplateScope(t *testing.T) {
+	db := newTestDB(t)
+	user := models.User{Username: "alice", Role: "user"}
+	db.Create(&user)
+	template := models.RecordTemplate{Name: "web"}
+	db.Create(&template)
+
+	tests := []struct {
+		scopes []string
+		want   int
+	}{
+		{[]string{"records:read"}, http.StatusForbidden},
+		{[]string{"templates:read"}, http.StatusOK},
+		{[]string{"templates:write"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := withAPIKey(user, tt.scopes...)
+		r.GET("/api/templates", ListTemplates(db))
+		r.GET("/api/templates/:templateId", GetTemplate(db))
+		for _, path := range []string{"/api/templates", fmt.Sprintf("/api/templates/%d", template.ID)} {
+			if w := serve(r, jsonRequest(http.MethodGet, path, "")); w.Code != tt.want {
+				t.Errorf("%s with %v: expected %d, got %d", path, tt.scopes, tt.want, w.Code)
+			}
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// throttle tracks failed attempts per key (client IP, username, ...) and
+// imposes an exponentially growing wait once a key exceeds its free attempts.
+// State is kept in memory; it resets when the backend restarts.
+type throttle struct {
+	mu       sync.Mutex
+	entries  map[string]*throttleEntry
+	free     int           // failures allowed before backoff starts
+	base     time.Duration // first backoff delay, doubled on every further failure
+	max      time.Duration
+	forgetAt time.Duration // quiet period after which a key starts over
+}
+
+type throttleEntry struct {
+	failures     int
+	blockedUntil time.Time
+	lastFailure  time.Time
+}
+
+func newThrottle(free int, base, max time.Duration) *throttle {
+	return &throttle{entries: map[string]*throttleEntry{}, free: free, base: base, max: max, forgetAt: time.Hour}
+}
+
+// wait returns how long key must wait before its next attempt
+func (t *throttle) wait(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.entries[key]
+	if !ok {
+		return 0
+	}
+	if time.Since(entry.lastFailure) > t.forgetAt {
+		delete(t.entries, key)
+		return 0
+	}
+	return time.Until(entry.blockedUntil)
+}
+
+// fail records a failed attempt for key
+func (t *throttle) fail(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failLocked(key)
+}
+
+// take returns how long key must wait, or records the attempt as a failure
+// when it may go ahead. Checking and counting under one lock means parallel
+// requests cannot all pass before the first of them has failed.
+func (t *throttle) take(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if entry, ok := t.entries[key]; ok && time.Since(entry.lastFailure) <= t.forgetAt {
+		if wait := time.Until(entry.blockedUntil); wait > 0 {
+			return wait
+		}
+	}
+	t.failLocked(key)
+	return 0
+}
+
+func (t *throttle) failLocked(key string) {
+	now := time.Now()
+	entry, ok := t.entries[key]
+	if !ok || now.Sub(entry.lastFailure) > t.forgetAt {
+		entry = &throttleEntry{}
+		t.entries[key] = entry
+	}
+	entry.failures++
+	entry.lastFailure = now
+	if over := entry.failures - t.free; over > 0 {
+		delay := time.Duration(float64(t.base) * math.Pow(2, float64(over-1)))
+		if delay > t.max || delay <= 0 {
+			delay = t.max
+		}
+		entry.blockedUntil = now.Add(delay)
+	}
+
+	// Keep memory bounded under a flood of distinct keys
+	if len(t.entries) > 10000 {
+		for k, e := range t.entries {
+			if now.Sub(e.lastFailure) > t.forgetAt || now.After(e.blockedUntil) {
+				delete(t.entries, k)
+			}
+		}
+	}
+}
+
+// forgive takes back one attempt recorded for key, e.g. one that take
+// reserved and that turned out to succeed, leaving earlier failures counted
+func (t *throttle) forgive(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.entries[key]
+	if !ok {
+		return
+	}
+	entry.failures--
+	if entry.failures <= 0 {
+		delete(t.entries, key)
+	} else if entry.failures <= t.free {
+		entry.blockedUntil = time.Time{}
+	}
+}
+
+// reset forgets key, e.g. after a successful login
+func (t *throttle) reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key)
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+var (
+	// Failed logins per client IP and per username
+	ipThrottle   = newThrottle(getEnvInt("LOGIN_FREE_ATTEMPTS_PER_IP", 20), time.Second, 15*time.Minute)
+	userThrottle = newThrottle(getEnvInt("LOGIN_FREE_ATTEMPTS_PER_USER", 5), time.Second, 15*time.Minute)
+	// Every registration counts, so accounts cannot be created in bulk
+	registerThrottle = newThrottle(getEnvInt("REGISTER_FREE_ATTEMPTS_PER_IP", 5), time.Minute, time.Hour)
+	// Verification and password reset mails, per client IP and per recipient account
+	mailThrottle = newThrottle(3, time.Minute, time.Hour)
+
+	lockoutThreshold = getEnvInt("LOGIN_LOCKOUT_THRESHOLD", 10)
+	lockoutDuration  = time.Duration(getEnvInt("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute
+)
+
+func userThrottleKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// throttled writes a 429 response when any of the keys is backing off.
+// Otherwise the attempt is counted against every key up front; a successful
+// login resets them. It runs before any password hashing so floods stay cheap.
+func throttled(c *gin.Context, checks map[*throttle]string) bool {
+	var wait time.Duration
+	for t, key := range checks {
+		if w := t.take(key); w > wait {
+			wait = w
+		}
+	}
+	if wait <= 0 {
+		return false
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       fmt.Sprintf("Too many attempts, try again in %d seconds", seconds),
+		"retry_after": seconds,
+	})
+	return true
+}
+
+// accountLocked reports whether username belongs to a locked account
+func accountLocked(db *gorm.DB, username string) (*time.Time, bool) {
+	var user models.User
+	if err := db.Select("id", "locked_until").Where("username = ?", username).First(&user).Error; err != nil {
+		return nil, false
+	}
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return user.LockedUntil, true
+	}
+	return nil, false
+}
+
+// recordLoginFailure counts a failed login against the account and locks it
+// once it reaches the lockout threshold. The client IP and username were
+// already counted by throttled.
+func recordLoginFailure(db *gorm.DB, c *gin.Context, username string) {
+	// Increment in the database so concurrent failures are all counted
+	var user models.User
+	result := db.Model(&user).Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}, {Name: "failed_logins"}}}).
+		Where("username = ?", username).
+		Update("failed_logins", gorm.Expr("failed_logins + 1"))
+	if result.Error != nil || result.RowsAffected == 0 || user.FailedLogins < lockoutThreshold {
+		return
+	}
+
+	// Only the request that crosses the threshold locks the account
+	until := time.Now().Add(lockoutDuration)
+	lock := db.Model(&models.User{}).Where("id = ? AND failed_logins >= ?", user.ID, lockoutThreshold).
+		Updates(map[string]interface{}{"locked_until": until, "failed_logins": 0})
+	if lock.RowsAffected == 0 {
+		return
+	}
+	writeAudit(db, models.AuditLog{
+		Action:     "user.lockout",
+		TargetType: "user",
+		TargetID:   fmt.Sprint(user.ID),
+		IP:         c.ClientIP(),
+		Diff:       jsonDiff(nil, gin.H{"failed_logins": user.FailedLogins, "locked_until": until}),
+	})
+}
+
+// recordLoginSuccess clears the failure counters of a user. The client IP
+// only gets its successful attempt back: one account signing in from an
+// address must not wipe the failures others from it have run up.
+func recordLoginSuccess(db *gorm.DB, c *gin.Context, user models.User) {
+	ipThrottle.forgive(c.ClientIP())
+	userThrottle.reset(userThrottleKey(user.Username))
+	if user.FailedLogins != 0 || user.LockedUntil != nil {
+		db.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"failed_logins": 0,
+			"locked_until":  nil,
+		})
+	}
+}
+
+// UnlockUser lifts a lockout and clears the login throttling of a user (admin only)
+func UnlockUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "user.manage", "users:admin") {
+			return
+		}
+
+		var user models.User
+		if result := db.First(&user, c.Param("id")); result.Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		if err := db.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"failed_logins": 0,
+			"locked_until":  nil,
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user: " + err.Error()})
+			return
+		}
+		userThrottle.reset(userThrottleKey(user.Username))
+		audit(c, "user.unlock", "user", user.ID, gin.H{"locked_until": user.LockedUntil}, gin.H{"locked_until": nil})
+		c.JSON(http.StatusOK, gin.H{"message": "User unlocked"})
+	}
+}
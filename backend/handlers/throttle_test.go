@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/localdns/backend/models"
+)
+
+func TestThrottleTakeReservesAttempts(t *testing.T) {
+	th := newThrottle(3, time.Minute, time.Hour)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if th.take("key") == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 4 {
+		t.Errorf("expected 4 attempts before the backoff, got %d", allowed)
+	}
+
+	th.reset("key")
+	if wait := th.take("key"); wait != 0 {
+		t.Errorf("expected no wait after reset, got %v", wait)
+	}
+}
+
+func TestRecordLoginFailureLocksAtThreshold(t *testing.T) {
+	db := newTestDB(t)
+	user := models.User{Username: "alice", Role: "user", AuthSource: "local"}
+	db.Create(&user)
+
+	for i := 1; i < lockoutThreshold; i++ {
+		recordLoginFailure(db, testContext(), "alice")
+	}
+	db.First(&user, user.ID)
+	if user.FailedLogins != lockoutThreshold-1 || user.LockedUntil != nil {
+		t.Fatalf("before the threshold: failed_logins=%d locked_until=%v", user.FailedLogins, user.LockedUntil)
+	}
+
+	recordLoginFailure(db, testContext(), "alice")
+	db.First(&user, user.ID)
+	if user.FailedLogins != 0 || user.LockedUntil == nil || !user.LockedUntil.After(time.Now()) {
+		t.Errorf("at the threshold: failed_logins=%d locked_until=%v", user.FailedLogins, user.LockedUntil)
+	}
+	var lockouts int64
+	db.Model(&models.AuditLog{}).Where("action = ?", "user.lockout").Count(&lockouts)
+	if lockouts != 1 {
+		t.Errorf("expected one lockout audit entry, got %d", lockouts)
+	}
+}
+
+func TestRecordLoginSuccessKeepsFailuresOfTheAddress(t *testing.T) {
+	db := newTestDB(t)
+	user := models.User{Username: "alice", Role: "user", AuthSource: "local"}
+	db.Create(&user)
+	c := testContext()
+	ip := c.ClientIP()
+	t.Cleanup(func() { ipThrottle.reset(ip); userThrottle.reset(userThrottleKey(user.Username)) })
+
+	for i := 0; i < 3; i++ {
+		ipThrottle.fail(ip)
+	}
+	userThrottle.fail(userThrottleKey(user.Username))
+	ipThrottle.take(ip)
+	userThrottle.take(userThrottleKey(user.Username))
+	recordLoginSuccess(db, c, user)
+
+	ipThrottle.mu.Lock()
+	failures := ipThrottle.entries[ip].failures
+	ipThrottle.mu.Unlock()
+	if failures != 3 {
+		t.Errorf("expected the address to keep its 3 failures, got %d", failures)
+	}
+	userThrottle.mu.Lock()
+	_, kept := userThrottle.entries[userThrottleKey(user.Username)]
+	userThrottle.mu.Unlock()
+	if kept {
+		t.Error("the user's failures were not cleared")
+	}
+}
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+)
+
+// defaultTLDs are the suffixes offered on a fresh install (see tld-lokal.md)
+var defaultTLDs = []models.TLD{
+	{Name: "lan", Description: "Local network", Enabled: true},
+	{Name: "test", Description: "Reserved for testing (RFC 2606)", Enabled: true},
+	{Name: "local", Description: "Local network; clashes with mDNS (RFC 6762)", Enabled: true},
+	{Name: "home", Description: "Home network", Enabled: true},
+	{Name: "internal", Description: "Private use", Enabled: true},
+}
+
+// SeedTLDs creates the default TLDs when none have been configured
+func SeedTLDs(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&models.TLD{}).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+	for _, tld := range defaultTLDs {
+		tld := tld
+		if err := db.Create(&tld).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateLabel checks a DNS label against the LDH rule: 1-63 letters,
+// digits and hyphens, not starting or ending with a hyphen
+func validateLabel(label string) error {
+	if label == "" {
+		return fmt.Errorf("empty label")
+	}
+	if len(label) > 63 {
+		return fmt.Errorf("label %q is longer than 63 characters", label)
+	}
+	for _, r := range label {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return fmt.Errorf("label %q may only contain letters, digits and hyphens", label)
+		}
+	}
+	if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+		return fmt.Errorf("label %q cannot start or end with a hyphen", label)
+	}
+	return nil
+}
+
+// normalizeDomainName lowercases a domain name, drops the root dot,
+// converts internationalised labels to A-labels and checks every label and
+// the overall length
+func normalizeDomainName(name string) (string, error) {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if name == "" {
+		return "", fmt.Errorf("domain name is required")
+	}
+	if needsIDNA(name) {
+		ascii, err := toASCIIName(domainIDNA, name)
+		if err != nil {
+			return "", err
+		}
+		name = ascii
+	}
+	if len(name) > 253 {
+		return "", fmt.Errorf("domain name is longer than 253 characters")
+	}
+	for _, label := range strings.Split(name, ".") {
+		if err := validateLabel(label); err != nil {
+			return "", err
+		}
+	}
+	return name, nil
+}
+
+// checkRegistrable applies the TLD policy to a normalized domain name: it
+// must sit one label below an enabled TLD and not be reserved. Returns the
+// matching TLD.
+func checkRegistrable(db *gorm.DB, name string, createAny bool) (models.TLD, error) {
+	var tld models.TLD
+	dot := strings.Index(name, ".")
+	if dot < 0 {
+		return tld, fmt.Errorf("%s is not below a TLD", name)
+	}
+	label, suffix := name[:dot], name[dot+1:]
+	if err := db.Where("name = ?", suffix).First(&tld).Error; err != nil {
+		return tld, fmt.Errorf(".%s is not a registrable TLD", suffix)
+	}
+	if !tld.Enabled {
+		return tld, fmt.Errorf(".%s is closed for new registrations", suffix)
+	}
+
+	var reserved models.ReservedName
+	if err := db.Where("name IN ?", []string{label, name}).Order("blocked DESC").First(&reserved).Error; err == nil {
+		if reserved.Blocked || !createAny {
+			return tld, fmt.Errorf("%s is reserved", name)
+		}
+	}
+	return tld, nil
+}
+
+// validateTerms checks that a TLD's terms are not negative and that its
+// default term lies between its minimum and maximum
+func validateTerms(tld models.TLD) error {
+	if tld.DefaultExpiry < 0 || tld.MinTerm < 0 || tld.MaxTerm < 0 {
+		return fmt.Errorf("terms cannot be negative")
+	}
+	if tld.MaxTerm > 0 && tld.MinTerm > tld.MaxTerm {
+		return fmt.Errorf("min_term_days cannot exceed max_term_days")
+	}
+	if tld.DefaultExpiry > 0 && (tld.DefaultExpiry < tld.MinTerm || tld.MaxTerm > 0 && tld.DefaultExpiry > tld.MaxTerm) {
+		return fmt.Errorf("default_expiry_days must lie between min_term_days and max_term_days")
+	}
+	return nil
+}
+
+// ListTLDs returns the configured TLDs
+func ListTLDs(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tlds := []models.TLD{}
+		db.Order("name ASC").Find(&tlds)
+		c.JSON(http.StatusOK, tlds)
+	}
+}
+
+// CreateTLD adds a registrable suffix
+func CreateTLD(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "config.write", "whois:admin") {
+			return
+		}
+
+		var input struct {
+			Name          string `json:"name" binding:"required"`
+			Description   string `json:"description"`
+			DefaultExpiry int    `json:"default_expiry_days"`
+			MinTerm       int    `json:"min_term_days"`
+			MaxTerm       int    `json:"max_term_days"`
+			Enabled       *bool  `json:"enabled"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		name, err := normalizeDomainName(strings.TrimPrefix(strings.TrimSpace(input.Name), "."))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tld := models.TLD{
+			Name:          name,
+			Description:   input.Description,
+			DefaultExpiry: input.DefaultExpiry,
+			MinTerm:       input.MinTerm,
+			MaxTerm:       input.MaxTerm,
+			Enabled:       true,
+		}
+		if input.Enabled != nil {
+			tld.Enabled = *input.Enabled
+		}
+		if err := validateTerms(tld); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := db.Create(&tld).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "TLD already exists"})
+			return
+		}
+		audit(c, "tld.create", "tld", tld.ID, nil, tld)
+		c.JSON(http.StatusCreated, tld)
+	}
+}
+
+// UpdateTLD changes a TLD's description, default expiry or whether it is
+// open for registrations
+func UpdateTLD(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "config.write", "whois:admin") {
+			return
+		}
+
+		var tld models.TLD
+		if err := db.First(&tld, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "TLD not found"})
+			return
+		}
+
+		var input struct {
+			Description   *string `json:"description"`
+			DefaultExpiry *int    `json:"default_expiry_days"`
+			MinTerm       *int    `json:"min_term_days"`
+			MaxTerm       *int    `json:"max_term_days"`
+			Enabled       *bool   `json:"enabled"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		before := tld
+		if input.Description != nil {
+			tld.Description = *input.Description
+		}
+		if input.DefaultExpiry != nil {
+			tld.DefaultExpiry = *input.DefaultExpiry
+		}
+		if input.MinTerm != nil {
+			tld.MinTerm = *input.MinTerm
+		}
+		if input.MaxTerm != nil {
+			tld.MaxTerm = *input.MaxTerm
+		}
+		if input.Enabled != nil {
+			tld.Enabled = *input.Enabled
+		}
+		if err := validateTerms(tld); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.Save(&tld).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save TLD: " + err.Error()})
+			return
+		}
+		audit(c, "tld.update", "tld", tld.ID, before, tld)
+		c.JSON(http.StatusOK, tld)
+	}
+}
+
+// DeleteTLD removes a TLD that no registered domain uses. Disable it instead
+// to stop new registrations while keeping existing domains.
+func DeleteTLD(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "config.write", "whois:admin") {
+			return
+		}
+
+		var tld models.TLD
+		if err := db.First(&tld, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "TLD not found"})
+			return
+		}
+		var used int64
+		db.Model(&models.Domain{}).Where("name LIKE ?", "%."+tld.Name).Count(&used)
+		if used > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%d domains are registered under .%s; disable it instead", used, tld.Name)})
+			return
+		}
+
+		if err := db.Delete(&tld).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete TLD: " + err.Error()})
+			return
+		}
+		audit(c, "tld.delete", "tld", tld.ID, tld, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "TLD deleted"})
+	}
+}
+
+// ListReservedNames returns the reserved and blocked names
+func ListReservedNames(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "config.write", "whois:admin") {
+			return
+		}
+
+		names := []models.ReservedName{}
+		db.Order("name ASC").Find(&names)
+		c.JSON(http.StatusOK, names)
+	}
+}
+
+// CreateReservedName reserves a label under every TLD ("admin") or a single
+// domain ("admin.lan"). With blocked set, not even domain.create_any can
+// register it.
+func CreateReservedName(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "config.write", "whois:admin") {
+			return
+		}
+
+		var input struct {
+			Name    string `json:"name" binding:"required"`
+			Reason  string `json:"reason"`
+			Blocked bool   `json:"blocked"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		name, err := normalizeDomainName(input.Name)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		reserved := models.ReservedName{Name: name, Reason: input.Reason, Blocked: input.Blocked, CreatedBy: actorID(c)}
+		if err := db.Create(&reserved).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name is already reserved"})
+			return
+		}
+		audit(c, "reserved_name.create", "reserved_name", reserved.ID, nil, reserved)
+		c.JSON(http.StatusCreated, reserved)
+	}
+}
+
+// DeleteReservedName releases a reserved name
+func DeleteReservedName(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireAdmin(c, db, "config.write", "whois:admin") {
+			return
+		}
+
+		var reserved models.ReservedName
+		if err := db.First(&reserved, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reserved name not found"})
+			return
+		}
+		if err := db.Delete(&reserved).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reserved name: " + err.Error()})
+			return
+		}
+		audit(c, "reserved_name.delete", "reserved_name", reserved.ID, reserved, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "Reserved name released"})
+	}
+}
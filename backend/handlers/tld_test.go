@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/localdns/backend/models"
+)
+
+func TestValidateLabel(t *testing.T) {
+	tests := []struct {
+		label string
+		ok    bool
+	}{
+		{"corp", true},
+		{"a", true},
+		{"my-host2", true},
+		{"xn--bcher-kva", true},
+		{"123", true},
+		{strings.Repeat("a", 63), true},
+		{strings.Repeat("a", 64), false},
+		{"", false},
+		{"-corp", false},
+		{"corp-", false},
+		{"-", false},
+		{"my_host", false},
+		{"my host", false},
+		{"Corp", false},
+		{"bücher", false},
+		{"*", false},
+	}
+	for _, tt := range tests {
+		if err := validateLabel(tt.label); (err == nil) != tt.ok {
+			t.Errorf("validateLabel(%q): expected ok=%v, got %v", tt.label, tt.ok, err)
+		}
+	}
+}
+
+func TestNormalizeDomainName(t *testing.T) {
+	tests := []struct {
+		name, want string
+		ok         bool
+	}{
+		{"Corp.LAN.", "corp.lan", true},
+		{" bücher.lan ", "xn--bcher-kva.lan", true},
+		{"corp..lan", "", false},
+		{"-corp.lan", "", false},
+		{"", "", false},
+		{strings.Repeat("a.", 127) + "lan", "", false},
+	}
+	for _, tt := range tests {
+		got, err := normalizeDomainName(tt.name)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("normalizeDomainName(%q): got %q, %v", tt.name, got, err)
+		}
+	}
+}
+
+func TestCheckRegistrable(t *testing.T) {
+	db := newTestDB(t)
+	if err := SeedTLDs(db); err != nil {
+		t.Fatalf("seed TLDs: %v", err)
+	}
+	db.Create(&models.TLD{Name: "old", Enabled: false})
+	db.Create(&models.ReservedName{Name: "www"})
+	db.Create(&models.ReservedName{Name: "admin.lan"})
+	db.Create(&models.ReservedName{Name: "localhost", Blocked: true})
+
+	tests := []struct {
+		name      string
+		createAny bool
+		ok        bool
+	}{
+		{"corp.lan", false, true},
+		{"corp.test", false, true},
+		{"lan", false, false},
+		{"corp.example", false, false},
+		{"dev.corp.lan", false, false},
+		{"corp.old", false, false},
+		{"www.lan", false, false},
+		{"www.home", false, false},
+		{"www.lan", true, true},
+		{"admin.lan", false, false},
+		{"admin.home", false, true},
+		{"admin.lan", true, true},
+		{"localhost.lan", false, false},
+		{"localhost.lan", true, false},
+	}
+	for _, tt := range tests {
+		tld, err := checkRegistrable(db, tt.name, tt.createAny)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkRegistrable(%q, createAny=%v): expected ok=%v, got %v", tt.name, tt.createAny, tt.ok, err)
+		}
+		if err == nil && !strings.HasSuffix(tt.name, "."+tld.Name) {
+			t.Errorf("checkRegistrable(%q) matched TLD %q", tt.name, tld.Name)
+		}
+	}
+}
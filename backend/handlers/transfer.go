@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/localdns/backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// authCodeTTL is how long a domain's auth code can be used
+const authCodeTTL = 7 * 24 * time.Hour
+
+// transferWindow returns how long the owner has to answer a transfer request
+func transferWindow(db *gorm.DB) time.Duration {
+	var config models.RegistrarConfig
+	if err := db.First(&config).Error; err != nil {
+		return 5 * 24 * time.Hour
+	}
+	return time.Duration(config.TransferWindow) * 24 * time.Hour
+}
+
+// transferPending reports whether a transfer of a domain awaits approval
+func transferPending(db *gorm.DB, domainID uint) bool {
+	var pending int64
+	db.Model(&models.DomainTransfer{}).Where("domain_id = ? AND status = ?", domainID, "pending").Count(&pending)
+	return pending > 0
+}
+
+// errTransferClosed is returned when a transfer was resolved in the meantime
+var errTransferClosed = errors.New("transfer is no longer pending")
+
+// transferBlocked is returned when a pending transfer can no longer go
+// through: the domain left active, got locked, or the receiver reached
+// their quota
+type transferBlocked struct{ reason string }
+
+func (e transferBlocked) Error() string { return e.reason }
+
+// completeTransfer moves a pending transfer's domain to the receiving user
+// and closes the transfer with status approved or auto_approved. Grants on
+// the domain are removed, since they were given by the previous owner.
+// The domain's state and the receiver's quota are checked again under lock,
+// as both may have changed since the transfer was requested.
+func completeTransfer(db *gorm.DB, transfer *models.DomainTransfer, status string, actor uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		claim := tx.Model(&models.DomainTransfer{}).Where("id = ? AND status = ?", transfer.ID, "pending").
+			Updates(map[string]interface{}{"status": status, "resolved_by": actor, "resolved_at": &now})
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			return errTransferClosed
+		}
+
+		var domain models.Domain
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&domain, transfer.DomainID).Error; err != nil {
+			return transferBlocked{"domain no longer exists"}
+		}
+		if domain.Status != "active" {
+			return transferBlocked{"domain is no longer active"}
+		}
+		if locked := prohibitedBy(domain, "transfer"); locked != "" {
+			return transferBlocked{"domain status " + locked + " prohibits transfers"}
+		}
+
+		var receiver models.User
+		if err := tx.First(&receiver, transfer.ToUserID).Error; err != nil {
+			return transferBlocked{"receiving user no longer exists"}
+		}
+		if !roleHasPermission(tx, receiver.Role, "domain.create_any") {
+			var quota quotaError
+			if err := checkDomainQuota(tx, receiver.ID); errors.As(err, &quota) {
+				return transferBlocked{fmt.Sprintf("receiving user reached their domain quota (%d domains)", quota.quota)}
+			} else if err != nil {
+				return err
+			}
+		}
+		updates := map[string]interface{}{"user_id": receiver.ID, "organization_id": nil}
+		if transfer.UpdateContacts {
+			updates["registrant_name"] = receiver.ContactName
+			updates["registrant_org"] = receiver.ContactOrg
+			updates["registrant_email"] = receiver.ContactEmail
+			updates["registrant_phone"] = receiver.ContactPhone
+			updates["registrant_address"] = receiver.ContactAddress
+			updates["registrant_city"] = receiver.ContactCity
+			updates["registrant_state"] = receiver.ContactState
+			updates["registrant_zip"] = receiver.ContactZip
+			updates["registrant_country"] = receiver.ContactCountry
+		}
+		if err := tx.Model(&models.Domain{}).Where("id = ?", transfer.DomainID).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := releaseOwnership(tx, transfer.DomainID, actor, "domain transferred"); err != nil {
+			return err
+		}
+
+		transfer.Status, transfer.ResolvedBy, transfer.ResolvedAt = status, actor, &now
+		comment := fmt.Sprintf("transferred from user %d to %s (%s)", transfer.FromUserID, receiver.Username, status)
+		return recordHistory(tx, transfer.DomainID, "update", actor, comment, nil, nil)
+	})
+}
+
+// releaseOwnership drops what the previous owner of a domain set up once it
+// changes hands: grants, delegations, the auth code, and pending transfers
+// and scheduled changes, which are cancelled with reason
+func releaseOwnership(tx *gorm.DB, domainID, actor uint, reason string) error {
+	if err := tx.Where("domain_id = ?", domainID).Delete(&models.Grant{}).Error; err != nil {
+		return err
+	}
+	var delegations []models.Delegation
+	if err := tx.Where("domain_id = ?", domainID).Find(&delegations).Error; err != nil {
+		return err
+	}
+	for _, delegation := range delegations {
+		if err := removeDelegation(tx, delegation, actor, "delegation of "+delegation.Name+" ended: "+reason); err != nil {
+			return err
+		}
+	}
+	if err := tx.Model(&models.Domain{}).Where("id = ?", domainID).
+		Updates(map[string]interface{}{"auth_code_hash": "", "auth_code_expires_at": nil}).Error; err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := tx.Model(&models.DomainTransfer{}).Where("domain_id = ? AND status = ?", domainID, "pending").
+		Updates(map[string]interface{}{"status": "cancelled", "reason": reason, "resolved_by": actor, "resolved_at": &now}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.ScheduledChange{}).Where("domain_id = ? AND status = ?", domainID, "pending").
+		Updates(map[string]interface{}{"status": "cancelled", "result": reason}).Error
+}
+
+// closeTransfer ends a pending transfer without moving the domain
+func closeTransfer(db *gorm.DB, transfer *models.DomainTransfer, status, reason string, actor uint) error {
+	now := time.Now()
+	result := db.Model(&models.DomainTransfer{}).Where("id = ? AND status = ?", transfer.ID, "pending").
+		Updates(map[string]interface{}{"status": status, "reason": reason, "resolved_by": actor, "resolved_at": &now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errTransferClosed
+	}
+	transfer.Status, transfer.Reason, transfer.ResolvedBy, transfer.ResolvedAt = status, reason, actor, &now
+	return nil
+}
+
+// applyTransfers approves pending transfers whose window has passed.
+// Transfers that can no longer go through are cancelled instead.
+func applyTransfers(db *gorm.DB, now time.Time) {
+	var due []models.DomainTransfer
+	if err := db.Where("status = ? AND expires_at <= ?", "pending", now).Find(&due).Error; err != nil {
+		log.Printf("Transfers: failed to load pending transfers: %v", err)
+		return
+	}
+	for _, transfer := range due {
+		before := gin.H{"status": transfer.Status}
+		err := completeTransfer(db, &transfer, "auto_approved", 0)
+		var blocked transferBlocked
+		if errors.As(err, &blocked) {
+			err = closeTransfer(db, &transfer, "cancelled", blocked.reason, 0)
+		}
+		if err != nil {
+			log.Printf("Transfers: transfer %d of %s failed: %v", transfer.ID, transfer.DomainName, err)
+			continue
+		}
+
+		log.Printf("Transfers: transfer %d of %s is now %s", transfer.ID, transfer.DomainName, transfer.Status)
+		writeAudit(db, models.AuditLog{
+			Action:     "transfer." + transfer.Status,
+			TargetType: "domain",
+			TargetID:   fmt.Sprint(transfer.DomainID),
+			Diff:       jsonDiff(before, gin.H{"status": transfer.Status, "to_user_id": transfer.ToUserID}),
+		})
+	}
+}
+
+// CreateAuthCode issues a new auth code for a domain, replacing the previous
+// one. The code is only shown in this response.
+func CreateAuthCode(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		domain, ok := loadDomain(c, db, c.Param("id"), "domain.transfer", "domains:write")
+		if !ok {
+			return
+		}
+		if !requireUnlocked(c, domain, "transfer") {
+			return
+		}
+		if domain.Status != "active" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only active domains can be transferred"})
+			return
+		}
+
+		code, err := generateToken(12)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate auth code"})
+			return
+		}
+		expiresAt := time.Now().Add(authCodeTTL)
+		if err := db.Model(&domain).Updates(map[string]interface{}{
+			"auth_code_hash":       hashToken(code),
+			"auth_code_expires_at": &expiresAt,
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store auth code: " + err.Error()})
+			return
+		}
+		audit(c, "domain.auth_code", "domain", domain.ID, nil, gin.H{"expires_at": expiresAt})
+		c.JSON(http.StatusOK, gin.H{"auth_code": code, "expires_at": expiresAt})
+	}
+}
+
+// RequestTransfer asks for a domain to be transferred to the caller. The
+// auth code is used up by the request; the owner then has the transfer
+// window to approve or reject it.
+func RequestTransfer(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Domain         string `json:"domain" binding:"required"`
+			AuthCode       string `json:"auth_code" binding:"required"`
+			UpdateContacts bool   `json:"update_contacts"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		name := lookupName(input.Domain)
+		if !requireScope(c, "domains:write", name) {
+			return
+		}
+		if !requirePermission(c, db, "domain.create") {
+			return
+		}
+
+		// The same answer whether the domain does not exist or the code is wrong
+		var domain models.Domain
+		if err := db.Where("name = ?", name).First(&domain).Error; err != nil ||
+			domain.AuthCodeHash == "" || domain.AuthCodeHash != hashToken(input.AuthCode) ||
+			domain.AuthCodeExpiresAt == nil || time.Now().After(*domain.AuthCodeExpiresAt) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid domain or auth code"})
+			return
+		}
+		if domain.Status != "active" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only active domains can be transferred"})
+			return
+		}
+		if !requireUnlocked(c, domain, "transfer") {
+			return
+		}
+		userID := c.MustGet("user_id").(uint)
+		if domain.UserID == userID && domain.OrganizationID == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You already own this domain"})
+			return
+		}
+
+		receiver, ok := currentUser(c, db)
+		if !ok {
+			return
+		}
+		if !can(c, db, "domain.create_any") && receiver.DomainQuota > 0 {
+			var owned int64
+			db.Model(&models.Domain{}).Where("user_id = ?", receiver.ID).Count(&owned)
+			if owned >= int64(receiver.DomainQuota) {
+				c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Domain quota reached (%d domains)", receiver.DomainQuota)})
+				return
+			}
+		}
+
+		transfer := models.DomainTransfer{
+			DomainID:           domain.ID,
+			DomainName:         domain.Name,
+			FromUserID:         domain.UserID,
+			FromOrganizationID: domain.OrganizationID,
+			ToUserID:           receiver.ID,
+			Status:             "pending",
+			UpdateContacts:     input.UpdateContacts,
+			ExpiresAt:          time.Now().Add(transferWindow(db)),
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// Using up the code also keeps two requests from racing
+			used := tx.Model(&models.Domain{}).Where("id = ? AND auth_code_hash = ?", domain.ID, domain.AuthCodeHash).
+				Updates(map[string]interface{}{"auth_code_hash": "", "auth_code_expires_at": nil})
+			if used.Error != nil {
+				return used.Error
+			}
+			if used.RowsAffected == 0 {
+				return fmt.Errorf("auth code has already been used")
+			}
+			if transferPending(tx, domain.ID) {
+				return fmt.Errorf("a transfer of this domain is already pending")
+			}
+			if err := tx.Create(&transfer).Error; err != nil {
+				return err
+			}
+			return recordHistory(tx, domain.ID, "update", receiver.ID, "transfer to "+receiver.Username+" requested", nil, nil)
+		})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to request transfer: " + err.Error()})
+			return
+		}
+
+		audit(c, "transfer.request", "domain", domain.ID, nil, transfer)
+		c.JSON(http.StatusCreated, transfer)
+	}
+}
+
+// ListTransfers returns the transfers the caller is part of: requested by
+// them or of domains they can transfer. Users with domain.transfer through
+// their role see every transfer. Filter with ?status=.
+func ListTransfers(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requireScope(c, "domains:read", "") {
+			return
+		}
+
+		query := db.Model(&models.DomainTransfer{})
+		if !can(c, db, "domain.transfer") {
+			userID := c.MustGet("user_id").(uint)
+			var memberships []models.OrganizationMember
+			db.Where("user_id = ? AND role = ?", userID, "owner").Find(&memberships)
+			orgIDs := []uint{}
+			for _, m := range memberships {
+				orgIDs = append(orgIDs, m.OrganizationID)
+			}
+			query = query.Where("to_user_id = ? OR (from_user_id = ? AND from_organization_id IS NULL) OR from_organization_id IN ?",
+				userID, userID, orgIDs)
+		}
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+
+		transfers := []models.DomainTransfer{}
+		if err := query.Order("id DESC").Find(&transfers).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, transfers)
+	}
+}
+
+// loadTransfer loads a pending transfer and checks that the caller holds
+// domain.transfer on its domain
+func loadTransfer(c *gin.Context, db *gorm.DB) (models.DomainTransfer, models.Domain, bool) {
+	var transfer models.DomainTransfer
+	if err := db.First(&transfer, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return transfer, models.Domain{}, false
+	}
+	domain, ok := loadDomain(c, db, transfer.DomainID, "domain.transfer", "domains:write")
+	if !ok {
+		return transfer, domain, false
+	}
+	if transfer.Status != "pending" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Transfer is already " + transfer.Status})
+		return transfer, domain, false
+	}
+	return transfer, domain, true
+}
+
+// ApproveTransfer lets the owner hand the domain over before the window ends
+func ApproveTransfer(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		transfer, domain, ok := loadTransfer(c, db)
+		if !ok {
+			return
+		}
+		if !requireUnlocked(c, domain, "transfer") {
+			return
+		}
+
+		err := completeTransfer(db, &transfer, "approved", actorID(c))
+		var blocked transferBlocked
+		if errors.As(err, &blocked) || errors.Is(err, errTransferClosed) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to approve transfer: " + err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve transfer: " + err.Error()})
+			return
+		}
+		audit(c, "transfer.approved", "domain", transfer.DomainID, gin.H{"user_id": transfer.FromUserID}, gin.H{"user_id": transfer.ToUserID})
+		c.JSON(http.StatusOK, transfer)
+	}
+}
+
+// RejectTransfer lets the owner refuse a transfer. The requester needs a new
+// auth code to try again.
+func RejectTransfer(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		transfer, _, ok := loadTransfer(c, db)
+		if !ok {
+			return
+		}
+		var input struct {
+			Reason string `json:"reason"`
+		}
+		c.ShouldBindJSON(&input)
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := closeTransfer(tx, &transfer, "rejected", input.Reason, actorID(c)); err != nil {
+				return err
+			}
+			return recordHistory(tx, transfer.DomainID, "update", actorID(c), "transfer rejected", nil, nil)
+		})
+		if errors.Is(err, errTransferClosed) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to reject transfer: " + err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject transfer: " + err.Error()})
+			return
+		}
+		audit(c, "transfer.rejected", "domain", transfer.DomainID, nil, transfer)
+		c.JSON(http.StatusOK, transfer)
+	}
+}
+
+// CancelTransfer withdraws a pending transfer; only the requesting user
+// may cancel it
+func CancelTransfer(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var transfer models.DomainTransfer
+		if err := db.First(&transfer, c.Param("id")).Error; err != nil || transfer.ToUserID != actorID(c) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+			return
+		}
+		if !requireScope(c, "domains:write", transfer.DomainName) {
+			return
+		}
+
+		if err := closeTransfer(db, &transfer, "cancelled", "", actorID(c)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to cancel transfer: " + err.Error()})
+			return
+		}
+		audit(c, "transfer.cancelled", "domain", transfer.DomainID, nil, transfer)
+		c.JSON(http.StatusOK, transfer)
+	}
+}
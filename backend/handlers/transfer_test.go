@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/localdns/backend/models"
+)
+
+func TestCompleteTransferClearsWhatThePreviousOwnerSetUp(t *testing.T) {
+	db := newTestDB(t)
+	alice := models.User{Username: "alice", Role: "user"}
+	bob := models.User{Username: "bob", Role: "user"}
+	carol := models.User{Username: "carol", Role: "user"}
+	for _, u := range []*models.User{&alice, &bob, &carol} {
+		db.Create(u)
+	}
+	domain := models.Domain{Name: "corp.lan", UserID: alice.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0)}
+	db.Create(&domain)
+	kept := models.Record{DomainID: domain.ID, Name: "www", Type: "A", Content: "192.0.2.1", TTL: 360}
+	db.Create(&kept)
+	db.Create(&models.Record{DomainID: domain.ID, Name: "ci", Type: "NS", Content: "ns1.carol.lan.", TTL: 360})
+	db.Create(&models.Delegation{DomainID: domain.ID, Name: "ci", UserID: &carol.ID, Nameservers: models.StringList{"ns1.carol.lan."}})
+	db.Create(&models.Grant{UserID: carol.ID, DomainID: domain.ID, Name: "corp.lan", Permissions: models.StringList{"record.write"}})
+	schedule := models.ScheduledChange{DomainID: domain.ID, CreatedBy: alice.ID, RunAt: time.Now().Add(time.Hour), Status: "pending", Changes: "[]"}
+	db.Create(&schedule)
+
+	transfer := models.DomainTransfer{DomainID: domain.ID, DomainName: domain.Name, FromUserID: alice.ID, ToUserID: bob.ID,
+		Status: "pending", ExpiresAt: time.Now().Add(time.Hour)}
+	db.Create(&transfer)
+	if err := completeTransfer(db, &transfer, "approved", alice.ID); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+
+	db.First(&domain, domain.ID)
+	if domain.UserID != bob.ID {
+		t.Errorf("domain still belongs to user %d", domain.UserID)
+	}
+	for name, model := range map[string]interface{}{"grants": &models.Grant{}, "delegations": &models.Delegation{}} {
+		var count int64
+		db.Model(model).Where("domain_id = ?", domain.ID).Count(&count)
+		if count != 0 {
+			t.Errorf("%d %s left on the domain", count, name)
+		}
+	}
+	var records []models.Record
+	db.Where("domain_id = ?", domain.ID).Find(&records)
+	if len(records) != 1 || records[0].ID != kept.ID {
+		t.Errorf("expected only the A record to remain, got %+v", records)
+	}
+	db.First(&schedule, schedule.ID)
+	if schedule.Status != "cancelled" {
+		t.Errorf("scheduled change is %s", schedule.Status)
+	}
+}
+
+func TestApplyTransfersCancelsTransfersThatCanNoLongerGoThrough(t *testing.T) {
+	db := newTestDB(t)
+	alice := models.User{Username: "alice", Role: "user"}
+	bob := models.User{Username: "bob", Role: "user", DomainQuota: 1}
+	db.Create(&alice)
+	db.Create(&bob)
+	db.Create(&models.Domain{Name: "bob.lan", UserID: bob.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0)})
+	full := models.Domain{Name: "full.lan", UserID: alice.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0)}
+	locked := models.Domain{Name: "locked.lan", UserID: alice.ID, Status: "active", ExpiresAt: time.Now().AddDate(1, 0, 0),
+		EPPStatuses: models.StringList{"clientTransferProhibited"}}
+	db.Create(&full)
+	db.Create(&locked)
+
+	transfers := map[string]*models.DomainTransfer{}
+	for _, domain := range []models.Domain{full, locked} {
+		transfer := models.DomainTransfer{DomainID: domain.ID, DomainName: domain.Name, FromUserID: alice.ID, ToUserID: bob.ID,
+			Status: "pending", ExpiresAt: time.Now().Add(-time.Minute)}
+		db.Create(&transfer)
+		transfers[domain.Name] = &transfer
+	}
+	applyTransfers(db, time.Now())
+
+	for name, transfer := range transfers {
+		var stored models.DomainTransfer
+		db.First(&stored, transfer.ID)
+		if stored.Status != "cancelled" {
+			t.Errorf("transfer of %s is %s", name, stored.Status)
+		}
+		var domain models.Domain
+		db.First(&domain, transfer.DomainID)
+		if domain.UserID != alice.ID {
+			t.Errorf("%s moved to user %d", name, domain.UserID)
+		}
+	}
+}
@@ -23,7 +23,7 @@ func WhoisQuery(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		domainName = strings.TrimSpace(strings.ToLower(domainName))
+		domainName = lookupName(domainName) // U-labels are looked up by their A-label form
 
 		var domain models.Domain
 		if result := db.Where("name = ?", domainName).First(&domain); result.Error != nil {
@@ -37,8 +37,12 @@ func WhoisQuery(db *gorm.DB) gin.HandlerFunc {
 
 		var config models.RegistrarConfig
 		db.First(&config)
+		if config.RedactWhois {
+			redactContacts(&domain)
+			user = models.User{}
+		}
 
-		c.String(http.StatusOK, formatWhoisResponse(domain, user, config))
+		c.String(http.StatusOK, formatWhoisResponse(domain, user, config, eppStatusCodes(domain, transferPending(db, domain.ID))))
 	}
 }
 
@@ -46,7 +50,7 @@ func WhoisQuery(db *gorm.DB) gin.HandlerFunc {
 func WhoisRaw(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		domainName := c.Param("domain")
-		domainName = strings.TrimSpace(strings.ToLower(domainName))
+		domainName = lookupName(domainName) // U-labels are looked up by their A-label form
 
 		var domain models.Domain
 		if result := db.Where("name = ?", domainName).First(&domain); result.Error != nil {
@@ -60,13 +64,77 @@ func WhoisRaw(db *gorm.DB) gin.HandlerFunc {
 
 		var config models.RegistrarConfig
 		db.First(&config)
+		if config.RedactWhois {
+			redactContacts(&domain)
+			user = models.User{}
+		}
+
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.String(http.StatusOK, formatWhoisResponse(domain, user, config, eppStatusCodes(domain, transferPending(db, domain.ID))))
+	}
+}
+
+// DomainWhois returns the WHOIS text of a domain for a signed-in caller.
+// Contact details are redacted unless the caller holds whois.view_private
+// on the domain, whatever the public redaction setting.
+func DomainWhois(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		domain, ok := loadDomain(c, db, c.Param("id"), "domain.read", "domains:read")
+		if !ok {
+			return
+		}
+
+		var user models.User
+		db.First(&user, domain.UserID)
+
+		var config models.RegistrarConfig
+		db.First(&config)
+		if !permitted(c, db, domain, domain.Name, "whois.view_private") {
+			redactContacts(&domain)
+			user = models.User{}
+		}
 
 		c.Header("Content-Type", "text/plain; charset=utf-8")
-		c.String(http.StatusOK, formatWhoisResponse(domain, user, config))
+		c.String(http.StatusOK, formatWhoisResponse(domain, user, config, eppStatusCodes(domain, transferPending(db, domain.ID))))
+	}
+}
+
+// redactContacts clears the registrant, admin and tech contacts of a domain
+// and its loaded owner; WHOIS output then shows them as REDACTED FOR PRIVACY
+func redactContacts(domain *models.Domain) {
+	for _, field := range []*string{
+		&domain.RegistrantName, &domain.RegistrantOrg, &domain.RegistrantEmail, &domain.RegistrantPhone,
+		&domain.RegistrantAddress, &domain.RegistrantCity, &domain.RegistrantState, &domain.RegistrantZip, &domain.RegistrantCountry,
+		&domain.AdminName, &domain.AdminOrg, &domain.AdminEmail, &domain.AdminPhone,
+		&domain.AdminAddress, &domain.AdminCity, &domain.AdminState, &domain.AdminZip, &domain.AdminCountry,
+		&domain.TechName, &domain.TechOrg, &domain.TechEmail, &domain.TechPhone,
+		&domain.TechAddress, &domain.TechCity, &domain.TechState, &domain.TechZip, &domain.TechCountry,
+		&domain.User.ContactName, &domain.User.ContactOrg, &domain.User.ContactEmail, &domain.User.ContactPhone,
+		&domain.User.ContactAddress, &domain.User.ContactCity, &domain.User.ContactState, &domain.User.ContactZip, &domain.User.ContactCountry,
+	} {
+		*field = ""
+	}
+}
+
+// internationalizedNameLine shows the U-label form of an IDN next to the
+// A-label "Domain Name"; it is empty for ASCII domains
+func internationalizedNameLine(name string) string {
+	if unicodeName := toUnicodeName(name); unicodeName != name {
+		return "Internationalized Domain Name: " + unicodeName + "\n"
 	}
+	return ""
 }
 
-func formatWhoisResponse(domain models.Domain, user models.User, config models.RegistrarConfig) string {
+// domainStatusLines prints one Domain Status line per EPP status code
+func domainStatusLines(codes []string) string {
+	var b strings.Builder
+	for _, code := range codes {
+		fmt.Fprintf(&b, "Domain Status: %s https://icann.org/epp#%s\n", code, code)
+	}
+	return b.String()
+}
+
+func formatWhoisResponse(domain models.Domain, user models.User, config models.RegistrarConfig, statuses []string) string {
 	// Calculate expiry: 1 year after last update
 	expiryDate := domain.ExpiresAt
 	if expiryDate.IsZero() {
@@ -111,7 +179,7 @@ func formatWhoisResponse(domain models.Domain, user models.User, config models.R
 
 
 	return fmt.Sprintf(`Domain Name: %s
-Registry Domain ID: DOM-%d-LOCALDNS
+%sRegistry Domain ID: DOM-%d-LOCALDNS
 Registrar WHOIS Server: %s
 Registrar URL: %s
 Updated Date: %s
@@ -121,8 +189,7 @@ Registrar: %s
 Registrar IANA ID: %s
 Registrar Abuse Contact Email: %s
 Registrar Abuse Contact Phone: %s
-Domain Status: %s https://icann.org/epp#%s
-
+%s
 Registry Registrant ID: C%d-LOCALDNS
 Registrant Name: %s
 Registrant Organization: %s
@@ -174,6 +241,7 @@ For more information on WHOIS status codes, please visit https://icann.org/epp
 
 `,
 		strings.ToUpper(domain.Name),
+		internationalizedNameLine(domain.Name),
 		domain.ID,
 		config.WhoisServer,
 		config.RegistrarURL,
@@ -184,8 +252,7 @@ For more information on WHOIS status codes, please visit https://icann.org/epp
 		valueOrDefault(config.RegistrarIANAID, "9999"),
 		valueOrDefault(config.AbuseContactEmail, config.RegistrarEmail),
 		valueOrDefault(config.AbuseContactPhone, config.RegistrarPhone),
-		valueOrDefault(domain.Status, "active"),
-		valueOrDefault(domain.Status, "active"),
+		domainStatusLines(statuses),
 		// Registrant
 		domain.ID,
 		valueOrDefault(registrantName, "REDACTED FOR PRIVACY"),
@@ -252,6 +319,10 @@ func valueOrFallback(val, fallback string) string {
 // GetRegistrarConfig returns the registrar configuration
 func GetRegistrarConfig(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if !requireScope(c, "domains:read", "") {
+			return
+		}
+
 		var config models.RegistrarConfig
 		if result := db.First(&config); result.Error != nil {
 			// If not found, return default empty config (or seed it)
@@ -267,9 +338,7 @@ func GetRegistrarConfig(db *gorm.DB) gin.HandlerFunc {
 // UpdateRegistrarConfig updates the registrar configuration (admin only)
 func UpdateRegistrarConfig(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role := c.MustGet("role").(string)
-		if role != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		if !requireAdmin(c, db, "config.write", "whois:admin") {
 			return
 		}
 
@@ -292,11 +361,38 @@ func UpdateRegistrarConfig(db *gorm.DB) gin.HandlerFunc {
 			NameServer2       string `json:"nameserver2"`
 			DefaultTTL        int    `json:"default_ttl"`
 			DefaultExpiry     int    `json:"default_expiry_days"`
+			RequireAdmin2FA   *bool  `json:"require_admin_2fa"`
+			RegistrationMode  string `json:"registration_mode"`
+			RequireApproval   *bool  `json:"require_approval"`
+			RedactWhois       *bool  `json:"redact_whois"`
+
+			GracePeriod         *int `json:"grace_period_days"`
+			RedemptionPeriod    *int `json:"redemption_period_days"`
+			PendingDeletePeriod *int `json:"pending_delete_period_days"`
+			MaxRegistration     *int `json:"max_registration_days"`
+			TransferWindow      *int `json:"transfer_window_days"`
+
+			ReminderDays          *[]int    `json:"reminder_days"`
+			ReminderChannels      *[]string `json:"reminder_channels"`
+			ReminderWebhookURL    *string   `json:"reminder_webhook_url"`
+			ReminderWebhookSecret *string   `json:"reminder_webhook_secret"`
 		}
 		if err := c.ShouldBindJSON(&input); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		for _, period := range []*int{input.GracePeriod, input.RedemptionPeriod, input.PendingDeletePeriod, input.MaxRegistration, input.TransferWindow} {
+			if period != nil && *period < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Periods cannot be negative"})
+				return
+			}
+		}
+		if input.RegistrationMode != "" && !registrationModes[input.RegistrationMode] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "registration_mode must be 'open', 'invite' or 'closed'"})
+			return
+		}
+
+		before := config
 
 		// Update all fields directly
 		config.RegistrarName = input.RegistrarName
@@ -315,11 +411,58 @@ func UpdateRegistrarConfig(db *gorm.DB) gin.HandlerFunc {
 		if input.DefaultExpiry > 0 {
 			config.DefaultExpiry = input.DefaultExpiry
 		}
+		if input.RequireAdmin2FA != nil {
+			config.RequireAdmin2FA = *input.RequireAdmin2FA
+		}
+		if input.RegistrationMode != "" {
+			config.RegistrationMode = input.RegistrationMode
+		}
+		if input.RequireApproval != nil {
+			config.RequireApproval = *input.RequireApproval
+		}
+		if input.RedactWhois != nil {
+			config.RedactWhois = *input.RedactWhois
+		}
+		if input.GracePeriod != nil {
+			config.GracePeriod = *input.GracePeriod
+		}
+		if input.RedemptionPeriod != nil {
+			config.RedemptionPeriod = *input.RedemptionPeriod
+		}
+		if input.PendingDeletePeriod != nil {
+			config.PendingDeletePeriod = *input.PendingDeletePeriod
+		}
+		if input.MaxRegistration != nil {
+			config.MaxRegistration = *input.MaxRegistration
+		}
+		if input.TransferWindow != nil {
+			config.TransferWindow = *input.TransferWindow
+		}
+		if input.ReminderDays != nil {
+			config.ReminderDays = models.StringList{}
+			for _, days := range *input.ReminderDays {
+				config.ReminderDays = append(config.ReminderDays, fmt.Sprint(days))
+			}
+		}
+		if input.ReminderChannels != nil {
+			config.ReminderChannels = *input.ReminderChannels
+		}
+		if input.ReminderWebhookURL != nil {
+			config.ReminderWebhookURL = strings.TrimSpace(*input.ReminderWebhookURL)
+		}
+		if input.ReminderWebhookSecret != nil {
+			config.ReminderWebhookSecret = *input.ReminderWebhookSecret
+		}
+		if err := validateReminderSettings(config); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
 		if err := db.Save(&config).Error; err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save config: " + err.Error()})
             return
         }
+		audit(c, "config.update", "config", config.ID, before, config)
 		c.JSON(http.StatusOK, config)
 	}
 }
@@ -327,18 +470,13 @@ func UpdateRegistrarConfig(db *gorm.DB) gin.HandlerFunc {
 // UpdateDomainRegistrant updates registrant info for a domain
 func UpdateDomainRegistrant(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.MustGet("user_id").(uint)
-		role := c.MustGet("role").(string)
 		domainID := c.Param("id")
 
-		var domain models.Domain
-		if result := db.First(&domain, domainID); result.Error != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		domain, ok := loadDomain(c, db, domainID, "domain.write", "domains:write")
+		if !ok {
 			return
 		}
-
-		if role != "admin" && domain.UserID != userID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		if !requireUnlocked(c, domain, "update") {
 			return
 		}
 
@@ -421,20 +559,31 @@ func UpdateDomainRegistrant(db *gorm.DB) gin.HandlerFunc {
 // GetDomain returns a single domain with all details
 func GetDomain(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.MustGet("user_id").(uint)
-		role := c.MustGet("role").(string)
 		domainID := c.Param("id")
 
 		var domain models.Domain
-		if result := db.Preload("User").Preload("Records").First(&domain, domainID); result.Error != nil {
+		if result := db.Preload("User").Preload("Organization").Preload("Records.Tags").First(&domain, domainID); result.Error != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
 			return
 		}
 
-		if role != "admin" && domain.UserID != userID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		if !authorizeDomain(c, db, domain, "domain.read", "domains:read") {
 			return
 		}
+		if !permitted(c, db, domain, domain.Name, "whois.view_private") {
+			redactContacts(&domain)
+		}
+
+		// Callers with a subdomain grant only see the records below it
+		access := recordAccess(c, db, domain, "record.read")
+		visible := make([]models.Record, 0, len(domain.Records))
+		for _, record := range domain.Records {
+			if access(record.Name) {
+				visible = append(visible, record)
+			}
+		}
+		withUnicodeNames(visible)
+		domain.Records = visible
 
 		c.JSON(http.StatusOK, domain)
 	}
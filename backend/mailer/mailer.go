@@ -0,0 +1,160 @@
+// Package mailer sends transactional email such as address verification and
+// password reset messages.
+package mailer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// DefaultTimeout bounds a whole SMTP exchange when SMTPMailer.Timeout is unset
+const DefaultTimeout = 30 * time.Second
+
+// SMTPMailer delivers mail through an SMTP server. STARTTLS is used when the
+// server offers it; set TLS for servers that expect TLS from the first byte.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	TLS      bool
+	Timeout  time.Duration // for connecting and for the whole exchange
+}
+
+// sanitizeHeader prevents header injection through user-controlled values
+func sanitizeHeader(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+func (m SMTPMailer) build(msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", sanitizeHeader(m.From))
+	fmt.Fprintf(&b, "To: %s\r\n", sanitizeHeader(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", sanitizeHeader(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// Send delivers msg
+func (m SMTPMailer) Send(msg Message) error {
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	addr := net.JoinHostPort(m.Host, m.Port)
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if m.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: m.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	// A server that stops answering fails the send instead of blocking it
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if !m.TLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: m.Host}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if m.Username != "" {
+		auth := smtp.PlainAuth("", m.Username, m.Password, m.Host)
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(m.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(m.build(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// LogMailer writes messages to the log instead of sending them. It is used
+// when no SMTP server is configured.
+type LogMailer struct{}
+
+// Send logs msg
+func (LogMailer) Send(msg Message) error {
+	log.Printf("mailer: no SMTP_HOST configured, not sending mail to %s\nSubject: %s\n\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FromEnv builds a Mailer from SMTP_HOST, SMTP_PORT, SMTP_USERNAME,
+// SMTP_PASSWORD, SMTP_FROM, SMTP_TLS and SMTP_TIMEOUT
+func FromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return LogMailer{}
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "25"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "noreply@localdns.local"
+	}
+	timeout, err := time.ParseDuration(os.Getenv("SMTP_TIMEOUT"))
+	if err != nil {
+		timeout = DefaultTimeout
+	}
+	return SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+		TLS:      os.Getenv("SMTP_TLS") == "true",
+		Timeout:  timeout,
+	}
+}
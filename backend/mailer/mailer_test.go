@@ -0,0 +1,178 @@
+package mailer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// received is one message accepted by the SMTP sink
+type received struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// smtpSink is a minimal SMTP server that accepts every message. It offers
+// AUTH PLAIN, which net/smtp only uses over plain text towards localhost.
+type smtpSink struct {
+	listener net.Listener
+	messages chan received
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &smtpSink{listener: l, messages: make(chan received, 10)}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 sink ESMTP")
+	var msg received
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-sink")
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			_, credentials, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(credentials)
+			msg.auth = string(decoded)
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			msg.from = strings.TrimPrefix(arg, "FROM:")
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			msg.to = append(msg.to, strings.TrimPrefix(arg, "TO:"))
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			r := bufio.NewReader(tp.DotReader())
+			var b strings.Builder
+			if _, err := r.WriteTo(&b); err != nil {
+				return
+			}
+			msg.data = b.String()
+			s.messages <- msg
+			msg = received{}
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (s *smtpSink) mailer() SMTPMailer {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return SMTPMailer{Host: host, Port: port, From: "noreply@localdns.lan"}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	sink := newSMTPSink(t)
+	m := sink.mailer()
+	m.Username = "relay"
+	m.Password = "secret"
+
+	err := m.Send(Message{To: "alice@localdns.lan", Subject: "Hello", Body: "line one\nline two\n"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	msg := <-sink.messages
+	if msg.auth != "\x00relay\x00secret" {
+		t.Errorf("unexpected credentials %q", msg.auth)
+	}
+	if msg.from != "<noreply@localdns.lan>" || len(msg.to) != 1 || msg.to[0] != "<alice@localdns.lan>" {
+		t.Errorf("unexpected envelope: from %s to %v", msg.from, msg.to)
+	}
+	for _, want := range []string{
+		"From: noreply@localdns.lan\n",
+		"To: alice@localdns.lan\n",
+		"Subject: Hello\n",
+		"Content-Type: text/plain; charset=UTF-8\n\nline one\nline two\n",
+	} {
+		if !strings.Contains(msg.data, want) {
+			t.Errorf("message is missing %q:\n%s", want, msg.data)
+		}
+	}
+}
+
+func TestSMTPMailerSanitizesHeaders(t *testing.T) {
+	sink := newSMTPSink(t)
+	m := sink.mailer()
+
+	err := m.Send(Message{To: "alice@localdns.lan", Subject: "Hi\r\nBcc: mallory@evil.test", Body: "body"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	msg := <-sink.messages
+	if strings.Contains(msg.data, "\nBcc:") || !strings.Contains(msg.data, "Subject: Hi  Bcc: mallory@evil.test\n") {
+		t.Errorf("header injection was not neutralised:\n%s", msg.data)
+	}
+}
+
+func TestSMTPMailerTimesOut(t *testing.T) {
+	// A server that accepts the connection but never greets
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close() // held open, silent, until the test ends
+		}
+	}()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	m := SMTPMailer{Host: host, Port: port, From: "noreply@localdns.lan", Timeout: 200 * time.Millisecond}
+
+	start := time.Now()
+	if err := m.Send(Message{To: "alice@localdns.lan", Subject: "Hello", Body: "body"}); err == nil {
+		t.Error("expected an error from a stalled server")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("send blocked for %v", elapsed)
+	}
+}
+
+func TestSMTPMailerUnreachable(t *testing.T) {
+	sink := newSMTPSink(t)
+	m := sink.mailer()
+	sink.listener.Close()
+
+	if err := m.Send(Message{To: "alice@localdns.lan", Subject: "Hello", Body: "body"}); err == nil {
+		t.Error("expected an error when the server is down")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/localdns/backend/handlers"
@@ -43,9 +45,54 @@ func main() {
     if err := db.AutoMigrate(&models.RegistrarConfig{}); err != nil {
          log.Printf("Failed to auto-migrate RegistrarConfig: %v", err)
     }
-    if err := db.AutoMigrate(&models.Domain{}, &models.Record{}); err != nil {
+    if err := db.AutoMigrate(&models.Domain{}, &models.Record{}, &models.RecordTag{}); err != nil {
          log.Printf("Failed to auto-migrate Domain/Record: %v", err)
     }
+    if err := db.AutoMigrate(&models.DomainHistory{}); err != nil {
+         log.Printf("Failed to auto-migrate DomainHistory: %v", err)
+    }
+    if err := db.AutoMigrate(&models.AuditLog{}); err != nil {
+         log.Printf("Failed to auto-migrate AuditLog: %v", err)
+    }
+    if err := db.AutoMigrate(&models.Session{}); err != nil {
+         log.Printf("Failed to auto-migrate Session: %v", err)
+    }
+    if err := db.AutoMigrate(&models.APIKey{}); err != nil {
+         log.Printf("Failed to auto-migrate APIKey: %v", err)
+    }
+    if err := db.AutoMigrate(&models.RecoveryCode{}); err != nil {
+         log.Printf("Failed to auto-migrate RecoveryCode: %v", err)
+    }
+    if err := db.AutoMigrate(&models.UserToken{}, &models.EmailTemplate{}); err != nil {
+         log.Printf("Failed to auto-migrate UserToken/EmailTemplate: %v", err)
+    }
+    if err := db.AutoMigrate(&models.Invitation{}); err != nil {
+         log.Printf("Failed to auto-migrate Invitation: %v", err)
+    }
+    if err := db.AutoMigrate(&models.Organization{}, &models.OrganizationMember{}); err != nil {
+         log.Printf("Failed to auto-migrate Organization/OrganizationMember: %v", err)
+    }
+    if err := db.AutoMigrate(&models.Role{}, &models.Grant{}); err != nil {
+         log.Printf("Failed to auto-migrate Role/Grant: %v", err)
+    }
+    if err := db.AutoMigrate(&models.Delegation{}); err != nil {
+         log.Printf("Failed to auto-migrate Delegation: %v", err)
+    }
+    if err := db.AutoMigrate(&models.TLD{}, &models.ReservedName{}); err != nil {
+         log.Printf("Failed to auto-migrate TLD/ReservedName: %v", err)
+    }
+    if err := db.AutoMigrate(&models.ScheduledChange{}); err != nil {
+         log.Printf("Failed to auto-migrate ScheduledChange: %v", err)
+    }
+    if err := db.AutoMigrate(&models.RecordTemplate{}, &models.TemplateRecord{}); err != nil {
+         log.Printf("Failed to auto-migrate RecordTemplate/TemplateRecord: %v", err)
+    }
+    if err := db.AutoMigrate(&models.ReminderDelivery{}); err != nil {
+         log.Printf("Failed to auto-migrate ReminderDelivery: %v", err)
+    }
+    if err := db.AutoMigrate(&models.DomainTransfer{}); err != nil {
+         log.Printf("Failed to auto-migrate DomainTransfer: %v", err)
+    }
     
     // User migration often fails on constraints, so we try soft migration then manual column headers
     if err := db.AutoMigrate(&models.User{}); err != nil {
@@ -63,6 +110,10 @@ func main() {
         if !m.HasColumn(&models.User{}, "ContactState") { m.AddColumn(&models.User{}, "ContactState") }
         if !m.HasColumn(&models.User{}, "ContactZip") { m.AddColumn(&models.User{}, "ContactZip") }
         if !m.HasColumn(&models.User{}, "ContactCountry") { m.AddColumn(&models.User{}, "ContactCountry") }
+        if !m.HasColumn(&models.User{}, "AuthSource") { m.AddColumn(&models.User{}, "AuthSource") }
+        if !m.HasColumn(&models.User{}, "ExternalID") { m.AddColumn(&models.User{}, "ExternalID") }
+        if !m.HasColumn(&models.User{}, "Status") { m.AddColumn(&models.User{}, "Status") }
+        if !m.HasColumn(&models.User{}, "DomainQuota") { m.AddColumn(&models.User{}, "DomainQuota") }
     }
 
     // Manual fallback for Domain columns if migration failed
@@ -105,6 +156,11 @@ func main() {
         if !m.HasColumn(&models.Domain{}, "Status") { m.AddColumn(&models.Domain{}, "Status") }
     }
 
+    // Domains registered before IDN support have no Unicode name yet
+    if err := handlers.BackfillUnicodeNames(db); err != nil {
+        log.Printf("Failed to backfill domain Unicode names: %v", err)
+    }
+
     // Seed Admin User
     var existingAdmin models.User
     if err := db.Where("username = ?", "admin").First(&existingAdmin).Error; err != nil {
@@ -124,6 +180,16 @@ func main() {
         }
     }
 
+    // Seed built-in roles; the admin role is kept holding every permission
+    if err := handlers.SeedRoles(db); err != nil {
+        log.Printf("Failed to seed roles: %v", err)
+    }
+
+    // Seed the default TLDs on first start
+    if err := handlers.SeedTLDs(db); err != nil {
+        log.Printf("Failed to seed TLDs: %v", err)
+    }
+
     // Seed default Registrar Config
     var existingConfig models.RegistrarConfig
     if err := db.First(&existingConfig).Error; err != nil {
@@ -148,11 +214,34 @@ func main() {
     }
 
 
+	// Background scheduler for staged record changes and record validity windows
+	go handlers.RunScheduler(db, 30*time.Second)
+
 	r := gin.Default()
 
+	// Restrict X-Forwarded-For to known proxies so per-IP login throttling
+	// cannot be bypassed by spoofing the header
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		if err := r.SetTrustedProxies(strings.Split(proxies, ",")); err != nil {
+			log.Printf("Invalid TRUSTED_PROXIES: %v", err)
+		}
+	}
+
 	// Public
+	r.GET("/api/register", handlers.RegistrationPolicy(db))
 	r.POST("/api/register", handlers.Register(db))
 	r.POST("/api/login", handlers.Login(db))
+	r.POST("/api/login/mfa", handlers.LoginMFA(db))
+	r.POST("/api/login/mfa/setup", handlers.LoginMFASetup(db))
+	r.POST("/api/token/refresh", handlers.RefreshToken(db))
+	r.POST("/api/verify-email", handlers.VerifyEmail(db))
+	r.POST("/api/password-reset", handlers.RequestPasswordReset(db))
+	r.POST("/api/password-reset/confirm", handlers.ResetPassword(db))
+
+	// OpenID Connect single sign-on
+	r.GET("/api/auth/oidc", handlers.OIDCConfig())
+	r.GET("/api/auth/oidc/login", handlers.OIDCLogin())
+	r.GET("/api/auth/oidc/callback", handlers.OIDCCallback(db))
 	
 	// Public WHOIS endpoint (no auth required)
 	r.GET("/whois/:domain", handlers.WhoisRaw(db))
@@ -160,30 +249,139 @@ func main() {
 
 	// Protected (TODO: Add Auth Middleware)
 	api := r.Group("/api")
-    api.Use(handlers.AuthMiddleware())
+    api.Use(handlers.AuthMiddleware(db), handlers.AuditMiddleware(db))
 	{
+		// Session
+		api.POST("/logout", handlers.Logout(db))
+
+		// Current user's account
+		api.GET("/me", handlers.GetMe(db))
+		api.PUT("/me", handlers.UpdateMe(db))
+		api.POST("/me/password", handlers.ChangePassword(db))
+		api.POST("/me/verify-email", handlers.ResendVerificationEmail(db))
+
+		// Two-factor authentication for the current user
+		api.POST("/me/2fa/setup", handlers.SetupTwoFactor(db))
+		api.POST("/me/2fa/verify", handlers.VerifyTwoFactor(db))
+		api.POST("/me/2fa/disable", handlers.DisableTwoFactor(db))
+		api.POST("/me/2fa/recovery-codes", handlers.RegenerateRecoveryCodes(db))
+
+		// Personal API keys
+		api.GET("/api-keys", handlers.ListAPIKeys(db))
+		api.POST("/api-keys", handlers.CreateAPIKey(db))
+		api.DELETE("/api-keys/:keyId", handlers.DeleteAPIKey(db))
+
+
 		// Domains
 		api.GET("/domains", handlers.ListDomains(db))
 		api.POST("/domains", handlers.CreateDomain(db))
 		api.GET("/domains/:id", handlers.GetDomain(db))
 		api.DELETE("/domains/:id", handlers.DeleteDomain(db))
 		api.PUT("/domains/:id/registrant", handlers.UpdateDomainRegistrant(db))
+		api.PUT("/domains/:id/owner", handlers.SetDomainOwner(db))
+		api.GET("/domains/:id/whois", handlers.DomainWhois(db))
+		api.POST("/domains/:id/restore", handlers.RestoreDomain(db))
+		api.POST("/domains/:id/renew", handlers.RenewDomain(db))
+		api.PUT("/domains/:id/auto-renew", handlers.SetAutoRenew(db))
+		api.PUT("/domains/:id/epp-status", handlers.SetEPPStatuses(db))
+
+		// Transfers between users with auth codes
+		api.POST("/domains/:id/auth-code", handlers.CreateAuthCode(db))
+		api.GET("/transfers", handlers.ListTransfers(db))
+		api.POST("/transfers", handlers.RequestTransfer(db))
+		api.POST("/transfers/:id/approve", handlers.ApproveTransfer(db))
+		api.POST("/transfers/:id/reject", handlers.RejectTransfer(db))
+		api.POST("/transfers/:id/cancel", handlers.CancelTransfer(db))
+
+		// Subdomain delegations
+		api.GET("/domains/:id/delegations", handlers.ListDelegations(db))
+		api.POST("/domains/:id/delegations", handlers.CreateDelegation(db))
+		api.DELETE("/delegations/:delegationId", handlers.DeleteDelegation(db))
+		api.GET("/domains/:id/history", handlers.ListDomainHistory(db))
+		api.POST("/domains/:id/rollback", handlers.RollbackDomain(db))
 		
 		// Records
 		api.GET("/domains/:id/records", handlers.ListRecords(db))
 		api.POST("/domains/:id/records", handlers.AddRecord(db))
 		api.PUT("/records/:recordId", handlers.UpdateRecord(db))
 		api.DELETE("/records/:recordId", handlers.DeleteRecord(db))
+		api.GET("/records/search", handlers.SearchRecords(db))
+
+		// Scheduled record changes
+		api.GET("/domains/:id/schedules", handlers.ListScheduledChanges(db))
+		api.POST("/domains/:id/schedules", handlers.CreateScheduledChange(db))
+		api.DELETE("/schedules/:scheduleId", handlers.CancelScheduledChange(db))
 		
+		// Record templates (admin manages, owners apply)
+		api.GET("/templates", handlers.ListTemplates(db))
+		api.GET("/templates/:templateId", handlers.GetTemplate(db))
+		api.POST("/templates", handlers.CreateTemplate(db))
+		api.PUT("/templates/:templateId", handlers.UpdateTemplate(db))
+		api.DELETE("/templates/:templateId", handlers.DeleteTemplate(db))
+		api.POST("/domains/:id/templates/:templateId/preview", handlers.PreviewTemplate(db))
+		api.POST("/domains/:id/templates/:templateId/apply", handlers.ApplyTemplate(db))
+
 		// Users (admin only)
 		api.GET("/users", handlers.ListUsers(db))
 		api.POST("/users", handlers.CreateUser(db))
 		api.PUT("/users/:id", handlers.UpdateUser(db))
 		api.DELETE("/users/:id", handlers.DeleteUser(db))
+		api.GET("/users/:id/sessions", handlers.ListUserSessions(db))
+		api.POST("/users/:id/sessions/revoke", handlers.RevokeUserSessions(db))
+		api.DELETE("/users/:id/2fa", handlers.ResetUserTwoFactor(db))
+		api.POST("/users/:id/unlock", handlers.UnlockUser(db))
+		api.POST("/users/:id/approve", handlers.ApproveUser(db))
+		api.POST("/users/:id/reject", handlers.RejectUser(db))
+		api.POST("/users/:id/impersonate", handlers.Impersonate(db))
+
+		// Organizations (shared domain ownership)
+		api.GET("/organizations", handlers.ListOrganizations(db))
+		api.POST("/organizations", handlers.CreateOrganization(db))
+		api.GET("/organizations/:id", handlers.GetOrganization(db))
+		api.PUT("/organizations/:id", handlers.UpdateOrganization(db))
+		api.DELETE("/organizations/:id", handlers.DeleteOrganization(db))
+		api.POST("/organizations/:id/members", handlers.AddOrganizationMember(db))
+		api.PUT("/organizations/:id/members/:userId", handlers.UpdateOrganizationMember(db))
+		api.DELETE("/organizations/:id/members/:userId", handlers.RemoveOrganizationMember(db))
+
+		// Invitations (admin only)
+		api.GET("/invitations", handlers.ListInvitations(db))
+		api.POST("/invitations", handlers.CreateInvitation(db))
+		api.DELETE("/invitations/:id", handlers.RevokeInvitation(db))
+
+		// Roles, permissions and per-domain grants
+		api.GET("/permissions", handlers.ListPermissions())
+		api.GET("/roles", handlers.ListRoles(db))
+		api.POST("/roles", handlers.CreateRole(db))
+		api.PUT("/roles/:id", handlers.UpdateRole(db))
+		api.DELETE("/roles/:id", handlers.DeleteRole(db))
+		api.GET("/grants", handlers.ListGrants(db))
+		api.POST("/grants", handlers.CreateGrant(db))
+		api.DELETE("/grants/:id", handlers.DeleteGrant(db))
+
+		// TLD policy (list is open to all users, changes need config.write)
+		api.GET("/tlds", handlers.ListTLDs(db))
+		api.POST("/tlds", handlers.CreateTLD(db))
+		api.PUT("/tlds/:id", handlers.UpdateTLD(db))
+		api.DELETE("/tlds/:id", handlers.DeleteTLD(db))
+		api.GET("/reserved-names", handlers.ListReservedNames(db))
+		api.POST("/reserved-names", handlers.CreateReservedName(db))
+		api.DELETE("/reserved-names/:id", handlers.DeleteReservedName(db))
 		
 		// Registrar Config (admin only for update)
 		api.GET("/config", handlers.GetRegistrarConfig(db))
 		api.PUT("/config", handlers.UpdateRegistrarConfig(db))
+
+		// Email templates (admin only)
+		api.GET("/email-templates", handlers.ListEmailTemplates(db))
+		api.PUT("/email-templates/:name", handlers.UpdateEmailTemplate(db))
+		api.DELETE("/email-templates/:name", handlers.ResetEmailTemplate(db))
+
+		// Expiry reminder delivery log (admin only)
+		api.GET("/reminders", handlers.ListReminderDeliveries(db))
+
+		// Audit log (admin only)
+		api.GET("/audit", handlers.ListAuditLogs(db))
 	}
 
 
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// APIKey is a personal access key for automation. Only a SHA-256 hash of the
+// key is stored; the key itself is shown once when it is created.
+type APIKey struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	UserID       uint       `gorm:"not null;index" json:"user_id"`
+	Name         string     `gorm:"not null" json:"name"`
+	Prefix       string     `gorm:"not null" json:"prefix"` // First characters of the key, for identification
+	KeyHash      string     `gorm:"uniqueIndex;not null" json:"-"`
+	Scopes       StringList `json:"scopes"`        // e.g. records:write:corp.lan, domains:read
+	AllowedCIDRs StringList `json:"allowed_cidrs"` // Empty allows any source address
+	ExpiresAt    *time.Time `json:"expires_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrAuditLogImmutable is returned when something tries to modify the audit log
+var ErrAuditLogImmutable = errors.New("audit log is append-only")
+
+// AuditLog records administrative and security-relevant actions.
+// Rows are only ever inserted; updates and deletes are rejected.
+type AuditLog struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+	ActorID    uint      `gorm:"index" json:"actor_id"`        // 0 for system or anonymous
+	Action     string    `gorm:"not null;index" json:"action"` // e.g. user.create, config.update
+	TargetType string    `gorm:"index" json:"target_type"`     // user, domain, config
+	TargetID   string    `gorm:"default:''" json:"target_id"`
+	IP         string    `gorm:"default:''" json:"ip"`
+	Diff       JSONText  `gorm:"type:text" json:"diff"` // {"field": {"old": ..., "new": ...}}
+
+	// Set when an admin acted as ActorID through impersonation
+	ImpersonatorID uint `gorm:"index;default:0" json:"impersonator_id"`
+}
+
+func (AuditLog) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditLogImmutable
+}
+
+func (AuditLog) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditLogImmutable
+}
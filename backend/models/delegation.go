@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Delegation hands the records at and below Name, a subdomain of the zone,
+// to another user or to an organization's members. With Nameservers set the
+// subdomain is also delegated in DNS through NS records in the parent zone.
+type Delegation struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	DomainID       uint       `gorm:"not null;uniqueIndex:idx_delegation_name" json:"domain_id"`
+	Name           string     `gorm:"not null;uniqueIndex:idx_delegation_name" json:"name"`
+	UserID         *uint      `gorm:"index" json:"user_id"`
+	OrganizationID *uint      `gorm:"index" json:"organization_id"`
+	Nameservers    StringList `json:"nameservers"`
+	CreatedBy      uint       `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
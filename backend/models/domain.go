@@ -9,6 +9,16 @@ type Domain struct {
 	Name      string    `gorm:"uniqueIndex;not null" json:"name"`
 	UserID    uint      `gorm:"not null" json:"user_id"`
 	User      User      `json:"user,omitempty"` // Association
+
+	// Name is stored in A-label (punycode) form, as served by DNS; this is
+	// the same name in U-labels, equal to Name for ASCII domains
+	UnicodeName string `gorm:"default:''" json:"unicode_name"`
+
+	// Set when the domain belongs to an organization; access then follows
+	// organization membership instead of UserID
+	OrganizationID *uint         `gorm:"index" json:"organization_id"`
+	Organization   *Organization `json:"organization,omitempty"`
+
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	ExpiresAt time.Time `json:"expires_at"`
@@ -47,7 +57,22 @@ type Domain struct {
 	TechCountry string `gorm:"default:''" json:"tech_country"`
 	
 	// Status
-	Status string `gorm:"default:'active'" json:"status"` // active, expired, suspended
+	Status string `gorm:"default:'active'" json:"status"` // active, grace, redemption, pending_delete, suspended
+
+	// When the lifecycle worker last changed Status
+	StatusChangedAt *time.Time `json:"status_changed_at,omitempty"`
+
+	// EPP status codes set on the domain, such as clientTransferProhibited
+	// or serverHold; the lifecycle stage is kept in Status
+	EPPStatuses StringList `gorm:"column:epp_statuses" json:"epp_statuses"`
+
+	// Renew automatically by the TLD's default term when ExpiresAt passes
+	AutoRenew bool `gorm:"default:false" json:"auto_renew"`
+
+	// Hash of the auth code another user needs to request a transfer; the
+	// code is single-use and expires
+	AuthCodeHash      string     `gorm:"default:''" json:"-"`
+	AuthCodeExpiresAt *time.Time `json:"auth_code_expires_at,omitempty"`
 	
 	// Relations
 	Records []Record `json:"records,omitempty"`
@@ -63,6 +88,29 @@ type Record struct {
 	Prio      int       `gorm:"default:0" json:"prio"`
 	Disabled  bool      `gorm:"default:false" json:"disabled"`
 	CreatedAt time.Time `json:"created_at"`
+
+	// Optional validity window, enforced by the scheduler
+	ActivateAt *time.Time `gorm:"index" json:"activate_at,omitempty"` // Record stays disabled until this time
+	ExpireAt   *time.Time `gorm:"index" json:"expire_at,omitempty"`   // Record is removed at this time
+
+	// Free-text notes and key/value labels for finding records
+	Comment string      `gorm:"default:''" json:"comment"`
+	Tags    []RecordTag `gorm:"constraint:OnDelete:CASCADE" json:"tags"`
+
+	// U-label form of an internationalised Name, filled in for API responses
+	UnicodeName string `gorm:"-" json:"unicode_name,omitempty"`
+
+	// Set while the record is disabled only because its domain is not being
+	// served (past grace); such records are enabled again on restore
+	Held bool `gorm:"default:false" json:"held"`
+}
+
+// RecordTag is a key/value label attached to a record
+type RecordTag struct {
+	ID       uint   `gorm:"primaryKey" json:"-"`
+	RecordID uint   `gorm:"not null;uniqueIndex:idx_record_tags_record_key" json:"-"`
+	Key      string `gorm:"not null;uniqueIndex:idx_record_tags_record_key;index" json:"key"`
+	Value    string `gorm:"default:''" json:"value"`
 }
 
 // RegistrarConfig stores global registrar settings
@@ -80,4 +128,29 @@ type RegistrarConfig struct {
 	NameServer2       string `json:"nameserver2"`
 	DefaultTTL        int    `gorm:"default:3600" json:"default_ttl"`
 	DefaultExpiry     int    `gorm:"default:365" json:"default_expiry_days"` // Days until expiry
+	RequireAdmin2FA   bool   `gorm:"column:require_admin_2fa;default:false" json:"require_admin_2fa"` // Admins must enrol in TOTP to log in
+	RegistrationMode  string `gorm:"default:open" json:"registration_mode"` // 'open', 'invite' (invitation required) or 'closed'
+	RequireApproval   bool   `gorm:"default:false" json:"require_approval"` // Self-registered users wait for admin approval
+	RedactWhois       bool   `gorm:"default:false" json:"redact_whois"` // Public WHOIS hides contact details
+
+	// Domain lifecycle after ExpiresAt, in days: still served during grace,
+	// then offline but restorable during redemption, then deleted once
+	// pending delete ends
+	GracePeriod         int `gorm:"default:30" json:"grace_period_days"`
+	RedemptionPeriod    int `gorm:"default:30" json:"redemption_period_days"`
+	PendingDeletePeriod int `gorm:"default:5" json:"pending_delete_period_days"`
+
+	// Longest total registration, in days from today, that renewals may reach
+	MaxRegistration int `gorm:"default:3650" json:"max_registration_days"`
+
+	// Expiry reminders: days before ExpiresAt at which to send them, and the
+	// channels to send them through (email, webhook)
+	ReminderDays          StringList `gorm:"default:'30,7,1'" json:"reminder_days"`
+	ReminderChannels      StringList `gorm:"default:'email'" json:"reminder_channels"`
+	ReminderWebhookURL    string     `gorm:"default:''" json:"reminder_webhook_url"`
+	ReminderWebhookSecret string     `gorm:"default:''" json:"-"` // Signs webhook bodies (X-LocalDNS-Signature)
+
+	// Days the owner has to approve or reject a transfer before it is
+	// approved automatically
+	TransferWindow int `gorm:"default:5" json:"transfer_window_days"`
 }
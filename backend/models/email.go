@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// EmailTemplate overrides the built-in text of a transactional email.
+// Subject and Body are Go text/template strings.
+type EmailTemplate struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"uniqueIndex;not null" json:"name"` // verify_email, password_reset, invitation, account_approved, expiry_reminder
+	Subject   string    `gorm:"not null" json:"subject"`
+	Body      string    `gorm:"type:text;not null" json:"body"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UserToken is a single-use, time-limited token sent to a user by email.
+// Only the hash is stored.
+type UserToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	Purpose   string     `gorm:"not null;index" json:"purpose"` // verify_email, password_reset
+	TokenHash string     `gorm:"not null;uniqueIndex" json:"-"`
+	Email     string     `gorm:"default:''" json:"email"` // address the token was sent to
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
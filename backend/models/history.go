@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+)
+
+// JSONText is a string column holding a JSON document. It is emitted as raw
+// JSON in API responses instead of an escaped string.
+type JSONText string
+
+func (j JSONText) MarshalJSON() ([]byte, error) {
+	if j == "" {
+		return []byte("null"), nil
+	}
+	return []byte(j), nil
+}
+
+// DomainHistory is an append-only, versioned log of changes to a domain's zone.
+// Before/After hold JSON snapshots of the record; either is empty when the
+// record did not exist on that side of the change.
+type DomainHistory struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	DomainID  uint      `gorm:"not null;index;uniqueIndex:idx_domain_histories_version" json:"domain_id"`
+	Version   uint      `gorm:"not null;uniqueIndex:idx_domain_histories_version" json:"version"` // Per-domain sequence number
+	RecordID  uint      `gorm:"index" json:"record_id"`                                           // 0 for domain-level events
+	Action    string    `gorm:"not null" json:"action"`                                           // create, update, delete
+	ActorID   uint      `json:"actor_id"`                                                         // 0 for system changes
+	Comment   string    `gorm:"default:''" json:"comment"`                                        // e.g. "rollback to ..."
+	Before    JSONText  `gorm:"type:text" json:"before"`
+	After     JSONText  `gorm:"type:text" json:"after"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
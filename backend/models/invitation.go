@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Invitation lets someone register while self-registration is restricted.
+// The account created from it gets the preset role and domain quota.
+// Only the hash of the token is stored.
+type Invitation struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	TokenHash   string     `gorm:"not null;uniqueIndex" json:"-"`
+	Email       string     `gorm:"not null" json:"email"`
+	Role        string     `gorm:"default:user" json:"role"`
+	DomainQuota int        `gorm:"default:0" json:"domain_quota"` // 0 means unlimited
+	CreatedBy   uint       `gorm:"index" json:"created_by"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	AcceptedAt  *time.Time `json:"accepted_at"`
+	AcceptedBy  *uint      `json:"accepted_by"` // user created from the invitation
+	CreatedAt   time.Time  `json:"created_at"`
+}
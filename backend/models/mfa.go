@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// RecoveryCode is a single-use code that can replace a TOTP code when the
+// user has lost their authenticator. Only the hash is stored.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"not null;uniqueIndex" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Organization is a team that can own domains, so access does not depend on
+// a single user account
+type Organization struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"uniqueIndex;not null" json:"name"`
+	Description string    `gorm:"default:''" json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// OrganizationMember gives a user a role in an organization:
+// 'owner' (manage members and domains), 'editor' (change records) or 'viewer'
+type OrganizationMember struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	OrganizationID uint      `gorm:"not null;uniqueIndex:idx_org_member" json:"organization_id"`
+	UserID         uint      `gorm:"not null;uniqueIndex:idx_org_member;index" json:"user_id"`
+	Role           string    `gorm:"not null;default:viewer" json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+}
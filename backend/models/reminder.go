@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// ReminderDelivery logs an expiry reminder sent for a domain through one
+// channel. The unique index keeps each reminder for a given expiry from
+// being sent twice; renewing the domain changes ExpiresAt and so starts a
+// new round of reminders.
+type ReminderDelivery struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	DomainID   uint      `gorm:"not null;uniqueIndex:idx_reminder_deliveries_key" json:"domain_id"`
+	DomainName string    `gorm:"not null" json:"domain_name"`
+	ExpiresAt  time.Time `gorm:"not null;uniqueIndex:idx_reminder_deliveries_key" json:"expires_at"`
+	DaysBefore int       `gorm:"not null;uniqueIndex:idx_reminder_deliveries_key" json:"days_before"`
+	Channel    string    `gorm:"not null;uniqueIndex:idx_reminder_deliveries_key" json:"channel"` // email, webhook
+	Recipient  string    `gorm:"default:''" json:"recipient"`
+	Status     string    `gorm:"default:'pending';index" json:"status"` // pending, sent, failed, skipped
+	Error      string    `gorm:"default:''" json:"error"`
+	Attempts   int       `gorm:"default:0" json:"attempts"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
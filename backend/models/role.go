@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// Role is a named set of permissions. Users reference a role by name through
+// User.Role; built-in roles are seeded at startup and cannot be deleted.
+type Role struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Name        string     `gorm:"uniqueIndex;not null" json:"name"`
+	Description string     `gorm:"default:''" json:"description"`
+	Permissions StringList `json:"permissions"`
+	BuiltIn     bool       `gorm:"default:false" json:"built_in"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Grant gives a user permissions on a single domain. When Name is a
+// subdomain rather than the domain itself, the grant only covers records at
+// or below that name.
+type Grant struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	DomainID    uint       `gorm:"not null;index" json:"domain_id"`
+	Name        string     `gorm:"not null" json:"name"`
+	Permissions StringList `json:"permissions"`
+	CreatedBy   uint       `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
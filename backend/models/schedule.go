@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// ScheduledChange is a set of record changes applied to a domain at RunAt
+type ScheduledChange struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	DomainID    uint       `gorm:"not null;index" json:"domain_id"`
+	CreatedBy   uint       `json:"created_by"`
+	Description string     `gorm:"default:''" json:"description"`
+	RunAt       time.Time  `gorm:"not null;index" json:"run_at"`
+	Status      string     `gorm:"default:'pending';index" json:"status"` // pending, running, applied, failed, cancelled
+	Changes     JSONText   `gorm:"type:text;not null" json:"changes"`     // []RecordChange
+	Result      string     `gorm:"default:''" json:"result"`
+	AppliedAt   *time.Time `json:"applied_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// RecordChange is a single operation within a ScheduledChange
+type RecordChange struct {
+	Action   string `json:"action"` // create, update, delete
+	RecordID uint   `json:"record_id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Content  string `json:"content,omitempty"`
+	TTL      int    `json:"ttl,omitempty"`
+	Prio     *int   `json:"prio,omitempty"` // left unchanged on update when nil
+}
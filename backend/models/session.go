@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// Session is a server-side login session. Access tokens carry its ID in the
+// "sid" claim; refresh tokens are stored hashed and rotated on every use.
+type Session struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	UserID       uint       `gorm:"not null;index" json:"user_id"`
+	RefreshHash  string     `gorm:"uniqueIndex;not null" json:"-"`
+	PreviousHash string     `gorm:"index;default:''" json:"-"` // Last rotated-out refresh token, for reuse detection
+	UserAgent    string     `gorm:"default:''" json:"user_agent"`
+	IP           string     `gorm:"default:''" json:"ip"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   time.Time  `json:"last_used_at"`
+	ExpiresAt    time.Time  `gorm:"index" json:"expires_at"` // Refresh token expiry
+	RevokedAt    *time.Time `json:"revoked_at"`
+
+	// Set on impersonation sessions: the admin acting as UserID. These
+	// sessions have no usable refresh token and end at ExpiresAt.
+	ImpersonatorID *uint `gorm:"index" json:"impersonator_id,omitempty"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// RecordTemplate is an admin-defined set of records that can be applied to a
+// domain. Record fields may contain {{variable}} placeholders; {{domain}} is
+// always available and expands to the domain name.
+type RecordTemplate struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"uniqueIndex;not null" json:"name"`
+	Description string    `gorm:"default:''" json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	Records   []TemplateRecord `gorm:"foreignKey:TemplateID;constraint:OnDelete:CASCADE" json:"records"`
+	Variables []string         `gorm:"-" json:"variables"` // Placeholders used by the records
+}
+
+// TemplateRecord is a parameterised record within a RecordTemplate
+type TemplateRecord struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	TemplateID uint   `gorm:"not null;index" json:"template_id"`
+	Name       string `gorm:"not null" json:"name"`
+	Type       string `gorm:"not null" json:"type"`
+	Content    string `gorm:"not null" json:"content"`
+	TTL        int    `gorm:"default:360" json:"ttl"`
+	Prio       int    `gorm:"default:0" json:"prio"`
+}
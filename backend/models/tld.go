@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+)
+
+// TLD is a suffix under which domains may be registered, such as "lan" or
+// "corp.internal". Domains are registered exactly one label below it.
+type TLD struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Name          string    `gorm:"uniqueIndex;not null" json:"name"`
+	Description   string    `gorm:"default:''" json:"description"`
+	DefaultExpiry int       `gorm:"default:0" json:"default_expiry_days"` // 0 uses the registrar default
+	MinTerm       int       `gorm:"default:0" json:"min_term_days"`       // Shortest registration or renewal, 0 for no minimum
+	MaxTerm       int       `gorm:"default:0" json:"max_term_days"`       // Longest registration or renewal, 0 for no maximum
+	Enabled       bool      `json:"enabled"`                              // Disabled TLDs accept no new registrations
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// ReservedName keeps a name from being registered. Name is either a single
+// label, reserved under every TLD, or a full domain name. Reserved names can
+// still be registered by users with domain.create_any; blocked names cannot
+// be registered at all.
+type ReservedName struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"uniqueIndex;not null" json:"name"`
+	Reason    string    `gorm:"default:''" json:"reason"`
+	Blocked   bool      `gorm:"default:false" json:"blocked"`
+	CreatedBy uint      `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// DomainTransfer is a request by a user to take over a domain with its auth
+// code. The current owner approves or rejects it before ExpiresAt; after
+// that it is approved automatically.
+type DomainTransfer struct {
+	ID                 uint       `gorm:"primaryKey" json:"id"`
+	DomainID           uint       `gorm:"not null;index" json:"domain_id"`
+	DomainName         string     `gorm:"not null" json:"domain_name"`
+	FromUserID         uint       `gorm:"not null;index" json:"from_user_id"`
+	FromOrganizationID *uint      `json:"from_organization_id"`
+	ToUserID           uint       `gorm:"not null;index" json:"to_user_id"`
+	Status             string     `gorm:"default:'pending';index" json:"status"` // pending, approved, auto_approved, rejected, cancelled
+	UpdateContacts     bool       `gorm:"default:false" json:"update_contacts"`  // Replace the registrant contact with the new owner's
+	Reason             string     `gorm:"default:''" json:"reason"`
+	ExpiresAt          time.Time  `json:"expires_at"` // Approved automatically at this time
+	ResolvedBy         uint       `json:"resolved_by"`
+	ResolvedAt         *time.Time `json:"resolved_at"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
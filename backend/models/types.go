@@ -0,0 +1,49 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// StringList is a list of strings stored as a single comma-separated text
+// column and emitted as a JSON array
+type StringList []string
+
+func (StringList) GormDataType() string {
+	return "text"
+}
+
+func (l StringList) Value() (driver.Value, error) {
+	return strings.Join(l, ","), nil
+}
+
+func (l *StringList) Scan(value interface{}) error {
+	var s string
+	switch v := value.(type) {
+	case nil:
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", value)
+	}
+	*l = StringList{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// Contains reports whether the list holds item
+func (l StringList) Contains(item string) bool {
+	for _, v := range l {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
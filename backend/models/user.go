@@ -8,8 +8,29 @@ type User struct {
 	ID           uint      `gorm:"primaryKey" json:"id"`
 	Username     string    `gorm:"uniqueIndex;not null" json:"username"`
 	PasswordHash string    `gorm:"not null" json:"-"`
-	Role         string    `gorm:"default:user" json:"role"` // 'admin' or 'user'
+	Role         string    `gorm:"default:user" json:"role"` // name of a Role: 'admin', 'user' or a custom role
 	CreatedAt    time.Time `json:"created_at"`
+
+	// Self-registered accounts stay 'pending' until an admin approves them
+	Status      string `gorm:"default:active;index" json:"status"` // 'active', 'pending' or 'rejected'
+	DomainQuota int    `gorm:"default:0" json:"domain_quota"`      // maximum number of domains, 0 means unlimited
+
+	// External identity (users provisioned by single sign-on have no local password)
+	AuthSource string `gorm:"default:local" json:"auth_source"` // 'local' or 'oidc'
+	ExternalID string `gorm:"index;default:''" json:"-"`        // subject at the identity provider
+
+	// Two-factor authentication (TOTP). The secret is set during enrolment and
+	// only takes effect once the user has confirmed a code.
+	TOTPSecret   string `gorm:"default:''" json:"-"`
+	TOTPEnabled  bool   `gorm:"default:false" json:"totp_enabled"`
+	TOTPLastStep int64  `gorm:"default:0" json:"-"` // last accepted time step, to reject replays
+
+	// Set once the contact email has been confirmed through a verification link
+	EmailVerified bool `gorm:"default:false" json:"email_verified"`
+
+	// Brute-force protection
+	FailedLogins int        `gorm:"default:0" json:"failed_logins"` // consecutive failures since the last login
+	LockedUntil  *time.Time `json:"locked_until"`
 	
 	// Contact Info (used for domain WHOIS data)
 	ContactName    string `gorm:"default:''" json:"contact_name"`
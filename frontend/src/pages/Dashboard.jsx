@@ -12,6 +12,29 @@ api.interceptors.request.use((config) => {
     return config;
 });
 
+// Access tokens are short-lived: on 401, rotate the refresh token once and retry
+api.interceptors.response.use(undefined, async (error) => {
+    const original = error.config;
+    const refreshToken = localStorage.getItem('refresh_token');
+    if (error.response?.status !== 401 || original._retried || !refreshToken) {
+        return Promise.reject(error);
+    }
+    original._retried = true;
+    try {
+        const res = await axios.post('/api/token/refresh', { refresh_token: refreshToken });
+        localStorage.setItem('token', res.data.token);
+        localStorage.setItem('refresh_token', res.data.refresh_token);
+        localStorage.setItem('user', JSON.stringify(res.data.user));
+        return api(original);
+    } catch (refreshError) {
+        localStorage.removeItem('token');
+        localStorage.removeItem('refresh_token');
+        localStorage.removeItem('user');
+        window.location.href = '/';
+        return Promise.reject(refreshError);
+    }
+});
+
 // DNS Record Types with examples
 const RECORD_TYPES = [
     { value: 'A', label: 'A (IPv4)', placeholder: '192.168.1.100' },
@@ -29,12 +52,16 @@ const DOMAIN_EXAMPLES = ['myserver.lan', 'homelab.local', 'nas.home', 'printer.i
 export default function Dashboard() {
     const [domains, setDomains] = useState([]);
     const [newDomain, setNewDomain] = useState('');
+    const [organizations, setOrganizations] = useState([]);
+    const [newDomainOrg, setNewDomainOrg] = useState('');
     const [loading, setLoading] = useState(true);
     const [expandedDomain, setExpandedDomain] = useState(null);
     const [domainRecords, setDomainRecords] = useState({});
     const [activeTab, setActiveTab] = useState('domains');
     const [users, setUsers] = useState([]);
     const [registrarConfig, setRegistrarConfig] = useState({});
+    const [roles, setRoles] = useState([]);
+    const [tlds, setTlds] = useState([]);
 
     // Forms
     const [newRecord, setNewRecord] = useState({ name: '', type: 'A', content: '', ttl: 3600, prio: 0 });
@@ -49,9 +76,12 @@ export default function Dashboard() {
 
     useEffect(() => {
         fetchDomains();
+        api.get('/api/organizations').then(res => setOrganizations(res.data || [])).catch(() => {});
+        api.get('/api/tlds').then(res => setTlds((res.data || []).filter(t => t.enabled))).catch(() => {});
         if (isAdmin) {
             fetchUsers();
             fetchConfig();
+            api.get('/api/roles').then(res => setRoles(res.data || [])).catch(() => {});
         }
     }, []);
 
@@ -103,7 +133,7 @@ export default function Dashboard() {
     const handleCreateDomain = async (e) => {
         e.preventDefault();
         try {
-            await api.post('/api/domains', { name: newDomain });
+            await api.post('/api/domains', { name: newDomain, organization_id: newDomainOrg ? Number(newDomainOrg) : undefined });
             setNewDomain('');
             fetchDomains();
         } catch (error) {
@@ -121,6 +151,46 @@ export default function Dashboard() {
         }
     };
 
+    const handleRestoreDomain = async (domainId) => {
+        if (!confirm('Restore this domain for a new term?')) return;
+        try {
+            await api.post(`/api/domains/${domainId}/restore`);
+            fetchDomains();
+        } catch (error) {
+            alert(error.response?.data?.error || 'Failed to restore domain');
+        }
+    };
+
+    const handleRenewDomain = async (domainId) => {
+        const years = prompt('Renew for how many years?', '1');
+        if (!years) return;
+        try {
+            await api.post(`/api/domains/${domainId}/renew`, { years: parseInt(years, 10) });
+            fetchDomains();
+        } catch (error) {
+            alert(error.response?.data?.error || 'Failed to renew domain');
+        }
+    };
+
+    const handleToggleAutoRenew = async (domain) => {
+        try {
+            await api.put(`/api/domains/${domain.id}/auto-renew`, { auto_renew: !domain.auto_renew });
+            fetchDomains();
+        } catch (error) {
+            alert(error.response?.data?.error || 'Failed to update auto-renew');
+        }
+    };
+
+    const handleAuthCode = async (domainId) => {
+        if (!confirm('Create a new transfer auth code? Any previous code stops working.')) return;
+        try {
+            const response = await api.post(`/api/domains/${domainId}/auth-code`);
+            prompt('Auth code (shown only once):', response.data.auth_code);
+        } catch (error) {
+            alert(error.response?.data?.error || 'Failed to create auth code');
+        }
+    };
+
     const handleAddRecord = async (e, domainId) => {
         e.preventDefault();
         try {
@@ -192,6 +262,26 @@ export default function Dashboard() {
         }
     };
 
+    const handleApproveUser = async (userId, approve) => {
+        try {
+            await api.post(`/api/users/${userId}/${approve ? 'approve' : 'reject'}`);
+            fetchUsers();
+        } catch (error) {
+            alert('Failed to update registration: ' + (error.response?.data?.error || error.message));
+        }
+    };
+
+    const handleInviteUser = async () => {
+        const email = prompt('Email address to invite:');
+        if (!email) return;
+        try {
+            const res = await api.post('/api/invitations', { email });
+            prompt(res.data.email_sent ? 'Invitation sent. Registration link:' : 'Could not send the email. Pass on this registration link:', res.data.link);
+        } catch (error) {
+            alert('Failed to invite user: ' + (error.response?.data?.error || error.message));
+        }
+    };
+
     const handleUpdateConfig = async (e) => {
         e.preventDefault();
         try {
@@ -212,8 +302,14 @@ export default function Dashboard() {
         }
     };
 
-    const handleLogout = () => {
+    const handleLogout = async () => {
+        try {
+            await api.post('/api/logout');
+        } catch (err) {
+            // Session may already be gone; clear local state regardless
+        }
         localStorage.removeItem('token');
+        localStorage.removeItem('refresh_token');
         localStorage.removeItem('user');
         window.location.href = '/';
     };
@@ -263,7 +359,7 @@ export default function Dashboard() {
                         <div className="bg-white shadow sm:rounded-lg p-6 mb-6">
                             <h2 className="text-lg font-medium mb-2">Register New Domain</h2>
                             <p className="text-sm text-gray-500 mb-4">
-                                Use local TLDs: <code className="bg-gray-100 px-1">.lan</code>, <code className="bg-gray-100 px-1">.local</code>, <code className="bg-gray-100 px-1">.home</code>, <code className="bg-gray-100 px-1">.internal</code>
+                                Use local TLDs: {tlds.map((t, i) => <span key={t.id}>{i > 0 && ', '}<code className="bg-gray-100 px-1">.{t.name}</code></span>)}
                             </p>
                             <form className="flex gap-4" onSubmit={handleCreateDomain}>
                                 <input
@@ -274,6 +370,12 @@ export default function Dashboard() {
                                     onChange={(e) => setNewDomain(e.target.value)}
                                     required
                                 />
+                                {organizations.length > 0 && (
+                                    <select className="border rounded px-3 py-2" value={newDomainOrg} onChange={(e) => setNewDomainOrg(e.target.value)}>
+                                        <option value="">Personal</option>
+                                        {organizations.filter(o => isAdmin || o.role !== 'viewer').map(o => <option key={o.id} value={o.id}>{o.name}</option>)}
+                                    </select>
+                                )}
                                 <button className="bg-green-600 text-white px-4 py-2 rounded hover:bg-green-700">Register</button>
                             </form>
                         </div>
@@ -289,8 +391,10 @@ export default function Dashboard() {
                                         <div className="flex justify-between items-center">
                                             <div className="cursor-pointer flex-1" onClick={() => toggleDomain(domain.id)}>
                                                 <p className="text-lg font-medium text-blue-600">
-                                                    {domain.name}
-                                                    {domain.user && <span className="ml-2 text-xs bg-gray-200 text-gray-700 px-2 py-1 rounded">Owner: {domain.user.username}</span>}
+                                                    {domain.unicode_name && domain.unicode_name !== domain.name ? <>{domain.unicode_name} <span className="text-sm text-gray-500 font-mono">({domain.name})</span></> : domain.name}
+                                                    {domain.organization
+                                                        ? <span className="ml-2 text-xs bg-indigo-100 text-indigo-800 px-2 py-1 rounded">Organization: {domain.organization.name}</span>
+                                                        : domain.user && <span className="ml-2 text-xs bg-gray-200 text-gray-700 px-2 py-1 rounded">Owner: {domain.user.username}</span>}
                                                     <span className={`ml-2 text-xs px-2 py-1 rounded ${domain.status === 'active' ? 'bg-green-100 text-green-800' : 'bg-red-100 text-red-800'}`}>{domain.status || 'active'}</span>
                                                 </p>
                                                 <p className="text-sm text-gray-500">Created: {new Date(domain.created_at).toLocaleDateString()}</p>
@@ -300,6 +404,10 @@ export default function Dashboard() {
                                                 <button onClick={() => toggleDomain(domain.id)} className="text-blue-600 hover:text-blue-800 text-sm">
                                                     {expandedDomain === domain.id ? '▲ Collapse' : '▼ DNS'}
                                                 </button>
+                                                {['active', 'expired', 'grace'].includes(domain.status) && <button onClick={() => handleRenewDomain(domain.id)} className="text-green-600 hover:text-green-800 text-sm">Renew</button>}
+                                                <button onClick={() => handleToggleAutoRenew(domain)} className="text-gray-600 hover:text-gray-800 text-sm">{domain.auto_renew ? 'Auto-renew: on' : 'Auto-renew: off'}</button>
+                                                {(domain.status === 'grace' || domain.status === 'redemption') && <button onClick={() => handleRestoreDomain(domain.id)} className="text-green-600 hover:text-green-800 text-sm">Restore</button>}
+                                                {domain.status === 'active' && <button onClick={() => handleAuthCode(domain.id)} className="text-gray-600 hover:text-gray-800 text-sm">Auth code</button>}
                                                 <button onClick={() => handleDeleteDomain(domain.id)} className="text-red-600 hover:text-red-800 text-sm">Delete</button>
                                             </div>
                                         </div>
@@ -342,7 +450,7 @@ export default function Dashboard() {
                                                                         </>
                                                                     ) : (
                                                                         <>
-                                                                            <td className="px-2 py-1 font-mono">{record.name}</td>
+                                                                            <td className="px-2 py-1 font-mono">{record.unicode_name ? <span title={record.name}>{record.unicode_name}</span> : record.name}</td>
                                                                             <td className="px-2 py-1"><span className="bg-blue-100 text-blue-800 px-1 rounded">{record.type}</span></td>
                                                                             <td className="px-2 py-1 font-mono text-xs">{record.content}</td>
                                                                             <td className="px-2 py-1">{record.ttl}s</td>
@@ -374,7 +482,10 @@ export default function Dashboard() {
                                 <h3 className="font-medium">User Management ({users.length})</h3>
                                 <p className="text-sm text-gray-500">User contact info is used for domain WHOIS data</p>
                             </div>
-                            <button onClick={() => setEditingUser({ role: 'user', username: '', password: '' })} className="bg-blue-600 text-white px-3 py-1 rounded text-sm hover:bg-blue-700">Add User</button>
+                            <div className="flex gap-2">
+                                <button onClick={handleInviteUser} className="bg-gray-200 text-gray-800 px-3 py-1 rounded text-sm hover:bg-gray-300">Invite</button>
+                                <button onClick={() => setEditingUser({ role: 'user', username: '', password: '' })} className="bg-blue-600 text-white px-3 py-1 rounded text-sm hover:bg-blue-700">Add User</button>
+                            </div>
                         </div>
                         <table className="w-full">
                             <thead className="bg-gray-50">
@@ -382,6 +493,7 @@ export default function Dashboard() {
                                     <th className="text-left px-4 py-2">ID</th>
                                     <th className="text-left px-4 py-2">Username</th>
                                     <th className="text-left px-4 py-2">Role</th>
+                                    <th className="text-left px-4 py-2">Status</th>
                                     <th className="text-left px-4 py-2">Contact Name</th>
                                     <th className="text-left px-4 py-2">Contact Email</th>
                                     <th className="text-left px-4 py-2">Actions</th>
@@ -393,9 +505,12 @@ export default function Dashboard() {
                                         <td className="px-4 py-2">{u.id}</td>
                                         <td className="px-4 py-2 font-medium">{u.username}</td>
                                         <td className="px-4 py-2"><span className={`px-2 py-0.5 rounded text-xs ${u.role === 'admin' ? 'bg-purple-100 text-purple-800' : 'bg-gray-100 text-gray-800'}`}>{u.role}</span></td>
+                                        <td className="px-4 py-2"><span className={`px-2 py-0.5 rounded text-xs ${u.status === 'pending' ? 'bg-yellow-100 text-yellow-800' : u.status === 'rejected' ? 'bg-red-100 text-red-800' : 'bg-green-100 text-green-800'}`}>{u.status || 'active'}</span></td>
                                         <td className="px-4 py-2 text-sm">{u.contact_name || <span className="text-gray-400">Not set</span>}</td>
                                         <td className="px-4 py-2 text-sm">{u.contact_email || <span className="text-gray-400">Not set</span>}</td>
                                         <td className="px-4 py-2 flex gap-2">
+                                            {u.status && u.status !== 'active' && <button onClick={() => handleApproveUser(u.id, true)} className="text-green-600 text-sm">Approve</button>}
+                                            {u.status === 'pending' && <button onClick={() => handleApproveUser(u.id, false)} className="text-red-600 text-sm">Reject</button>}
                                             <button onClick={() => setEditingUser({ ...u })} className="text-blue-600 text-sm">Edit</button>
                                             {u.id !== user.id && <button onClick={() => handleDeleteUser(u.id)} className="text-red-600 text-sm">Delete</button>}
                                         </td>
@@ -424,6 +539,9 @@ export default function Dashboard() {
                             <div><label className="block text-sm font-medium text-gray-700">Nameserver 2</label><input className="mt-1 block w-full border rounded px-3 py-2" value={registrarConfig.nameserver2 || ''} onChange={e => setRegistrarConfig({ ...registrarConfig, nameserver2: e.target.value })} /></div>
                             <div><label className="block text-sm font-medium text-gray-700">Default TTL (seconds)</label><input type="number" className="mt-1 block w-full border rounded px-3 py-2" value={registrarConfig.default_ttl || 3600} onChange={e => setRegistrarConfig({ ...registrarConfig, default_ttl: parseInt(e.target.value) })} /></div>
                             <div><label className="block text-sm font-medium text-gray-700">Default Expiry (days)</label><input type="number" className="mt-1 block w-full border rounded px-3 py-2" value={registrarConfig.default_expiry_days || 365} onChange={e => setRegistrarConfig({ ...registrarConfig, default_expiry_days: parseInt(e.target.value) })} /></div>
+                            <div><label className="block text-sm font-medium text-gray-700">Self-Registration</label><select className="mt-1 block w-full border rounded px-3 py-2" value={registrarConfig.registration_mode || 'open'} onChange={e => setRegistrarConfig({ ...registrarConfig, registration_mode: e.target.value })}><option value="open">Open</option><option value="invite">Invitation only</option><option value="closed">Disabled</option></select></div>
+                            <div className="flex items-end"><label className="flex items-center gap-2 text-sm font-medium text-gray-700 py-2"><input type="checkbox" checked={!!registrarConfig.require_approval} onChange={e => setRegistrarConfig({ ...registrarConfig, require_approval: e.target.checked })} />New registrations need admin approval</label></div>
+                            <div className="flex items-end"><label className="flex items-center gap-2 text-sm font-medium text-gray-700 py-2"><input type="checkbox" checked={!!registrarConfig.redact_whois} onChange={e => setRegistrarConfig({ ...registrarConfig, redact_whois: e.target.checked })} />Hide contact details in public WHOIS</label></div>
                             <div className="col-span-2"><button type="submit" className="bg-blue-600 text-white px-6 py-2 rounded hover:bg-blue-700">Save Configuration</button></div>
                         </form>
                     </div>
@@ -505,7 +623,8 @@ export default function Dashboard() {
                             <h3 className="font-medium text-blue-600 mb-2">Account Info</h3>
                             <div className="grid grid-cols-2 gap-4 mb-4">
                                 <div><label className="block text-sm font-medium text-gray-700">Username</label><input className="mt-1 block w-full border rounded px-3 py-2" value={editingUser.username || ''} onChange={e => setEditingUser({ ...editingUser, username: e.target.value })} required /></div>
-                                <div><label className="block text-sm font-medium text-gray-700">Role</label><select className="mt-1 block w-full border rounded px-3 py-2" value={editingUser.role || 'user'} onChange={e => setEditingUser({ ...editingUser, role: e.target.value })}><option value="user">user</option><option value="admin">admin</option></select></div>
+                                <div><label className="block text-sm font-medium text-gray-700">Role</label><select className="mt-1 block w-full border rounded px-3 py-2" value={editingUser.role || 'user'} onChange={e => setEditingUser({ ...editingUser, role: e.target.value })}>{(roles.length ? roles.map(r => r.name) : ['user', 'admin']).map(name => <option key={name} value={name}>{name}</option>)}</select></div>
+                                <div><label className="block text-sm font-medium text-gray-700">Domain Quota (0 = unlimited)</label><input type="number" min="0" className="mt-1 block w-full border rounded px-3 py-2" value={editingUser.domain_quota || 0} onChange={e => setEditingUser({ ...editingUser, domain_quota: parseInt(e.target.value) || 0 })} /></div>
                                 {!editingUser.id && (
                                     <div className="col-span-2"><label className="block text-sm font-medium text-gray-700">Password</label><input type="password" className="mt-1 block w-full border rounded px-3 py-2" value={editingUser.password || ''} onChange={e => setEditingUser({ ...editingUser, password: e.target.value })} required /></div>
                                 )}
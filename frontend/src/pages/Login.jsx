@@ -1,13 +1,63 @@
-import React, { useState } from 'react';
+import React, { useEffect, useState } from 'react';
 import axios from 'axios';
-import { useNavigate } from 'react-router-dom';
+import { useLocation, useNavigate } from 'react-router-dom';
 
 export default function Login() {
-    const [isLogin, setIsLogin] = useState(true);
+    // Invitation links open /register?token=...
+    const [invite] = useState(() => window.location.pathname === '/register' ? new URLSearchParams(window.location.search).get('token') : null);
+    const [policy, setPolicy] = useState({ mode: 'open' });
+    const [isLogin, setIsLogin] = useState(!invite);
     const [username, setUsername] = useState('');
     const [password, setPassword] = useState('');
+    const [email, setEmail] = useState('');
     const [error, setError] = useState('');
+    const [sso, setSso] = useState(null);
     const navigate = useNavigate();
+    const location = useLocation();
+
+    useEffect(() => {
+        axios.get('/api/auth/oidc')
+            .then(res => { if (res.data.enabled) setSso(res.data); })
+            .catch(() => {});
+        axios.get('/api/register', { params: invite ? { invite } : {} })
+            .then(res => {
+                setPolicy(res.data);
+                if (res.data.invitation?.valid) setEmail(res.data.invitation.email);
+                if (invite && !res.data.invitation?.valid) setError('This invitation is invalid or has expired.');
+            })
+            .catch(() => {});
+    }, []);
+
+    // Second login step for accounts with two-factor authentication
+    const [mfa, setMfa] = useState(null); // { token, enroll, secret, uri }
+    const [code, setCode] = useState('');
+    const [recoveryCodes, setRecoveryCodes] = useState(null);
+
+    const finishLogin = (data) => {
+        localStorage.setItem('token', data.token);
+        localStorage.setItem('refresh_token', data.refresh_token);
+        localStorage.setItem('user', JSON.stringify(data.user));
+        navigate('/dashboard');
+        window.location.reload(); // Simple refresh to update auth state
+    };
+
+    // Password and single sign-on logins may both ask for a second factor
+    const handleLoginResponse = async (data) => {
+        if (data.mfa_enrollment_required) {
+            const setup = await axios.post('/api/login/mfa/setup', { mfa_token: data.mfa_token });
+            setMfa({ token: data.mfa_token, enroll: true, secret: setup.data.secret, uri: setup.data.provisioning_uri });
+        } else if (data.mfa_token) {
+            setMfa({ token: data.mfa_token, enroll: false });
+        } else {
+            finishLogin(data);
+        }
+    };
+
+    useEffect(() => {
+        if (location.state?.mfa_token) {
+            handleLoginResponse(location.state).catch(err => setError(err.response?.data?.error || 'An error occurred'));
+        }
+    }, []);
 
     const handleSubmit = async (e) => {
         e.preventDefault();
@@ -15,21 +65,86 @@ export default function Login() {
         const endpoint = isLogin ? '/api/login' : '/api/register';
 
         try {
-            const res = await axios.post(endpoint, { username, password });
+            const res = await axios.post(endpoint, isLogin ? { username, password } : { username, password, email, invite_token: invite || undefined });
             if (isLogin) {
-                localStorage.setItem('token', res.data.token);
-                localStorage.setItem('user', JSON.stringify(res.data.user));
-                navigate('/dashboard');
-                window.location.reload(); // Simple refresh to update auth state
+                await handleLoginResponse(res.data);
             } else {
                 setIsLogin(true); // Switch to login after register
-                setError('Registration successful! Please login.');
+                setError(res.data.message);
+            }
+        } catch (err) {
+            setError(err.response?.data?.error || 'An error occurred');
+        }
+    };
+
+    const handleMfaSubmit = async (e) => {
+        e.preventDefault();
+        setError('');
+        try {
+            const res = await axios.post('/api/login/mfa', { mfa_token: mfa.token, code });
+            if (res.data.recovery_codes) {
+                // Show the recovery codes once before continuing
+                setRecoveryCodes({ codes: res.data.recovery_codes, session: res.data });
+            } else {
+                finishLogin(res.data);
             }
         } catch (err) {
             setError(err.response?.data?.error || 'An error occurred');
         }
     };
 
+    if (recoveryCodes) {
+        return (
+            <div className="flex items-center justify-center min-h-screen bg-gray-100">
+                <div className="px-8 py-6 mt-4 text-left bg-white shadow-lg rounded-lg w-96">
+                    <h3 className="text-2xl font-bold text-center">Recovery Codes</h3>
+                    <p className="mt-4 text-sm text-gray-600">Store these codes somewhere safe. Each can be used once if you lose your authenticator. They will not be shown again.</p>
+                    <ul className="mt-4 grid grid-cols-2 gap-2 font-mono text-sm">
+                        {recoveryCodes.codes.map(c => <li key={c}>{c}</li>)}
+                    </ul>
+                    <button className="px-6 py-2 mt-6 text-white bg-blue-600 rounded-lg hover:bg-blue-900" onClick={() => finishLogin(recoveryCodes.session)}>
+                        Continue
+                    </button>
+                </div>
+            </div>
+        );
+    }
+
+    if (mfa) {
+        return (
+            <div className="flex items-center justify-center min-h-screen bg-gray-100">
+                <div className="px-8 py-6 mt-4 text-left bg-white shadow-lg rounded-lg w-96">
+                    <h3 className="text-2xl font-bold text-center">Two-Factor Authentication</h3>
+                    {mfa.enroll && (
+                        <div className="mt-4 text-sm text-gray-600">
+                            <p>Two-factor authentication is required for your account. Add this key to your authenticator app, then enter the code it shows.</p>
+                            <p className="mt-2 font-mono break-all text-gray-900">{mfa.secret}</p>
+                            <a href={mfa.uri} className="text-blue-600 hover:underline">Open in authenticator app</a>
+                        </div>
+                    )}
+                    <form onSubmit={handleMfaSubmit}>
+                        <div className="mt-4">
+                            <label className="block" htmlFor="code">{mfa.enroll ? 'Authenticator code' : 'Authenticator or recovery code'}</label>
+                            <input
+                                type="text"
+                                autoComplete="one-time-code"
+                                placeholder="123456"
+                                className="w-full px-4 py-2 mt-2 border rounded-md focus:outline-none focus:ring-1 focus:ring-blue-600"
+                                value={code}
+                                onChange={(e) => setCode(e.target.value)}
+                                required
+                            />
+                        </div>
+                        {error && <p className="text-red-500 text-sm mt-2">{error}</p>}
+                        <button className="px-6 py-2 mt-4 text-white bg-blue-600 rounded-lg hover:bg-blue-900">
+                            Verify
+                        </button>
+                    </form>
+                </div>
+            </div>
+        );
+    }
+
     return (
         <div className="flex items-center justify-center min-h-screen bg-gray-100">
             <div className="px-8 py-6 mt-4 text-left bg-white shadow-lg rounded-lg w-96">
@@ -48,6 +163,19 @@ export default function Login() {
                             required
                         />
                     </div>
+                    {!isLogin && (
+                        <div className="mt-4">
+                            <label className="block" htmlFor="email">Email</label>
+                            <input
+                                type="email"
+                                placeholder="you@example.lan"
+                                className="w-full px-4 py-2 mt-2 border rounded-md focus:outline-none focus:ring-1 focus:ring-blue-600"
+                                value={email}
+                                onChange={(e) => setEmail(e.target.value)}
+                                required
+                            />
+                        </div>
+                    )}
                     <div className="mt-4">
                         <label className="block" htmlFor="password">Password</label>
                         <input
@@ -64,11 +192,23 @@ export default function Login() {
                         <button className="px-6 py-2 mt-4 text-white bg-blue-600 rounded-lg hover:bg-blue-900">
                             {isLogin ? 'Login' : 'Register'}
                         </button>
-                        <a href="#" className="text-sm text-blue-600 hover:underline" onClick={(e) => { e.preventDefault(); setIsLogin(!isLogin); setError(''); }}>
-                            {isLogin ? 'Need an account?' : 'Have an account?'}
-                        </a>
+                        {(!isLogin || invite || policy.mode === 'open') && (
+                            <a href="#" className="text-sm text-blue-600 hover:underline" onClick={(e) => { e.preventDefault(); setIsLogin(!isLogin); setError(''); }}>
+                                {isLogin ? 'Need an account?' : 'Have an account?'}
+                            </a>
+                        )}
                     </div>
+                    {isLogin && (
+                        <a href="/reset-password" className="block mt-4 text-sm text-blue-600 hover:underline">Forgot password?</a>
+                    )}
                 </form>
+                {isLogin && sso && (
+                    <div className="mt-6 pt-4 border-t">
+                        <a href="/api/auth/oidc/login" className="block w-full px-6 py-2 text-center text-blue-600 border border-blue-600 rounded-lg hover:bg-blue-50">
+                            Sign in with {sso.name}
+                        </a>
+                    </div>
+                )}
             </div>
         </div>
     );
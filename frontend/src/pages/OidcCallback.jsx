@@ -0,0 +1,52 @@
+import React, { useEffect, useState } from 'react';
+import { useNavigate } from 'react-router-dom';
+
+// Landing page for the single sign-on flow. The backend passes the session
+// tokens (or an error) in the URL fragment.
+export default function OidcCallback() {
+    const [error, setError] = useState('');
+    const navigate = useNavigate();
+
+    useEffect(() => {
+        const params = new URLSearchParams(window.location.hash.substring(1));
+        window.history.replaceState(null, '', window.location.pathname);
+
+        // Accounts with two-factor authentication finish on the login page
+        if (params.get('mfa_token')) {
+            navigate('/', {
+                replace: true,
+                state: { mfa_token: params.get('mfa_token'), mfa_enrollment_required: params.get('mfa_enrollment_required') === 'true' },
+            });
+            return;
+        }
+
+        if (params.get('error') || !params.get('token')) {
+            setError(params.get('error') || 'Single sign-on failed');
+            return;
+        }
+
+        localStorage.setItem('token', params.get('token'));
+        localStorage.setItem('refresh_token', params.get('refresh_token'));
+        localStorage.setItem('user', JSON.stringify({
+            id: Number(params.get('user_id')),
+            username: params.get('username'),
+            role: params.get('role'),
+        }));
+        window.location.replace('/dashboard'); // Full reload to update auth state
+    }, []);
+
+    return (
+        <div className="flex items-center justify-center min-h-screen bg-gray-100">
+            <div className="px-8 py-6 mt-4 text-left bg-white shadow-lg rounded-lg w-96">
+                {error ? (
+                    <>
+                        <p className="text-red-500 text-sm">{error}</p>
+                        <a href="/" className="text-sm text-blue-600 hover:underline">Back to login</a>
+                    </>
+                ) : (
+                    <p className="text-gray-600">Signing you in...</p>
+                )}
+            </div>
+        </div>
+    );
+}
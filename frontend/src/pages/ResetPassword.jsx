@@ -0,0 +1,75 @@
+import React, { useState } from 'react';
+import axios from 'axios';
+
+// Requests a password reset link, or sets a new password when opened from
+// the link in the reset email (?token=...)
+export default function ResetPassword() {
+    const [token] = useState(() => new URLSearchParams(window.location.search).get('token'));
+    const [login, setLogin] = useState('');
+    const [password, setPassword] = useState('');
+    const [confirm, setConfirm] = useState('');
+    const [message, setMessage] = useState('');
+    const [error, setError] = useState('');
+
+    const handleRequest = async (e) => {
+        e.preventDefault();
+        setError('');
+        try {
+            const res = await axios.post('/api/password-reset', { login });
+            setMessage(res.data.message);
+        } catch (err) {
+            setError(err.response?.data?.error || 'An error occurred');
+        }
+    };
+
+    const handleReset = async (e) => {
+        e.preventDefault();
+        setError('');
+        if (password !== confirm) {
+            setError('Passwords do not match');
+            return;
+        }
+        try {
+            const res = await axios.post('/api/password-reset/confirm', { token, new_password: password });
+            setMessage(res.data.message);
+        } catch (err) {
+            setError(err.response?.data?.error || 'An error occurred');
+        }
+    };
+
+    const inputClass = "w-full px-4 py-2 mt-2 border rounded-md focus:outline-none focus:ring-1 focus:ring-blue-600";
+
+    return (
+        <div className="flex items-center justify-center min-h-screen bg-gray-100">
+            <div className="px-8 py-6 mt-4 text-left bg-white shadow-lg rounded-lg w-96">
+                <h3 className="text-2xl font-bold text-center">Reset Password</h3>
+                {message ? (
+                    <p className="mt-4 text-gray-600">{message}</p>
+                ) : token ? (
+                    <form onSubmit={handleReset}>
+                        <div className="mt-4">
+                            <label className="block" htmlFor="password">New password</label>
+                            <input type="password" className={inputClass} value={password} onChange={(e) => setPassword(e.target.value)} minLength={8} required />
+                        </div>
+                        <div className="mt-4">
+                            <label className="block" htmlFor="confirm">Confirm new password</label>
+                            <input type="password" className={inputClass} value={confirm} onChange={(e) => setConfirm(e.target.value)} minLength={8} required />
+                        </div>
+                        {error && <p className="text-red-500 text-sm mt-2">{error}</p>}
+                        <button className="px-6 py-2 mt-4 text-white bg-blue-600 rounded-lg hover:bg-blue-900">Set password</button>
+                    </form>
+                ) : (
+                    <form onSubmit={handleRequest}>
+                        <div className="mt-4">
+                            <label className="block" htmlFor="login">Username or email</label>
+                            <input type="text" className={inputClass} value={login} onChange={(e) => setLogin(e.target.value)} required />
+                        </div>
+                        {error && <p className="text-red-500 text-sm mt-2">{error}</p>}
+                        <button className="px-6 py-2 mt-4 text-white bg-blue-600 rounded-lg hover:bg-blue-900">Send reset link</button>
+                    </form>
+                )}
+                <a href="/" className="block mt-4 text-sm text-blue-600 hover:underline">Back to login</a>
+            </div>
+        </div>
+    );
+}
@@ -0,0 +1,29 @@
+import React, { useEffect, useState } from 'react';
+import axios from 'axios';
+
+// Landing page for the link in the verification email
+export default function VerifyEmail() {
+    const [status, setStatus] = useState('Verifying your email address...');
+    const [error, setError] = useState('');
+
+    useEffect(() => {
+        const token = new URLSearchParams(window.location.search).get('token');
+        window.history.replaceState(null, '', window.location.pathname);
+        if (!token) {
+            setError('Verification link is incomplete');
+            return;
+        }
+        axios.post('/api/verify-email', { token })
+            .then(res => setStatus(res.data.message || 'Email address verified'))
+            .catch(err => setError(err.response?.data?.error || 'Verification failed'));
+    }, []);
+
+    return (
+        <div className="flex items-center justify-center min-h-screen bg-gray-100">
+            <div className="px-8 py-6 mt-4 text-left bg-white shadow-lg rounded-lg w-96">
+                {error ? <p className="text-red-500 text-sm">{error}</p> : <p className="text-gray-600">{status}</p>}
+                <a href="/" className="block mt-4 text-sm text-blue-600 hover:underline">Back to login</a>
+            </div>
+        </div>
+    );
+}
@@ -11,8 +11,11 @@ CREATE TABLE users (
     id SERIAL PRIMARY KEY,
     username TEXT UNIQUE NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
-    role VARCHAR(20) DEFAULT 'user', -- 'admin' or 'user'
+    role VARCHAR(20) DEFAULT 'user', -- name of a role in roles ('admin', 'user' or a custom role)
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    -- Registration approval and limits
+    status VARCHAR(20) DEFAULT 'active', -- 'active', 'pending' or 'rejected'
+    domain_quota BIGINT DEFAULT 0, -- 0 means unlimited
     -- Contact Info (used for domain WHOIS data)
     contact_name TEXT DEFAULT '',
     contact_org TEXT DEFAULT '',
@@ -22,14 +25,52 @@ CREATE TABLE users (
     contact_city TEXT DEFAULT '',
     contact_state TEXT DEFAULT '',
     contact_zip TEXT DEFAULT '',
-    contact_country TEXT DEFAULT ''
+    contact_country TEXT DEFAULT '',
+    -- External identity for single sign-on users
+    auth_source VARCHAR(20) DEFAULT 'local',
+    external_id VARCHAR(255) DEFAULT '',
+    -- Two-factor authentication (TOTP)
+    totp_secret TEXT DEFAULT '',
+    totp_enabled BOOLEAN DEFAULT FALSE,
+    totp_last_step BIGINT DEFAULT 0,
+    -- Brute-force protection
+    failed_logins BIGINT DEFAULT 0,
+    locked_until TIMESTAMP,
+    email_verified BOOLEAN DEFAULT FALSE
 );
 
+CREATE INDEX idx_users_external_id ON users(external_id);
+CREATE INDEX idx_users_status ON users(status);
+
+-- Organizations Table (teams that can own domains)
+CREATE TABLE organizations (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT UNIQUE NOT NULL,
+    description TEXT DEFAULT '',
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP
+);
+
+-- Organization Members Table (role: 'owner', 'editor' or 'viewer')
+CREATE TABLE organization_members (
+    id BIGSERIAL PRIMARY KEY,
+    organization_id BIGINT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL DEFAULT 'viewer',
+    created_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_org_member ON organization_members(organization_id, user_id);
+CREATE INDEX idx_organization_members_user_id ON organization_members(user_id);
+
 -- Domains Table
 CREATE TABLE domains (
     id SERIAL PRIMARY KEY,
-    name VARCHAR(255) UNIQUE NOT NULL,
+    name VARCHAR(255) UNIQUE NOT NULL, -- A-label (punycode) form, as served by DNS
+    unicode_name VARCHAR(255) DEFAULT '', -- U-label form of internationalised names
     user_id INTEGER NOT NULL REFERENCES users(id),
+    -- Set when the domain belongs to an organization
+    organization_id BIGINT REFERENCES organizations(id),
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     expires_at TIMESTAMP,
@@ -63,10 +104,17 @@ CREATE TABLE domains (
     tech_state VARCHAR(255) DEFAULT '',
     tech_zip VARCHAR(255) DEFAULT '',
     tech_country VARCHAR(255) DEFAULT '',
-    -- Status: active, expired, suspended
-    status VARCHAR(20) DEFAULT 'active'
+    -- Status: active, grace, redemption, pending_delete, suspended
+    status VARCHAR(20) DEFAULT 'active',
+    status_changed_at TIMESTAMP, -- set by the lifecycle worker
+    auto_renew BOOLEAN DEFAULT FALSE, -- renewed by the lifecycle worker on expiry
+    auth_code_hash VARCHAR(64) DEFAULT '', -- single-use code for transfers, hashed
+    auth_code_expires_at TIMESTAMP,
+    epp_statuses TEXT DEFAULT '' -- comma-separated EPP status codes, e.g. clientTransferProhibited
 );
 
+CREATE INDEX idx_domains_organization_id ON domains(organization_id);
+
 -- Records Table
 CREATE TABLE records (
     id SERIAL PRIMARY KEY,
@@ -77,11 +125,298 @@ CREATE TABLE records (
     ttl INTEGER DEFAULT 360,
     prio INTEGER DEFAULT 0,
     disabled BOOLEAN DEFAULT FALSE,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    -- Optional validity window, enforced by the backend scheduler
+    activate_at TIMESTAMP, -- record stays disabled until this time
+    expire_at TIMESTAMP, -- record is removed at this time
+    comment TEXT DEFAULT '', -- free-text notes
+    held BOOLEAN DEFAULT FALSE -- disabled only because the domain is past grace
 );
 
 -- Index for domain_id in records table (for faster lookups)
 CREATE INDEX idx_records_domain_id ON records(domain_id);
+CREATE INDEX idx_records_activate_at ON records(activate_at);
+CREATE INDEX idx_records_expire_at ON records(expire_at);
+
+-- Record Tags Table (key/value labels on records)
+CREATE TABLE record_tags (
+    id BIGSERIAL PRIMARY KEY,
+    record_id BIGINT NOT NULL REFERENCES records(id) ON DELETE CASCADE,
+    key TEXT NOT NULL,
+    value TEXT DEFAULT ''
+);
+
+CREATE UNIQUE INDEX idx_record_tags_record_key ON record_tags(record_id, key);
+CREATE INDEX idx_record_tags_key ON record_tags(key);
+
+-- Sessions Table (server-side login sessions with rotating refresh tokens)
+CREATE TABLE sessions (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    refresh_hash TEXT UNIQUE NOT NULL, -- SHA-256 of the current refresh token
+    previous_hash TEXT DEFAULT '', -- last rotated-out refresh token, for reuse detection
+    user_agent TEXT DEFAULT '',
+    ip TEXT DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    impersonator_id BIGINT -- admin acting as user_id; such sessions cannot be refreshed
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX idx_sessions_impersonator_id ON sessions(impersonator_id);
+CREATE INDEX idx_sessions_previous_hash ON sessions(previous_hash);
+CREATE INDEX idx_sessions_expires_at ON sessions(expires_at);
+
+-- Domain History Table (append-only, versioned log of zone changes)
+CREATE TABLE domain_histories (
+    id BIGSERIAL PRIMARY KEY,
+    domain_id BIGINT NOT NULL,
+    version BIGINT NOT NULL,
+    record_id BIGINT DEFAULT 0, -- 0 for domain-level events
+    action TEXT NOT NULL, -- create, update, delete
+    actor_id BIGINT DEFAULT 0, -- 0 for system changes
+    comment TEXT DEFAULT '',
+    before TEXT, -- JSON snapshot before the change
+    after TEXT, -- JSON snapshot after the change
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_domain_histories_domain_id ON domain_histories(domain_id);
+CREATE UNIQUE INDEX idx_domain_histories_version ON domain_histories(domain_id, version);
+CREATE INDEX idx_domain_histories_record_id ON domain_histories(record_id);
+CREATE INDEX idx_domain_histories_created_at ON domain_histories(created_at);
+
+-- Audit Log Table (append-only record of administrative actions)
+CREATE TABLE audit_logs (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    actor_id BIGINT DEFAULT 0, -- 0 for system or anonymous
+    action TEXT NOT NULL, -- e.g. user.create, config.update
+    target_type TEXT DEFAULT '',
+    target_id TEXT DEFAULT '',
+    ip TEXT DEFAULT '',
+    diff TEXT, -- JSON object of changed fields
+    impersonator_id BIGINT DEFAULT 0 -- admin acting as actor_id, 0 when not impersonating
+);
+
+CREATE INDEX idx_audit_logs_created_at ON audit_logs(created_at);
+CREATE INDEX idx_audit_logs_actor_id ON audit_logs(actor_id);
+CREATE INDEX idx_audit_logs_action ON audit_logs(action);
+CREATE INDEX idx_audit_logs_target_type ON audit_logs(target_type);
+CREATE INDEX idx_audit_logs_impersonator_id ON audit_logs(impersonator_id);
+
+-- Scheduled Changes Table (record change sets applied at run_at)
+CREATE TABLE scheduled_changes (
+    id BIGSERIAL PRIMARY KEY,
+    domain_id BIGINT NOT NULL,
+    created_by BIGINT,
+    description TEXT DEFAULT '',
+    run_at TIMESTAMP NOT NULL,
+    status TEXT DEFAULT 'pending', -- pending, running, applied, failed, cancelled
+    changes TEXT NOT NULL, -- JSON array of record changes
+    result TEXT DEFAULT '',
+    applied_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_scheduled_changes_domain_id ON scheduled_changes(domain_id);
+CREATE INDEX idx_scheduled_changes_run_at ON scheduled_changes(run_at);
+CREATE INDEX idx_scheduled_changes_status ON scheduled_changes(status);
+
+-- Record Templates Tables (admin-defined, parameterised record sets)
+CREATE TABLE record_templates (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT UNIQUE NOT NULL,
+    description TEXT DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE template_records (
+    id BIGSERIAL PRIMARY KEY,
+    template_id BIGINT NOT NULL REFERENCES record_templates(id) ON DELETE CASCADE,
+    name TEXT NOT NULL, -- may contain {{variable}} placeholders
+    type TEXT NOT NULL,
+    content TEXT NOT NULL,
+    ttl BIGINT DEFAULT 360,
+    prio BIGINT DEFAULT 0
+);
+
+CREATE INDEX idx_template_records_template_id ON template_records(template_id);
+
+-- API Keys Table
+CREATE TABLE api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash VARCHAR(64) NOT NULL UNIQUE,
+    scopes TEXT,
+    allowed_cidrs TEXT,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP
+);
+
+CREATE INDEX idx_api_keys_user_id ON api_keys(user_id);
+
+-- Recovery Codes Table (single-use two-factor backup codes, hashed)
+CREATE TABLE recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL UNIQUE,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes(user_id);
+
+-- User Tokens Table (single-use email verification and password reset tokens, hashed)
+CREATE TABLE user_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(32) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    email TEXT DEFAULT '',
+    expires_at TIMESTAMP,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP
+);
+
+CREATE INDEX idx_user_tokens_user_id ON user_tokens(user_id);
+CREATE INDEX idx_user_tokens_purpose ON user_tokens(purpose);
+
+-- Email Templates Table (admin overrides of the built-in email texts)
+CREATE TABLE email_templates (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(64) NOT NULL UNIQUE,
+    subject TEXT NOT NULL,
+    body TEXT NOT NULL,
+    updated_at TIMESTAMP
+);
+
+-- Invitations Table (single-use registration links, hashed)
+CREATE TABLE invitations (
+    id BIGSERIAL PRIMARY KEY,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    email TEXT NOT NULL,
+    role VARCHAR(20) DEFAULT 'user',
+    domain_quota BIGINT DEFAULT 0,
+    created_by BIGINT,
+    expires_at TIMESTAMP,
+    accepted_at TIMESTAMP,
+    accepted_by BIGINT,
+    created_at TIMESTAMP
+);
+
+CREATE INDEX idx_invitations_created_by ON invitations(created_by);
+
+-- Roles Table (named permission sets; users reference them by name)
+CREATE TABLE roles (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(20) NOT NULL UNIQUE,
+    description TEXT DEFAULT '',
+    permissions TEXT,
+    built_in BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP
+);
+
+-- Grants Table (per-domain or per-subdomain permissions for a user)
+CREATE TABLE grants (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    domain_id BIGINT NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    permissions TEXT,
+    created_by BIGINT,
+    created_at TIMESTAMP
+);
+
+CREATE INDEX idx_grants_user_id ON grants(user_id);
+CREATE INDEX idx_grants_domain_id ON grants(domain_id);
+
+-- Delegations Table (subdomains handed to another user or organization)
+CREATE TABLE delegations (
+    id BIGSERIAL PRIMARY KEY,
+    domain_id BIGINT NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    user_id BIGINT REFERENCES users(id) ON DELETE CASCADE,
+    organization_id BIGINT REFERENCES organizations(id) ON DELETE CASCADE,
+    nameservers TEXT,
+    created_by BIGINT,
+    created_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_delegation_name ON delegations(domain_id, name);
+CREATE INDEX idx_delegations_user_id ON delegations(user_id);
+CREATE INDEX idx_delegations_organization_id ON delegations(organization_id);
+
+-- TLDs Table (suffixes open for registration; seeded with lan, test, local, home, internal)
+CREATE TABLE tlds (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT DEFAULT '',
+    default_expiry INTEGER DEFAULT 0, -- days, 0 uses the registrar default
+    min_term INTEGER DEFAULT 0, -- days, 0 for no minimum
+    max_term INTEGER DEFAULT 0, -- days, 0 for no maximum
+    enabled BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP
+);
+
+-- Reserved Names Table (labels or full domains that cannot be registered)
+CREATE TABLE reserved_names (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    reason TEXT DEFAULT '',
+    blocked BOOLEAN DEFAULT FALSE, -- blocked names are refused even to domain.create_any
+    created_by BIGINT,
+    created_at TIMESTAMP
+);
+
+-- Reminder Deliveries Table (log of expiry reminders; each is sent once per expiry)
+CREATE TABLE reminder_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    domain_id BIGINT NOT NULL,
+    domain_name TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    days_before BIGINT NOT NULL,
+    channel VARCHAR(20) NOT NULL, -- email, webhook
+    recipient TEXT DEFAULT '',
+    status VARCHAR(20) DEFAULT 'pending', -- pending, sent, failed, skipped
+    error TEXT DEFAULT '',
+    attempts BIGINT DEFAULT 0,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_reminder_deliveries_key ON reminder_deliveries(domain_id, expires_at, days_before, channel);
+CREATE INDEX idx_reminder_deliveries_status ON reminder_deliveries(status);
+
+-- Domain Transfers Table (requests to move a domain to another user)
+CREATE TABLE domain_transfers (
+    id BIGSERIAL PRIMARY KEY,
+    domain_id BIGINT NOT NULL,
+    domain_name TEXT NOT NULL,
+    from_user_id BIGINT NOT NULL,
+    from_organization_id BIGINT,
+    to_user_id BIGINT NOT NULL,
+    status VARCHAR(20) DEFAULT 'pending', -- pending, approved, auto_approved, rejected, cancelled
+    update_contacts BOOLEAN DEFAULT FALSE,
+    reason TEXT DEFAULT '',
+    expires_at TIMESTAMP, -- approved automatically at this time
+    resolved_by BIGINT,
+    resolved_at TIMESTAMP,
+    created_at TIMESTAMP
+);
+
+CREATE INDEX idx_domain_transfers_domain_id ON domain_transfers(domain_id);
+CREATE INDEX idx_domain_transfers_from_user_id ON domain_transfers(from_user_id);
+CREATE INDEX idx_domain_transfers_to_user_id ON domain_transfers(to_user_id);
+CREATE INDEX idx_domain_transfers_status ON domain_transfers(status);
 
 -- RegistrarConfig Table
 CREATE TABLE registrar_configs (
@@ -97,7 +432,24 @@ CREATE TABLE registrar_configs (
     name_server1 TEXT DEFAULT '',
     name_server2 TEXT DEFAULT '',
     default_ttl BIGINT DEFAULT 3600,
-    default_expiry BIGINT DEFAULT 365
+    default_expiry BIGINT DEFAULT 365,
+    require_admin_2fa BOOLEAN DEFAULT FALSE,
+    registration_mode VARCHAR(20) DEFAULT 'open',
+    require_approval BOOLEAN DEFAULT FALSE,
+    redact_whois BOOLEAN DEFAULT FALSE,
+    -- Domain lifecycle after expiry, in days
+    grace_period BIGINT DEFAULT 30,
+    redemption_period BIGINT DEFAULT 30,
+    pending_delete_period BIGINT DEFAULT 5,
+    -- Longest a registration may run from today, in days
+    max_registration BIGINT DEFAULT 3650,
+    -- Expiry reminders: days before expiry, channels (email, webhook)
+    reminder_days TEXT DEFAULT '30,7,1',
+    reminder_channels TEXT DEFAULT 'email',
+    reminder_webhook_url TEXT DEFAULT '',
+    reminder_webhook_secret TEXT DEFAULT '',
+    -- Days the owner has to answer a transfer before it is approved
+    transfer_window BIGINT DEFAULT 5
 );
 
 -- ============================================================================
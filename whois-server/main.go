@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"golang.org/x/net/idna"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -17,6 +18,7 @@ import (
 type Domain struct {
 	ID                uint `gorm:"primaryKey"`
 	Name              string
+	UnicodeName       string
 	UserID            uint
 	CreatedAt         time.Time
 	UpdatedAt         time.Time
@@ -49,6 +51,7 @@ type Domain struct {
 	TechZip           string
 	TechCountry       string
 	Status            string
+	EPPStatuses       string `gorm:"column:epp_statuses"` // comma-separated EPP status codes
 }
 
 type RegistrarConfig struct {
@@ -63,6 +66,7 @@ type RegistrarConfig struct {
 	WhoisServer       string
 	NameServer1       string
 	NameServer2       string
+	RedactWhois       bool
 }
 
 var db *gorm.DB
@@ -113,7 +117,11 @@ func handleConnection(conn net.Conn) {
 	if err != nil {
 		return
 	}
-	domainName := strings.TrimSpace(strings.ToLower(query))
+	domainName := strings.TrimSuffix(strings.TrimSpace(strings.ToLower(query)), ".")
+	// Unicode queries are looked up by their A-label form
+	if ascii, err := idna.Lookup.ToASCII(domainName); err == nil {
+		domainName = ascii
+	}
 	log.Printf("WHOIS query for: %s", domainName)
 	response := lookupDomain(domainName)
 	conn.Write([]byte(response))
@@ -126,10 +134,35 @@ func lookupDomain(domainName string) string {
 	}
 	var config RegistrarConfig
 	db.First(&config)
-	return formatWhoisResponse(domain, config)
+	if config.RedactWhois {
+		domain = redactContacts(domain)
+	}
+	return formatWhoisResponse(domain, config, eppStatusCodes(domain, transferPending(domain.ID)))
+}
+
+// transferPending reports whether a transfer of the domain awaits an answer
+func transferPending(domainID uint) bool {
+	var pending int64
+	db.Table("domain_transfers").Where("domain_id = ? AND status = ?", domainID, "pending").Count(&pending)
+	return pending > 0
 }
 
-func formatWhoisResponse(domain Domain, config RegistrarConfig) string {
+// redactContacts clears all contact fields so they print as REDACTED FOR PRIVACY
+func redactContacts(domain Domain) Domain {
+	return Domain{
+		ID:          domain.ID,
+		Name:        domain.Name,
+		UnicodeName: domain.UnicodeName,
+		UserID:      domain.UserID,
+		CreatedAt:   domain.CreatedAt,
+		UpdatedAt:   domain.UpdatedAt,
+		ExpiresAt:   domain.ExpiresAt,
+		Status:      domain.Status,
+		EPPStatuses: domain.EPPStatuses,
+	}
+}
+
+func formatWhoisResponse(domain Domain, config RegistrarConfig, statusCodes []string) string {
 	expiryDate := domain.ExpiresAt
 	if expiryDate.IsZero() {
 		expiryDate = domain.UpdatedAt.AddDate(1, 0, 0)
@@ -158,8 +191,14 @@ func formatWhoisResponse(domain Domain, config RegistrarConfig) string {
 	techZip := valueOrFallback(domain.TechZip, domain.RegistrantZip)
 	techCountry := valueOrFallback(domain.TechCountry, domain.RegistrantCountry)
 
+	// Internationalized names also show their U-label form
+	unicodeLine := ""
+	if domain.UnicodeName != "" && domain.UnicodeName != domain.Name {
+		unicodeLine = "Internationalized Domain Name: " + domain.UnicodeName + "\n"
+	}
+
 	return fmt.Sprintf(`Domain Name: %s
-Registry Domain ID: DOM-%d-LOCALDNS
+%sRegistry Domain ID: DOM-%d-LOCALDNS
 Registrar WHOIS Server: %s
 Registrar URL: %s
 Updated Date: %s
@@ -169,8 +208,7 @@ Registrar: %s
 Registrar IANA ID: %s
 Registrar Abuse Contact Email: %s
 Registrar Abuse Contact Phone: %s
-Domain Status: %s https://icann.org/epp#%s
-
+%s
 Registry Registrant ID: C%d-LOCALDNS
 Registrant Name: %s
 Registrant Organization: %s
@@ -222,6 +260,7 @@ For more information on WHOIS status codes, please visit https://icann.org/epp
 
 `,
 		strings.ToUpper(domain.Name),
+		unicodeLine,
 		domain.ID,
 		config.WhoisServer,
 		config.RegistrarURL,
@@ -232,8 +271,7 @@ For more information on WHOIS status codes, please visit https://icann.org/epp
 		valueOrDefault(config.RegistrarIANAID, "9999"),
 		valueOrDefault(config.AbuseContactEmail, config.RegistrarEmail),
 		valueOrDefault(config.AbuseContactPhone, config.RegistrarPhone),
-		valueOrDefault(domain.Status, "active"),
-		valueOrDefault(domain.Status, "active"),
+		domainStatusLines(statusCodes),
 		domain.ID,
 		valueOrDefault(domain.RegistrantName, "REDACTED FOR PRIVACY"),
 		valueOrDefault(domain.RegistrantOrg, "REDACTED FOR PRIVACY"),
@@ -279,6 +317,48 @@ NOTICE: This is a LOCAL DNS REGISTRAR for homelab/internal network use only.
 `, domain, time.Now().Format(time.RFC3339))
 }
 
+// eppStatusCodes returns the EPP status codes of a domain: its lifecycle
+// stage, the codes set on it and pendingTransfer, or "ok" when there are none
+func eppStatusCodes(domain Domain, pendingTransfer bool) []string {
+	var codes []string
+	switch domain.Status {
+	case "grace":
+		codes = append(codes, "autoRenewPeriod")
+	case "redemption":
+		codes = append(codes, "redemptionPeriod", "pendingDelete")
+	case "pending_delete":
+		codes = append(codes, "pendingDelete")
+	case "suspended":
+		codes = append(codes, "serverHold")
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		seen[code] = true
+	}
+	for _, code := range strings.Split(domain.EPPStatuses, ",") {
+		if code = strings.TrimSpace(code); code != "" && !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+	if pendingTransfer {
+		codes = append(codes, "pendingTransfer")
+	}
+	if len(codes) == 0 {
+		codes = append(codes, "ok")
+	}
+	return codes
+}
+
+// domainStatusLines prints one Domain Status line per EPP status code
+func domainStatusLines(codes []string) string {
+	var b strings.Builder
+	for _, code := range codes {
+		fmt.Fprintf(&b, "Domain Status: %s https://icann.org/epp#%s\n", code, code)
+	}
+	return b.String()
+}
+
 func valueOrDefault(val, def string) string {
 	if val == "" {
 		return def